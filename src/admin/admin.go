@@ -0,0 +1,205 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/flow"
+)
+
+// LoadError is a file that failed to load at startup, the process runs without it
+type LoadError struct {
+	Path  string    `json:"path"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+var loadErrors = struct {
+	mu     sync.Mutex
+	errors []LoadError
+}{}
+
+func RecordLoadError(path string, err error) {
+	loadErrors.mu.Lock()
+	defer loadErrors.mu.Unlock()
+
+	loadErrors.errors = append(loadErrors.errors, LoadError{
+		Path:  path,
+		Error: err.Error(),
+		Time:  time.Now(),
+	})
+}
+
+type groupView struct {
+	Group      string   `json:"group"`
+	Namespaces []string `json:"namespaces"`
+}
+
+type filterView struct {
+	Namespace string `json:"namespace,omitempty"`
+	Group     string `json:"group,omitempty"`
+	Path      string `json:"path"`
+	Source    string `json:"source"`
+}
+
+/*
+ * api serves the loaded configuration read-only
+ */
+
+type api struct {
+	namespaces *flow.Namespaces
+	filterRoot *flow.FilterRoot
+}
+
+// max size of a message explained
+const maxExplainSize = 1 << 20
+
+// Setup exposes the loaded namespaces, groups, filters and load errors under /admin
+func Setup(namespaces *flow.Namespaces, filterRoot *flow.FilterRoot) {
+	api := &api{
+		namespaces: namespaces,
+		filterRoot: filterRoot,
+	}
+
+	http.HandleFunc("GET /admin/namespaces", api.namespacesHandler)
+	http.HandleFunc("GET /admin/namespaces/{name}", api.namespaceHandler)
+	http.HandleFunc("GET /admin/groups", api.groupsHandler)
+	http.HandleFunc("GET /admin/groups/{name}", api.groupHandler)
+	http.HandleFunc("GET /admin/filters", api.filtersHandler)
+	http.HandleFunc("GET /admin/filters/{namespace}", api.filterHandler)
+	http.HandleFunc("GET /admin/errors", errorsHandler)
+	http.HandleFunc("POST /admin/explain", api.explainHandler)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		logrus.Errorf("admin encode: %+v", err)
+	}
+}
+
+func notFound(w http.ResponseWriter, what string, name string) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": what + " not found: " + name})
+}
+
+func (api *api) namespacesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, api.namespaces.All())
+}
+
+func (api *api) namespaceHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, exists := api.namespaces.Get(r.PathValue("name"))
+	if !exists {
+		notFound(w, "namespace", r.PathValue("name"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, namespace)
+}
+
+func newGroupView(group *flow.GroupNode) groupView {
+	view := groupView{Group: group.Name(), Namespaces: make([]string, 0)}
+	for _, leaf := range group.Children() {
+		view.Namespaces = append(view.Namespaces, leaf.Namespace)
+	}
+
+	return view
+}
+
+func (api *api) groupsHandler(w http.ResponseWriter, r *http.Request) {
+	groups := make([]groupView, 0)
+	for _, group := range api.filterRoot.Groups() {
+		groups = append(groups, newGroupView(group))
+	}
+
+	writeJSON(w, http.StatusOK, groups)
+}
+
+func (api *api) groupHandler(w http.ResponseWriter, r *http.Request) {
+	group := api.filterRoot.GetGroup(r.PathValue("name"))
+	if group == nil {
+		notFound(w, "group", r.PathValue("name"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newGroupView(group))
+}
+
+func (api *api) filtersHandler(w http.ResponseWriter, r *http.Request) {
+	path, source := api.filterRoot.GroupFilterSource()
+	filters := struct {
+		Groups     filterView   `json:"groups"`
+		Namespaces []filterView `json:"namespaces"`
+	}{
+		Groups:     filterView{Path: path, Source: source},
+		Namespaces: make([]filterView, 0),
+	}
+
+	for _, group := range api.filterRoot.Groups() {
+		for _, leaf := range group.Children() {
+			filters.Namespaces = append(filters.Namespaces, filterView{
+				Namespace: leaf.Namespace,
+				Group:     group.Name(),
+				Path:      leaf.Path,
+				Source:    leaf.Source,
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, filters)
+}
+
+// findLeaf is the filter of a namespace, with its group
+func findLeaf(filterRoot *flow.FilterRoot, namespace string) (*flow.LeafNode, string) {
+	for _, group := range filterRoot.Groups() {
+		for _, leaf := range group.Children() {
+			if leaf.Namespace == namespace {
+				return leaf, group.Name()
+			}
+		}
+	}
+
+	return nil, ""
+}
+
+func (api *api) filterHandler(w http.ResponseWriter, r *http.Request) {
+	leaf, group := findLeaf(api.filterRoot, r.PathValue("namespace"))
+	if leaf == nil {
+		notFound(w, "filter of namespace", r.PathValue("namespace"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, filterView{
+		Namespace: leaf.Namespace,
+		Group:     group,
+		Path:      leaf.Path,
+		Source:    leaf.Source,
+	})
+}
+
+// explainHandler runs the filters on the posted message, the live metrics are not updated
+func (api *api) explainHandler(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxExplainSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, flow.Explain(payload, api.namespaces, api.filterRoot))
+}
+
+func errorsHandler(w http.ResponseWriter, r *http.Request) {
+	loadErrors.mu.Lock()
+	errors := append([]LoadError{}, loadErrors.errors...)
+	loadErrors.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, errors)
+}
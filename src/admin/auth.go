@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type userKey struct{}
+
+/*
+ * Tokens maps the bearer tokens of the admin clients to their user names, the
+ * tokens file has a "user token" line per client, # starting a comment
+ */
+
+type Tokens map[string]string
+
+func LoadTokens(path string) (Tokens, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := make(Tokens)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a user and a token", path, n)
+		}
+		tokens[fields[1]] = fields[0]
+	}
+
+	return tokens, scanner.Err()
+}
+
+// user of the token, compared in constant time
+func (tokens Tokens) user(token string) (string, bool) {
+	var found string
+	for candidate, user := range tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			found = user
+		}
+	}
+
+	return found, len(found) > 0
+}
+
+// Authenticate rejects the requests without a known bearer token, the handler gets the user with User
+func (tokens Tokens) Authenticate(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		user, ok := tokens.user(token)
+		if !found || !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	}
+}
+
+func User(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
@@ -0,0 +1,329 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/prom"
+)
+
+// max size of a submitted namespace
+const maxSubmissionSize = 1 << 20
+
+// Submission is a namespace configuration (yaml) with its jq filter
+type Submission struct {
+	Config string `json:"config"`
+	Filter string `json:"filter"`
+}
+
+// AuditEntry is a change of a namespace by a user, before is empty on creation and after on deletion
+type AuditEntry struct {
+	Time      time.Time   `json:"time"`
+	User      string      `json:"user"`
+	Remote    string      `json:"remote"`
+	Action    string      `json:"action"`
+	Namespace string      `json:"namespace"`
+	Before    *Submission `json:"before,omitempty"`
+	After     *Submission `json:"after,omitempty"`
+}
+
+type WriteOptions struct {
+	Tokens        Tokens
+	AuditFile     string // audit entries are appended as json lines, and logged
+	NamespacesDir string
+	FiltersDir    string
+
+	// Compile compiles a namespace filter with the functions of the loaded filters
+	Compile func(source string) (*gojq.Code, error)
+
+	// outputs set up from the namespaces at startup
+	OTLP      bool // the otlp native histogram views
+	Publisher bool // the destination topics publisher
+	Alerts    bool // the alert engine, notified of the changes once it runs
+
+	AlertInterval time.Duration // the alert evaluation interval, the shortest anomaly interval
+}
+
+/*
+ * writeApi creates, replaces and deletes namespaces of the running process,
+ * persisting them in the namespaces and filters directories
+ */
+
+type writeApi struct {
+	options    WriteOptions
+	namespaces *flow.Namespaces
+	filterRoot *flow.FilterRoot
+
+	// one change at a time, from validation to persistence
+	mu sync.Mutex
+}
+
+// SetupWrite exposes the authenticated namespace changes under /admin/namespaces
+func SetupWrite(options WriteOptions, namespaces *flow.Namespaces, filterRoot *flow.FilterRoot) {
+	api := &writeApi{
+		options:    options,
+		namespaces: namespaces,
+		filterRoot: filterRoot,
+	}
+
+	api.register(http.DefaultServeMux)
+}
+
+func (api *writeApi) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /admin/namespaces", api.options.Tokens.Authenticate(api.createHandler))
+	mux.HandleFunc("PUT /admin/namespaces/{name}", api.options.Tokens.Authenticate(api.putHandler))
+	mux.HandleFunc("DELETE /admin/namespaces/{name}", api.options.Tokens.Authenticate(api.deleteHandler))
+}
+
+func badRequest(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+}
+
+func (api *writeApi) createHandler(w http.ResponseWriter, r *http.Request) {
+	api.write(w, r, "")
+}
+
+func (api *writeApi) putHandler(w http.ResponseWriter, r *http.Request) {
+	api.write(w, r, r.PathValue("name"))
+}
+
+// write creates the submitted namespace, or replaces it when name is given
+func (api *writeApi) write(w http.ResponseWriter, r *http.Request, name string) {
+	var submission Submission
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err := decoder.Decode(&submission); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	// validated under the lock, another change could register its metrics with other types
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	namespace, err := flow.ParseNamespace([]byte(submission.Config))
+	if err != nil {
+		badRequest(w, fmt.Errorf("namespace: %w", err))
+		return
+	}
+
+	if err := namespace.CheckRegistered(); err != nil {
+		badRequest(w, fmt.Errorf("namespace: %w, they only change after a restart", err))
+		return
+	}
+
+	// checked without a running engine too, the namespace would fail the next startup
+	for _, anomaly := range namespace.Anomalies {
+		if err := anomaly.CheckInterval(api.options.AlertInterval); err != nil {
+			badRequest(w, fmt.Errorf("namespace: %w", err))
+			return
+		}
+	}
+
+	if len(name) > 0 && namespace.Name != name {
+		badRequest(w, fmt.Errorf("namespace: name %q does not match %q", namespace.Name, name))
+		return
+	}
+
+	if filepath.Base(namespace.Name) != namespace.Name || namespace.Name == "." || namespace.Name == ".." {
+		badRequest(w, fmt.Errorf("namespace: name %q is not a file name", namespace.Name))
+		return
+	}
+
+	filter, err := api.options.Compile(submission.Filter)
+	if err != nil {
+		badRequest(w, fmt.Errorf("filter: %w", err))
+		return
+	}
+
+	previous, exists := api.namespaces.Get(namespace.Name)
+	if exists && len(name) == 0 {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "namespace already exists: " + namespace.Name})
+		return
+	}
+
+	namespace.Path = filepath.Join(api.options.NamespacesDir, namespace.Name+".yaml")
+	if exists && len(previous.Path) > 0 {
+		namespace.Path = previous.Path
+	}
+	filterPath := fmt.Sprintf("%s/%s.jq", api.options.FiltersDir, namespace.Name)
+
+	entry := AuditEntry{
+		Time:      time.Now(),
+		User:      User(r),
+		Remote:    r.RemoteAddr,
+		Action:    "create",
+		Namespace: namespace.Name,
+		After:     &submission,
+	}
+	if exists {
+		entry.Action = "update"
+		entry.Before = api.current(previous)
+	}
+
+	if err := writeFiles(map[string]string{namespace.Path: submission.Config, filterPath: submission.Filter}); err != nil {
+		logrus.Errorf("admin write %s: %+v", namespace.Name, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if exists {
+		previous.DeleteSeries()
+	}
+	namespace.Register()
+	api.namespaces.Put(namespace)
+	if api.filterRoot.SetLeaf(namespace.Group, &flow.LeafNode{
+		Filter:    filter,
+		Namespace: namespace.Name,
+		Path:      filterPath,
+		Source:    submission.Filter,
+	}) {
+		prom.MyBasePromMetrics.IncNumberGroups()
+	}
+	prom.MyBasePromMetrics.SetNumberNamespaces(api.namespaces.Len())
+
+	api.audit(entry)
+
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, map[string]any{"namespace": namespace, "warnings": api.warnings(namespace)})
+}
+
+func (api *writeApi) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	namespace, exists := api.namespaces.Get(r.PathValue("name"))
+	if !exists {
+		notFound(w, "namespace", r.PathValue("name"))
+		return
+	}
+
+	entry := AuditEntry{
+		Time:      time.Now(),
+		User:      User(r),
+		Remote:    r.RemoteAddr,
+		Action:    "delete",
+		Namespace: namespace.Name,
+		Before:    api.current(namespace),
+	}
+
+	api.filterRoot.RemoveLeaf(namespace.Name)
+	api.namespaces.Delete(namespace.Name)
+	namespace.DeleteSeries()
+	prom.MyBasePromMetrics.SetNumberNamespaces(api.namespaces.Len())
+
+	paths := []string{fmt.Sprintf("%s/%s.jq", api.options.FiltersDir, namespace.Name)}
+	if len(namespace.Path) > 0 {
+		paths = append(paths, namespace.Path)
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.Errorf("admin delete %s: %+v", namespace.Name, err)
+		}
+	}
+
+	api.audit(entry)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// current is the configuration and filter a change replaces, as on disk
+func (api *writeApi) current(namespace *flow.Namespace) *Submission {
+	var submission Submission
+	if buf, err := os.ReadFile(namespace.Path); err == nil {
+		submission.Config = string(buf)
+	}
+
+	if leaf, _ := findLeaf(api.filterRoot, namespace.Name); leaf != nil {
+		submission.Filter = leaf.Source
+	}
+
+	return &submission
+}
+
+// warnings are the parts of a namespace only applied at startup
+func (api *writeApi) warnings(namespace *flow.Namespace) []string {
+	warnings := make([]string, 0)
+	if !api.options.Alerts && (len(namespace.Alerts) > 0 || namespace.Absence != nil || len(namespace.Anomalies) > 0 || len(namespace.SLOs) > 0) {
+		warnings = append(warnings, "no alert engine runs, alerts, absence, anomalies and slos are evaluated after a restart")
+	}
+
+	if api.options.OTLP {
+		for _, metric := range namespace.Metrics {
+			if metric.Type == "histogram" && metric.NativeBucketFactor > 1 {
+				warnings = append(warnings, "native histograms are exported over otlp as exponential histograms after a restart")
+				break
+			}
+		}
+	}
+
+	if len(namespace.DestTopic) > 0 && !api.options.Publisher {
+		warnings = append(warnings, "no destination topics publisher runs, dest_topic is published after a restart")
+	}
+
+	return warnings
+}
+
+// writeFiles writes the files next to them first, so a failure leaves the previous files
+func writeFiles(files map[string]string) error {
+	temporary := make(map[string]string, len(files))
+	defer func() {
+		for _, tmp := range temporary {
+			os.Remove(tmp)
+		}
+	}()
+
+	for path, content := range files {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+			return err
+		}
+		temporary[path] = tmp
+	}
+
+	for path, tmp := range temporary {
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		delete(temporary, path)
+	}
+
+	return nil
+}
+
+func (api *writeApi) audit(entry AuditEntry) {
+	logrus.Infof("admin audit: %s %s namespace %s from %s", entry.User, entry.Action, entry.Namespace, entry.Remote)
+
+	if len(api.options.AuditFile) == 0 {
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logrus.Errorf("admin audit: %+v", err)
+		return
+	}
+
+	file, err := os.OpenFile(api.options.AuditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logrus.Errorf("admin audit: %+v", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		logrus.Errorf("admin audit: %+v", err)
+	}
+}
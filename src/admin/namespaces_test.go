@@ -0,0 +1,297 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itchyny/gojq"
+
+	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/prom"
+)
+
+func TestMain(m *testing.M) {
+	prom.SetupPrometheus(false)
+	os.Exit(m.Run())
+}
+
+func compileFilter(source string) (*gojq.Code, error) {
+	program, err := gojq.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return gojq.Compile(program)
+}
+
+// recordingWatcher records the changes as "previous metric -> new metric"
+type recordingWatcher struct {
+	flow.Output
+	changes []string
+}
+
+func (watcher *recordingWatcher) NamespaceChanged(previous *flow.Namespace, namespace *flow.Namespace) {
+	describe := func(namespace *flow.Namespace) string {
+		for name := range namespace.Metrics {
+			return name
+		}
+		return "none"
+	}
+
+	change := "nil -> "
+	if previous != nil {
+		change = describe(previous) + " -> "
+	}
+	if namespace != nil {
+		change += describe(namespace)
+	} else {
+		change += "nil"
+	}
+	watcher.changes = append(watcher.changes, change)
+}
+
+type writeTest struct {
+	t       *testing.T
+	options WriteOptions
+	catalog *flow.Namespaces
+	server  *httptest.Server
+}
+
+func newWriteTest(t *testing.T) *writeTest {
+	dir := t.TempDir()
+	options := WriteOptions{
+		Tokens:        Tokens{"secret": "alice"},
+		AuditFile:     filepath.Join(dir, "audit.log"),
+		NamespacesDir: filepath.Join(dir, "namespaces"),
+		FiltersDir:    filepath.Join(dir, "filters"),
+		Compile:       compileFilter,
+		AlertInterval: 15 * time.Second,
+	}
+	for _, dir := range []string{options.NamespacesDir, options.FiltersDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+
+	test := &writeTest{
+		t:       t,
+		options: options,
+		catalog: flow.NewNamespaces(nil),
+	}
+
+	api := &writeApi{
+		options:    options,
+		namespaces: test.catalog,
+		filterRoot: flow.NewFilterTree(nil, "", ""),
+	}
+	mux := http.NewServeMux()
+	api.register(mux)
+	test.server = httptest.NewServer(mux)
+	t.Cleanup(test.server.Close)
+
+	return test
+}
+
+// submission has a counter metric, then the extra config
+func submission(name string, metric string, extra ...string) string {
+	config := "namespace: " + name + "\ngroup: group\nservice: service\nmetrics:\n  " + metric + ":\n    type: counter\n    help: test\n" + strings.Join(extra, "")
+	buf, _ := json.Marshal(Submission{Config: config, Filter: "."})
+	return string(buf)
+}
+
+// do sends the request with the token, if any, and returns the status
+func (test *writeTest) do(method string, path string, body string, token string) int {
+	req, err := http.NewRequest(method, test.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		test.t.Fatalf("request: %v", err)
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		test.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	res.Body.Close()
+
+	return res.StatusCode
+}
+
+func (test *writeTest) read(path string) string {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		test.t.Errorf("read: %v", err)
+	}
+	return string(buf)
+}
+
+func (test *writeTest) audit() []AuditEntry {
+	file, err := os.Open(test.options.AuditFile)
+	if err != nil {
+		test.t.Fatalf("audit: %v", err)
+	}
+	defer file.Close()
+
+	entries := make([]AuditEntry, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			test.t.Fatalf("audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestWriteNamespace(t *testing.T) {
+	test := newWriteTest(t)
+	path := filepath.Join(test.options.NamespacesDir, "orders.yaml")
+	watcher := &recordingWatcher{}
+	test.catalog.Watch(watcher)
+
+	if status := test.do("POST", "/admin/namespaces", submission("orders", "admin_test_created"), "secret"); status != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", status)
+	}
+	if _, exists := test.catalog.Get("orders"); !exists {
+		t.Errorf("create: the namespace is not loaded")
+	}
+	if !strings.Contains(test.read(path), "admin_test_created") {
+		t.Errorf("create: the namespace is not persisted")
+	}
+
+	if status := test.do("POST", "/admin/namespaces", submission("orders", "admin_test_created"), "secret"); status != http.StatusConflict {
+		t.Errorf("create again: expected 409, got %d", status)
+	}
+
+	if status := test.do("PUT", "/admin/namespaces/orders", submission("orders", "admin_test_replaced"), "secret"); status != http.StatusOK {
+		t.Fatalf("replace: expected 200, got %d", status)
+	}
+	namespace, _ := test.catalog.Get("orders")
+	if _, exists := namespace.Metrics["admin_test_replaced"]; !exists {
+		t.Errorf("replace: the namespace is not replaced")
+	}
+	if !strings.Contains(test.read(path), "admin_test_replaced") {
+		t.Errorf("replace: the namespace is not persisted")
+	}
+
+	if status := test.do("DELETE", "/admin/namespaces/orders", "", "secret"); status != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d", status)
+	}
+	if _, exists := test.catalog.Get("orders"); exists {
+		t.Errorf("delete: the namespace is still loaded")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("delete: the namespace file is left")
+	}
+	if status := test.do("DELETE", "/admin/namespaces/orders", "", "secret"); status != http.StatusNotFound {
+		t.Errorf("delete again: expected 404, got %d", status)
+	}
+
+	expected := []string{"nil -> admin_test_created", "admin_test_created -> admin_test_replaced", "admin_test_replaced -> nil"}
+	if strings.Join(watcher.changes, "; ") != strings.Join(expected, "; ") {
+		t.Errorf("expected the outputs notified of %v, got %v", expected, watcher.changes)
+	}
+
+	// an audit line per change, with what it replaced
+	entries := test.audit()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 audit entries, got %d", len(entries))
+	}
+	for i, action := range []string{"create", "update", "delete"} {
+		entry := entries[i]
+		if entry.Action != action || entry.User != "alice" || entry.Namespace != "orders" {
+			t.Errorf("audit %d: expected alice %s orders, got %s %s %s", i, action, entry.User, entry.Action, entry.Namespace)
+		}
+	}
+	if entries[0].Before != nil || entries[0].After == nil {
+		t.Errorf("audit create: expected only the submission after")
+	}
+	if entries[1].Before == nil || !strings.Contains(entries[1].Before.Config, "admin_test_created") {
+		t.Errorf("audit update: expected the replaced config before")
+	}
+	if entries[2].Before == nil || entries[2].After != nil {
+		t.Errorf("audit delete: expected only the config before")
+	}
+}
+
+func TestWriteRejected(t *testing.T) {
+	test := newWriteTest(t)
+	// closed by the evaluations every 15s
+	anomaly := submission("orders", "admin_test_rejected", "anomalies:\n  - metric: admin_test_rejected\n    method: ewma\n    interval: 5s\n")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  string
+		status int
+	}{
+		{"no token", "POST", "/admin/namespaces", submission("orders", "admin_test_rejected"), "", http.StatusUnauthorized},
+		{"unknown token", "POST", "/admin/namespaces", submission("orders", "admin_test_rejected"), "guess", http.StatusUnauthorized},
+		{"delete without token", "DELETE", "/admin/namespaces/orders", "", "", http.StatusUnauthorized},
+		{"path name", "POST", "/admin/namespaces", submission("../x", "admin_test_rejected"), "secret", http.StatusBadRequest},
+		{"other name", "PUT", "/admin/namespaces/payments", submission("orders", "admin_test_rejected"), "secret", http.StatusBadRequest},
+		{"not json", "POST", "/admin/namespaces", "namespace: orders", "secret", http.StatusBadRequest},
+		{"anomaly interval", "POST", "/admin/namespaces", anomaly, "secret", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		if status := test.do(tt.method, tt.path, tt.body, tt.token); status != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.status, status)
+		}
+	}
+
+	if test.catalog.Len() != 0 {
+		t.Errorf("expected no namespace loaded, got %d", test.catalog.Len())
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(test.options.NamespacesDir), "x.yaml")); !os.IsNotExist(err) {
+		t.Errorf("the namespace was written outside of the namespaces directory")
+	}
+	if _, err := os.Stat(test.options.AuditFile); !os.IsNotExist(err) {
+		t.Errorf("the rejected changes were audited")
+	}
+}
+
+func TestWriteFilesFailure(t *testing.T) {
+	test := newWriteTest(t)
+	path := filepath.Join(test.options.NamespacesDir, "orders.yaml")
+
+	if status := test.do("POST", "/admin/namespaces", submission("orders", "admin_test_kept"), "secret"); status != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", status)
+	}
+	before := test.read(path)
+
+	// the temporary filter file cannot be written
+	if err := os.Mkdir(filepath.Join(test.options.FiltersDir, "orders.jq.tmp"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	if status := test.do("PUT", "/admin/namespaces/orders", submission("orders", "admin_test_lost"), "secret"); status != http.StatusInternalServerError {
+		t.Fatalf("replace: expected 500, got %d", status)
+	}
+
+	if after := test.read(path); after != before {
+		t.Errorf("expected the namespace file intact, got %q", after)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary namespace file removed")
+	}
+	namespace, _ := test.catalog.Get("orders")
+	if _, exists := namespace.Metrics["admin_test_kept"]; !exists {
+		t.Errorf("expected the loaded namespace kept")
+	}
+	if entries := test.audit(); len(entries) != 1 {
+		t.Errorf("expected only the creation audited, got %d entries", len(entries))
+	}
+}
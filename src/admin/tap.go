@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/flow"
+)
+
+const tapHeartbeat = 15 * time.Second
+
+type tapLimits struct {
+	maxTaps     int
+	rate        float64
+	maxDuration time.Duration
+}
+
+// SetupTap exposes /admin/tap, streaming the matching messages and their events as server-sent
+// events to the admin users only, as the messages are raw
+func SetupTap(tokens Tokens, maxTaps int, rate float64, maxDuration time.Duration) {
+	limits := &tapLimits{
+		maxTaps:     maxTaps,
+		rate:        rate,
+		maxDuration: maxDuration,
+	}
+
+	http.HandleFunc("GET /admin/tap", tokens.Authenticate(limits.tapHandler))
+}
+
+func tapFilter(r *http.Request) (flow.TapFilter, error) {
+	query := r.URL.Query()
+	filter := flow.TapFilter{
+		Namespace: query.Get("namespace"),
+		Group:     query.Get("group"),
+		Hostname:  query.Get("hostname"),
+		Sample:    1,
+	}
+
+	if (len(filter.Namespace) == 0) == (len(filter.Group) == 0) {
+		return filter, fmt.Errorf("tap needs either a namespace or a group")
+	}
+
+	if sample := query.Get("sample"); len(sample) > 0 {
+		value, err := strconv.ParseFloat(sample, 64)
+		if err != nil || value <= 0 || value > 1 {
+			return filter, fmt.Errorf("sample must be in ]0, 1]")
+		}
+		filter.Sample = value
+	}
+
+	return filter, nil
+}
+
+func (limits *tapLimits) tapHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := tapFilter(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming unsupported"})
+		return
+	}
+
+	tap, err := flow.Subscribe(filter, limits.maxTaps, limits.rate)
+	if errors.Is(err, flow.ErrTooManyTaps) {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		return
+	}
+	defer flow.Unsubscribe(tap)
+
+	logrus.Infof("tap started from %s: %+v", r.RemoteAddr, filter)
+	defer logrus.Infof("tap ended from %s", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(tapHeartbeat)
+	defer heartbeat.Stop()
+
+	deadline := time.NewTimer(limits.maxDuration)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case message := <-tap.Messages():
+			data, err := json.Marshal(message)
+			if err != nil {
+				logrus.Errorf("tap marshal: %+v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-deadline.C:
+			fmt.Fprint(w, "event: end\ndata: {\"reason\": \"max duration reached\"}\n\n")
+			flusher.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,168 @@
+package alert
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+const (
+	namespaceAbsentAlert = "namespace_absent"
+	hostnameAbsentAlert  = "hostname_absent"
+
+	defaultHostnameRetention = 7 * 24 * time.Hour
+)
+
+// Absence raises an alert when the namespace, or one of its hostnames, receives
+// no event for a duration (0 disables the check). A hostname silent for longer
+// than the retention is forgotten, its alert resolved.
+type Absence struct {
+	Namespace   prom.Duration     `json:"namespace" yaml:"namespace"`
+	Hostname    prom.Duration     `json:"hostname" yaml:"hostname"`
+	Retention   prom.Duration     `json:"retention" yaml:"retention"` // 7 days by default
+	Labels      map[string]string `json:"labels" yaml:"labels"`
+	Annotations map[string]string `json:"annotations" yaml:"annotations"`
+}
+
+func (absence *Absence) Validate() error {
+	if absence.Namespace == 0 && absence.Hostname == 0 {
+		return fmt.Errorf("absence without namespace nor hostname threshold")
+	}
+
+	if absence.Retention > 0 && absence.Retention <= absence.Hostname {
+		return fmt.Errorf("absence retention must be longer than the hostname threshold")
+	}
+
+	return nil
+}
+
+func (absence *Absence) retention() time.Duration {
+	if absence.Retention == 0 {
+		return max(defaultHostnameRetention, 2*absence.Hostname.Duration())
+	}
+
+	return absence.Retention.Duration()
+}
+
+type silence struct {
+	last   time.Time
+	absent bool
+	since  time.Time
+}
+
+/*
+ * lastSeen keeps the last event time of a namespace and, when they are checked, of
+ * each of its hostnames, a hostname is only known once it sent an event
+ */
+
+type lastSeen struct {
+	mu        sync.Mutex
+	namespace silence
+	hostnames map[string]*silence
+}
+
+func newLastSeen(start time.Time, hostnames bool) *lastSeen {
+	seen := &lastSeen{namespace: silence{last: start}}
+	if hostnames {
+		seen.hostnames = make(map[string]*silence)
+	}
+
+	return seen
+}
+
+func (seen *lastSeen) touch(hostname string, now time.Time) {
+	seen.mu.Lock()
+	defer seen.mu.Unlock()
+
+	seen.namespace.last = now
+	if seen.hostnames == nil {
+		return
+	}
+
+	host, exists := seen.hostnames[hostname]
+	if !exists {
+		host = &silence{}
+		seen.hostnames[hostname] = host
+	}
+	host.last = now
+}
+
+// check tells if the silence started or stopped being an absence
+func (s *silence) check(threshold time.Duration, now time.Time) (started bool, stopped bool) {
+	absent := now.Sub(s.last) >= threshold
+	started = absent && !s.absent
+	stopped = !absent && s.absent
+	s.absent = absent
+
+	if started {
+		s.since = s.last.Add(threshold)
+	}
+	return started, stopped
+}
+
+func (alerts *namespaceAlerts) evaluateAbsence(now time.Time) []Alert {
+	notifications := make([]Alert, 0)
+	absence := alerts.absence
+
+	alerts.seen.mu.Lock()
+	defer alerts.seen.mu.Unlock()
+
+	if absence.Namespace > 0 {
+		threshold := absence.Namespace.Duration()
+		notifications = alerts.appendAbsence(notifications, namespaceAbsentAlert, &alerts.seen.namespace, threshold, absence.Labels, now)
+
+		state := stateInactive
+		if alerts.seen.namespace.absent {
+			state = stateFiring
+		}
+		alertMetrics.state.With(prometheus.Labels{"namespace": alerts.name, "alert": namespaceAbsentAlert}).Set(float64(state))
+	}
+
+	if absence.Hostname > 0 {
+		threshold := absence.Hostname.Duration()
+		retention := absence.retention()
+		absent := 0
+		for hostname, host := range alerts.seen.hostnames {
+			labels := map[string]string{"hostname": hostname}
+			for label, value := range absence.Labels {
+				labels[label] = value
+			}
+
+			if now.Sub(host.last) >= retention {
+				// a decommissioned hostname, forgotten with its alert
+				if host.absent {
+					notifications = append(notifications, alerts.newAlert(hostnameAbsentAlert, "resolved", now.Sub(host.last).Seconds(), threshold.Seconds(), labels, absence.Annotations, host.since, now))
+				}
+				delete(alerts.seen.hostnames, hostname)
+				continue
+			}
+
+			notifications = alerts.appendAbsence(notifications, hostnameAbsentAlert, host, threshold, labels, now)
+			if host.absent {
+				absent++
+			}
+		}
+		alertMetrics.absentHostnames.With(prometheus.Labels{"namespace": alerts.name}).Set(float64(absent))
+	}
+
+	return notifications
+}
+
+// appendAbsence notifies when the absence starts and when events are back, the value is the silence in seconds
+func (alerts *namespaceAlerts) appendAbsence(notifications []Alert, name string, s *silence, threshold time.Duration, labels map[string]string, now time.Time) []Alert {
+	value := now.Sub(s.last).Seconds()
+
+	started, stopped := s.check(threshold, now)
+	switch {
+	case started:
+		return append(notifications, alerts.newAlert(name, "firing", value, threshold.Seconds(), labels, alerts.absence.Annotations, s.since, time.Time{}))
+	case stopped:
+		return append(notifications, alerts.newAlert(name, "resolved", value, threshold.Seconds(), labels, alerts.absence.Annotations, s.since, s.last))
+	}
+
+	return notifications
+}
@@ -0,0 +1,136 @@
+package alert
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+func TestAbsenceValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		absence Absence
+		valid   bool
+	}{
+		{"namespace", Absence{Namespace: prom.Duration(time.Minute)}, true},
+		{"hostname", Absence{Hostname: prom.Duration(time.Minute)}, true},
+		{"retention", Absence{Hostname: prom.Duration(time.Minute), Retention: prom.Duration(time.Hour)}, true},
+		{"no threshold", Absence{}, false},
+		{"retention shorter than the threshold", Absence{Hostname: prom.Duration(time.Hour), Retention: prom.Duration(time.Minute)}, false},
+		{"retention at the threshold", Absence{Hostname: prom.Duration(time.Hour), Retention: prom.Duration(time.Hour)}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.absence.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+
+	if retention := (&Absence{Hostname: prom.Duration(time.Hour)}).retention(); retention != defaultHostnameRetention {
+		t.Errorf("expected the default retention, got %v", retention)
+	}
+	if retention := (&Absence{Hostname: prom.Duration(5 * 24 * time.Hour)}).retention(); retention != 10*24*time.Hour {
+		t.Errorf("expected twice the hostname threshold, got %v", retention)
+	}
+}
+
+// describe gives the notifications as "name hostname state", sorted
+func describe(alerts []Alert) string {
+	descriptions := make([]string, 0, len(alerts))
+	for _, alert := range alerts {
+		descriptions = append(descriptions, strings.TrimSpace(alert.Name+" "+alert.Labels["hostname"])+" "+alert.State)
+	}
+	sort.Strings(descriptions)
+
+	return strings.Join(descriptions, "; ")
+}
+
+func TestNamespaceAbsence(t *testing.T) {
+	engine := newTestEngine()
+	absence := &Absence{Namespace: prom.Duration(time.Minute)}
+	engine.addNamespace("silent", "service", "group", NamespaceConfig{Absence: absence}, t0)
+	state := alertMetrics.state.With(prometheus.Labels{"namespace": "silent", "alert": namespaceAbsentAlert})
+
+	// silent since the start
+	if alerts := engine.evaluate(t0.Add(30 * time.Second)); len(alerts) != 0 {
+		t.Errorf("expected no alert before the threshold, got %v", alerts)
+	}
+
+	alerts := engine.evaluate(t0.Add(time.Minute))
+	if describe(alerts) != "namespace_absent firing" || !alerts[0].StartsAt.Equal(t0.Add(time.Minute)) || alerts[0].Value != 60 {
+		t.Fatalf("expected the namespace absent from the threshold, got %v", alerts)
+	}
+	if testutil.ToFloat64(state) != stateFiring {
+		t.Errorf("expected the absence firing")
+	}
+
+	if alerts := engine.evaluate(t0.Add(2 * time.Minute)); len(alerts) != 0 {
+		t.Errorf("expected a single notification while absent, got %v", alerts)
+	}
+
+	engine.observe("silent", "host", map[string]any{}, t0.Add(150*time.Second))
+	alerts = engine.evaluate(t0.Add(160 * time.Second))
+	if describe(alerts) != "namespace_absent resolved" || !alerts[0].EndsAt.Equal(t0.Add(150*time.Second)) {
+		t.Fatalf("expected the absence resolved at the event, got %v", alerts)
+	}
+	if testutil.ToFloat64(state) != stateInactive {
+		t.Errorf("expected the absence inactive")
+	}
+}
+
+func TestHostnameAbsence(t *testing.T) {
+	engine := newTestEngine()
+	absence := &Absence{Hostname: prom.Duration(time.Minute), Retention: prom.Duration(10 * time.Minute)}
+	engine.addNamespace("hosts", "service", "group", NamespaceConfig{Absence: absence}, t0)
+	absent := alertMetrics.absentHostnames.With(prometheus.Labels{"namespace": "hosts"})
+	seen := engine.namespaces["hosts"].seen
+
+	// a hostname is only known once it sent an event
+	if alerts := engine.evaluate(t0.Add(5 * time.Minute)); len(alerts) != 0 {
+		t.Errorf("expected no alert without hostname, got %v", alerts)
+	}
+
+	engine.observe("hosts", "a", map[string]any{}, t0.Add(5*time.Minute))
+	engine.observe("hosts", "b", map[string]any{}, t0.Add(5*time.Minute))
+	engine.observe("hosts", "a", map[string]any{}, t0.Add(5*time.Minute+50*time.Second))
+
+	steps := []struct {
+		at      time.Duration
+		alerts  string
+		absent  float64
+		tracked int
+	}{
+		{5*time.Minute + 30*time.Second, "", 0, 2},
+		{6 * time.Minute, "hostname_absent b firing", 1, 2},
+		{6*time.Minute + 50*time.Second, "hostname_absent a firing", 2, 2},
+		{10 * time.Minute, "", 2, 2},
+		// b silent for the retention: forgotten with its alert
+		{15 * time.Minute, "hostname_absent b resolved", 1, 1},
+		{15*time.Minute + 50*time.Second, "hostname_absent a resolved", 0, 0},
+	}
+
+	for _, step := range steps {
+		alerts := engine.evaluate(t0.Add(step.at))
+		if describe(alerts) != step.alerts {
+			t.Errorf("at %v: expected %q, got %q", step.at, step.alerts, describe(alerts))
+		}
+		if value := testutil.ToFloat64(absent); value != step.absent {
+			t.Errorf("at %v: expected %v absent hostnames, got %v", step.at, step.absent, value)
+		}
+		if len(seen.hostnames) != step.tracked {
+			t.Errorf("at %v: expected %d hostnames tracked, got %d", step.at, step.tracked, len(seen.hostnames))
+		}
+	}
+
+	// a forgotten hostname is new again
+	engine.observe("hosts", "b", map[string]any{}, t0.Add(16*time.Minute))
+	if alerts := engine.evaluate(t0.Add(16*time.Minute + 30*time.Second)); len(alerts) != 0 || len(seen.hostnames) != 1 {
+		t.Errorf("expected b tracked again without alert, got %v", alerts)
+	}
+}
@@ -0,0 +1,314 @@
+package alert
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+const (
+	defaultAnomalyInterval  = time.Minute
+	defaultAnomalyAlpha     = 0.1
+	defaultAnomalyWindow    = 60
+	defaultAnomalyMinPoints = 10
+	defaultAnomalyBound     = 3
+
+	// a flat baseline gives an infinite score to any other value
+	maxAnomalyScore = 100
+
+	hoursPerWeek = 7 * 24
+)
+
+/*
+ * Anomaly scores the points of a metric, its values aggregated over an interval,
+ * against a baseline of the previous points:
+ *   - ewma: exponentially weighted mean and variance
+ *   - zscore: mean and standard deviation of the last window points
+ * A seasonal baseline keeps one baseline per hour of the week.
+ */
+
+type Anomaly struct {
+	Name        string            `json:"name" yaml:"name"` // <metric>_anomaly by default
+	Metric      string            `json:"metric" yaml:"metric"`
+	Method      string            `json:"method" yaml:"method"`
+	Aggregation string            `json:"aggregation" yaml:"aggregation"` // sum by default, avg, min, max, count
+	Interval    prom.Duration     `json:"interval" yaml:"interval"`       // time per point, 1m by default
+	Alpha       float64           `json:"alpha" yaml:"alpha"`             // ewma smoothing, 0.1 by default
+	Window      uint              `json:"window" yaml:"window"`           // zscore points, 60 by default
+	Seasonal    bool              `json:"seasonal" yaml:"seasonal"`
+	MinPoints   uint              `json:"min_points" yaml:"min_points"` // points of a baseline before scoring, 10 by default
+	Bound       float64           `json:"bound" yaml:"bound"`           // absolute score raising an alert, 3 by default
+	Labels      map[string]string `json:"labels" yaml:"labels"`
+	Annotations map[string]string `json:"annotations" yaml:"annotations"`
+}
+
+var anomalyScore = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "anomaly_score",
+		Help: "The score of the last point of a metric against its baseline, in standard deviations",
+	}, []string{"namespace", "anomaly"},
+)
+
+// Validate checks the anomaly against the metrics of its namespace and sets its defaults
+func (anomaly *Anomaly) Validate(metrics map[string]*prom.Metric) error {
+	if _, exists := metrics[anomaly.Metric]; !exists {
+		return fmt.Errorf("anomaly: unknown metric %q", anomaly.Metric)
+	}
+
+	if len(anomaly.Name) == 0 {
+		anomaly.Name = anomaly.Metric + "_anomaly"
+	}
+
+	switch anomaly.Method {
+	case "ewma":
+		if anomaly.Alpha == 0 {
+			anomaly.Alpha = defaultAnomalyAlpha
+		}
+		// at 1 the baseline is the last point alone, without variance
+		if anomaly.Alpha < 0 || anomaly.Alpha >= 1 {
+			return fmt.Errorf("anomaly %s: alpha must be in ]0, 1[", anomaly.Name)
+		}
+	case "zscore":
+		if anomaly.Window == 0 {
+			anomaly.Window = defaultAnomalyWindow
+		}
+	default:
+		return fmt.Errorf("anomaly %s: unknown method %q", anomaly.Name, anomaly.Method)
+	}
+
+	switch anomaly.Aggregation {
+	case "":
+		anomaly.Aggregation = "sum"
+	case "sum", "avg", "min", "max", "count":
+	default:
+		return fmt.Errorf("anomaly %s: unknown aggregation %q", anomaly.Name, anomaly.Aggregation)
+	}
+
+	if anomaly.MinPoints == 0 {
+		anomaly.MinPoints = defaultAnomalyMinPoints
+	}
+
+	if anomaly.Bound == 0 {
+		anomaly.Bound = defaultAnomalyBound
+	}
+
+	return nil
+}
+
+// CheckInterval rejects an interval shorter than the evaluations of the engine, which
+// close the points: the intervals between two evaluations would be merged or lost
+func (anomaly *Anomaly) CheckInterval(evalInterval time.Duration) error {
+	if anomaly.interval() < evalInterval {
+		return fmt.Errorf("anomaly %s: interval %v shorter than the alert evaluation interval %v", anomaly.Name, anomaly.interval(), evalInterval)
+	}
+
+	return nil
+}
+
+func (anomaly *Anomaly) interval() time.Duration {
+	if anomaly.Interval == 0 {
+		return defaultAnomalyInterval
+	}
+
+	return anomaly.Interval.Duration()
+}
+
+type baseline interface {
+	points() int
+	score(value float64) float64
+	update(value float64)
+}
+
+func score(value float64, mean float64, std float64) float64 {
+	if std == 0 {
+		switch {
+		case value > mean:
+			return maxAnomalyScore
+		case value < mean:
+			return -maxAnomalyScore
+		default:
+			return 0
+		}
+	}
+
+	return math.Max(-maxAnomalyScore, math.Min(maxAnomalyScore, (value-mean)/std))
+}
+
+type ewmaBaseline struct {
+	alpha    float64
+	mean     float64
+	variance float64
+	n        int
+}
+
+func (b *ewmaBaseline) points() int {
+	return b.n
+}
+
+func (b *ewmaBaseline) score(value float64) float64 {
+	return score(value, b.mean, math.Sqrt(b.variance))
+}
+
+func (b *ewmaBaseline) update(value float64) {
+	b.n++
+	if b.n == 1 {
+		b.mean = value
+		return
+	}
+
+	diff := value - b.mean
+	b.mean += b.alpha * diff
+	b.variance = (1 - b.alpha) * (b.variance + b.alpha*diff*diff)
+}
+
+type zscoreBaseline struct {
+	values []float64
+	next   int
+	n      int
+}
+
+func (b *zscoreBaseline) points() int {
+	return b.n
+}
+
+func (b *zscoreBaseline) score(value float64) float64 {
+	var sum, squares float64
+	for _, v := range b.values[:b.n] {
+		sum += v
+		squares += v * v
+	}
+
+	mean := sum / float64(b.n)
+	variance := math.Max(0, squares/float64(b.n)-mean*mean)
+	return score(value, mean, math.Sqrt(variance))
+}
+
+func (b *zscoreBaseline) update(value float64) {
+	b.values[b.next] = value
+	b.next = (b.next + 1) % len(b.values)
+	b.n = min(b.n+1, len(b.values))
+}
+
+/*
+ * anomalyDetector aggregates the values of the current interval, then scores the
+ * point and adds it to the baseline of its slot when the interval is over
+ */
+
+type anomalyDetector struct {
+	config *Anomaly
+
+	mu      sync.Mutex
+	start   time.Time
+	current aggregate
+
+	baselines map[int]baseline
+	firing    bool
+	since     time.Time
+}
+
+func newAnomalyDetector(config *Anomaly, now time.Time) *anomalyDetector {
+	return &anomalyDetector{
+		config:    config,
+		start:     now.Truncate(config.interval()),
+		current:   aggregate{min: math.Inf(1), max: math.Inf(-1)},
+		baselines: make(map[int]baseline),
+	}
+}
+
+func (detector *anomalyDetector) observe(value float64) {
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+
+	detector.current.sum += value
+	detector.current.min = math.Min(detector.current.min, value)
+	detector.current.max = math.Max(detector.current.max, value)
+	detector.current.count++
+}
+
+func (detector *anomalyDetector) baseline(slot int) baseline {
+	b, exists := detector.baselines[slot]
+	if !exists {
+		if detector.config.Method == "ewma" {
+			b = &ewmaBaseline{alpha: detector.config.Alpha}
+		} else {
+			b = &zscoreBaseline{values: make([]float64, detector.config.Window)}
+		}
+		detector.baselines[slot] = b
+	}
+
+	return b
+}
+
+// close ends the interval once it is over, an interval without events is a 0 point
+// for sum and count and no point otherwise
+func (detector *anomalyDetector) close(now time.Time) (point float64, slot int, ok bool) {
+	start := now.Truncate(detector.config.interval())
+
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+
+	if !start.After(detector.start) {
+		return 0, 0, false
+	}
+
+	current := detector.current
+	if detector.config.Seasonal {
+		slot = hourOfWeek(detector.start)
+	}
+
+	detector.start = start
+	detector.current = aggregate{min: math.Inf(1), max: math.Inf(-1)}
+
+	if current.count == 0 && detector.config.Aggregation != "sum" && detector.config.Aggregation != "count" {
+		return 0, 0, false
+	}
+
+	return current.get(detector.config.Aggregation), slot, true
+}
+
+func hourOfWeek(t time.Time) int {
+	t = t.UTC()
+	return (int(t.Weekday())*24 + t.Hour()) % hoursPerWeek
+}
+
+func (alerts *namespaceAlerts) evaluateAnomalies(now time.Time) []Alert {
+	notifications := make([]Alert, 0)
+
+	for _, detectors := range alerts.anomalies {
+		for _, detector := range detectors {
+			point, slot, ok := detector.close(now)
+			if !ok {
+				continue
+			}
+
+			config := detector.config
+			b := detector.baseline(slot)
+
+			var value float64
+			if b.points() >= int(config.MinPoints) {
+				value = b.score(point)
+			}
+			b.update(point)
+
+			anomalyScore.With(prometheus.Labels{"namespace": alerts.name, "anomaly": config.Name}).Set(value)
+
+			anomalous := math.Abs(value) >= config.Bound
+			switch {
+			case anomalous && !detector.firing:
+				detector.firing = true
+				detector.since = now
+				notifications = append(notifications, alerts.newAlert(config.Name, "firing", value, config.Bound, config.Labels, config.Annotations, now, time.Time{}))
+			case !anomalous && detector.firing:
+				detector.firing = false
+				notifications = append(notifications, alerts.newAlert(config.Name, "resolved", value, config.Bound, config.Labels, config.Annotations, detector.since, now))
+			}
+		}
+	}
+
+	return notifications
+}
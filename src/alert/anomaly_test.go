@@ -0,0 +1,147 @@
+package alert
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+func TestAnomalyValidate(t *testing.T) {
+	metrics := map[string]*prom.Metric{"total": {Name: "total", Type: "counter"}}
+
+	tests := []struct {
+		name    string
+		anomaly Anomaly
+		valid   bool
+	}{
+		{"ewma defaults", Anomaly{Metric: "total", Method: "ewma"}, true},
+		{"zscore defaults", Anomaly{Metric: "total", Method: "zscore"}, true},
+		{"alpha below 1", Anomaly{Metric: "total", Method: "ewma", Alpha: 0.99}, true},
+		{"alpha 1", Anomaly{Metric: "total", Method: "ewma", Alpha: 1}, false},
+		{"negative alpha", Anomaly{Metric: "total", Method: "ewma", Alpha: -0.1}, false},
+		{"unknown metric", Anomaly{Metric: "other", Method: "ewma"}, false},
+		{"unknown method", Anomaly{Metric: "total", Method: "mad"}, false},
+		{"unknown aggregation", Anomaly{Metric: "total", Method: "ewma", Aggregation: "p99"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.anomaly.Validate(metrics); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestAnomalyCheckInterval(t *testing.T) {
+	tests := []struct {
+		interval prom.Duration
+		valid    bool
+	}{
+		{0, true}, // 1m by default
+		{prom.Duration(15 * time.Second), true},
+		{prom.Duration(5 * time.Second), false},
+	}
+
+	for _, test := range tests {
+		anomaly := Anomaly{Name: "total_anomaly", Interval: test.interval}
+		if err := anomaly.CheckInterval(15 * time.Second); (err == nil) != test.valid {
+			t.Errorf("interval %v: expected valid %v, got %v", test.interval.Duration(), test.valid, err)
+		}
+	}
+}
+
+func TestEWMABaseline(t *testing.T) {
+	b := &ewmaBaseline{alpha: 0.5}
+
+	b.update(10)
+	if b.points() != 1 || b.mean != 10 || b.variance != 0 {
+		t.Fatalf("expected the first point as the mean, got %d points, mean %v variance %v", b.points(), b.mean, b.variance)
+	}
+
+	// diff 4: mean 10 + 0.5*4, variance 0.5 * (0 + 0.5*16)
+	b.update(14)
+	if b.mean != 12 || b.variance != 4 {
+		t.Fatalf("expected mean 12 variance 4, got %v %v", b.mean, b.variance)
+	}
+
+	if s := b.score(16); s != 2 {
+		t.Errorf("expected a score of 2 standard deviations, got %v", s)
+	}
+	if s := b.score(8); s != -2 {
+		t.Errorf("expected a score of -2 standard deviations, got %v", s)
+	}
+}
+
+func TestZScoreBaseline(t *testing.T) {
+	b := &zscoreBaseline{values: make([]float64, 4)}
+
+	for _, value := range []float64{100, 2, 4, 4, 6} {
+		b.update(value)
+	}
+
+	// the window keeps the last 4 points: mean 4, standard deviation sqrt(2)
+	if b.points() != 4 {
+		t.Fatalf("expected the window of 4 points, got %d", b.points())
+	}
+	if s := b.score(4 + 2*math.Sqrt2); math.Abs(s-2) > 1e-9 {
+		t.Errorf("expected a score of 2 standard deviations, got %v", s)
+	}
+
+	flat := &zscoreBaseline{values: make([]float64, 3)}
+	flat.update(5)
+	flat.update(5)
+	tests := []struct {
+		value float64
+		score float64
+	}{
+		{5, 0},
+		{6, maxAnomalyScore},
+		{4, -maxAnomalyScore},
+	}
+	for _, test := range tests {
+		if s := flat.score(test.value); s != test.score {
+			t.Errorf("flat baseline: expected %v to score %v, got %v", test.value, test.score, s)
+		}
+	}
+}
+
+func TestAnomalySeasonal(t *testing.T) {
+	config := &Anomaly{Name: "total_anomaly", Metric: "total", Method: "ewma", Aggregation: "sum", Interval: prom.Duration(time.Hour), Alpha: 0.5, Seasonal: true, MinPoints: 1, Bound: 3}
+	// a Monday
+	start := time.Date(2024, time.January, 1, 10, 0, 0, 0, time.UTC)
+	detector := newAnomalyDetector(config, start)
+
+	closeAt := func(at time.Time, expectedSlot int) {
+		detector.observe(1)
+		detector.observe(2)
+		point, slot, ok := detector.close(at)
+		if !ok || point != 3 || slot != expectedSlot {
+			t.Errorf("%v: expected the point 3 in slot %d, got %v in slot %d (%v)", at, expectedSlot, point, slot, ok)
+		}
+		detector.baseline(slot).update(point)
+	}
+
+	closeAt(start.Add(time.Hour), 24+10)
+	closeAt(start.Add(2*time.Hour), 24+11)
+
+	// a week later: the hours without events are a single 0 point, then the first hour again
+	week := start.Add(7 * 24 * time.Hour)
+	if point, slot, ok := detector.close(week); !ok || point != 0 || slot != 24+12 {
+		t.Errorf("expected a 0 point for the hours without events, got %v in slot %d (%v)", point, slot, ok)
+	}
+	closeAt(week.Add(time.Hour), 24+10)
+
+	if len(detector.baselines) != 2 || detector.baselines[24+10].points() != 2 || detector.baselines[24+11].points() != 1 {
+		t.Errorf("expected a baseline per hour of the week, got %v", detector.baselines)
+	}
+
+	// not over yet
+	if _, _, ok := detector.close(week.Add(90 * time.Minute)); ok {
+		t.Errorf("expected no point before the end of the interval")
+	}
+
+	if hour := hourOfWeek(time.Date(2024, time.January, 6, 23, 0, 0, 0, time.FixedZone("", 2*3600))); hour != 6*24+21 {
+		t.Errorf("expected the hour of the week in UTC, got %d", hour)
+	}
+}
@@ -0,0 +1,402 @@
+package alert
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+// values of the alert_state gauge
+const (
+	stateInactive = 0
+	statePending  = 1
+	stateFiring   = 2
+)
+
+var alertMetrics = struct {
+	state              *prometheus.GaugeVec
+	absentHostnames    *prometheus.GaugeVec
+	notifications      *prometheus.CounterVec
+	notificationErrors *prometheus.CounterVec
+}{
+	state: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "alert_state",
+			Help: "The state of the alert rules: 0 inactive, 1 pending, 2 firing",
+		}, []string{"namespace", "alert"},
+	),
+	absentHostnames: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "absent_hostnames",
+			Help: "The number of hostnames of a namespace silent for longer than their absence threshold",
+		}, []string{"namespace"},
+	),
+	notifications: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_notifications",
+			Help: "The number of alerts sent per notifier",
+		}, []string{"notifier"},
+	),
+	notificationErrors: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alert_notification_errors",
+			Help: "The number of failed notifications per notifier",
+		}, []string{"notifier"},
+	),
+}
+
+// Alert is what the notifiers receive when a rule starts firing or is resolved
+type Alert struct {
+	Name        string            `json:"name"`
+	State       string            `json:"state"` // firing or resolved
+	Namespace   string            `json:"namespace"`
+	Service     string            `json:"service"`
+	Group       string            `json:"group"`
+	Value       float64           `json:"value"`
+	Threshold   float64           `json:"threshold"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"starts_at"`
+	EndsAt      time.Time         `json:"ends_at"`
+}
+
+type Notifier interface {
+	Name() string
+	Notify(alerts []Alert) error
+}
+
+type ruleState struct {
+	state       int
+	activeSince time.Time
+}
+
+/*
+ * namespaceAlerts holds the rules of a namespace with the windows of the metrics they use
+ */
+
+type namespaceAlerts struct {
+	name    string
+	service string
+	group   string
+
+	rules   []*Rule
+	windows map[string]*window
+	states  map[string]*ruleState
+
+	absence *Absence
+	seen    *lastSeen
+
+	anomalies map[string][]*anomalyDetector
+	slos      []*sloTracker
+}
+
+/*
+ * Engine evaluates the alert rules of every namespace at a fixed interval and
+ * notifies when they start firing (after holding for their duration) and when
+ * they are resolved
+ */
+
+type Engine struct {
+	interval  time.Duration
+	notifiers []Notifier
+
+	// namespaces are added and removed while running by the admin api
+	mu         sync.RWMutex
+	namespaces map[string]*namespaceAlerts
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func NewEngine(interval time.Duration, notifiers []Notifier) *Engine {
+	prom.MustRegister(alertMetrics.state, alertMetrics.absentHostnames, alertMetrics.notifications, alertMetrics.notificationErrors, anomalyScore,
+		sloMetrics.compliance, sloMetrics.budget, sloMetrics.burnRate, sloMetrics.tracked)
+
+	return &Engine{
+		interval:   interval,
+		notifiers:  notifiers,
+		namespaces: make(map[string]*namespaceAlerts),
+		stop:       make(chan struct{}),
+	}
+}
+
+// NamespaceConfig is what a namespace declares for the engine
+type NamespaceConfig struct {
+	Rules     []*Rule
+	Absence   *Absence
+	Anomalies []*Anomaly
+	SLOs      []*SLO
+}
+
+func (config NamespaceConfig) empty() bool {
+	return len(config.Rules) == 0 && config.Absence == nil && len(config.Anomalies) == 0 && len(config.SLOs) == 0
+}
+
+// AddNamespace registers the rules, absence thresholds, anomaly detectors and SLOs of a namespace,
+// a namespace already registered must be removed first
+func (engine *Engine) AddNamespace(name string, service string, group string, config NamespaceConfig) {
+	engine.addNamespace(name, service, group, config, time.Now())
+}
+
+// addNamespace starts the intervals and the silences at now
+func (engine *Engine) addNamespace(name string, service string, group string, config NamespaceConfig, now time.Time) {
+	if config.empty() {
+		return
+	}
+
+	alerts := &namespaceAlerts{
+		name:    name,
+		service: service,
+		group:   group,
+		rules:   config.Rules,
+		windows: make(map[string]*window),
+		states:  make(map[string]*ruleState),
+		absence: config.Absence,
+
+		anomalies: make(map[string][]*anomalyDetector),
+	}
+
+	for _, anomaly := range config.Anomalies {
+		alerts.anomalies[anomaly.Metric] = append(alerts.anomalies[anomaly.Metric], newAnomalyDetector(anomaly, now))
+	}
+
+	for _, slo := range config.SLOs {
+		alerts.slos = append(alerts.slos, newSLOTracker(slo, now))
+	}
+
+	if config.Absence != nil {
+		alerts.seen = newLastSeen(now, config.Absence.Hostname > 0)
+		alertMetrics.state.With(prometheus.Labels{"namespace": name, "alert": namespaceAbsentAlert}).Set(stateInactive)
+	}
+
+	for _, rule := range config.Rules {
+		for _, metric := range rule.metrics() {
+			if w, exists := alerts.windows[metric]; exists {
+				w.grow(rule.window())
+			} else {
+				alerts.windows[metric] = newWindow(rule.window())
+			}
+		}
+
+		alerts.states[rule.Name] = &ruleState{}
+		alertMetrics.state.With(prometheus.Labels{"namespace": name, "alert": rule.Name}).Set(stateInactive)
+	}
+
+	engine.mu.Lock()
+	engine.namespaces[name] = alerts
+	engine.mu.Unlock()
+
+	logrus.Infof("registered %d alert rules, %d anomaly detectors and %d SLOs for namespace %s (absence %t)",
+		len(config.Rules), len(config.Anomalies), len(config.SLOs), name, config.Absence != nil)
+}
+
+// RemoveNamespace drops the rules of a replaced or deleted namespace, with their series
+func (engine *Engine) RemoveNamespace(name string) {
+	engine.mu.Lock()
+	_, exists := engine.namespaces[name]
+	delete(engine.namespaces, name)
+	engine.mu.Unlock()
+
+	if !exists {
+		return
+	}
+
+	labels := prometheus.Labels{"namespace": name}
+	alertMetrics.state.DeletePartialMatch(labels)
+	alertMetrics.absentHostnames.DeletePartialMatch(labels)
+	anomalyScore.DeletePartialMatch(labels)
+	sloMetrics.compliance.DeletePartialMatch(labels)
+	sloMetrics.budget.DeletePartialMatch(labels)
+	sloMetrics.burnRate.DeletePartialMatch(labels)
+	sloMetrics.tracked.DeletePartialMatch(labels)
+	logrus.Infof("removed the alert rules, anomaly detectors and SLOs of namespace %s", name)
+}
+
+// HasRules tells if any namespace has alert rules, absence thresholds, anomaly detectors or SLOs
+func (engine *Engine) HasRules() bool {
+	engine.mu.RLock()
+	defer engine.mu.RUnlock()
+
+	return len(engine.namespaces) > 0
+}
+
+// Observe adds the values of an event to the windows of its namespace
+func (engine *Engine) Observe(namespace string, hostname string, metrics map[string]any) {
+	engine.observe(namespace, hostname, metrics, time.Now())
+}
+
+// observe adds the values to the buckets of now
+func (engine *Engine) observe(namespace string, hostname string, metrics map[string]any, now time.Time) {
+	engine.mu.RLock()
+	alerts, exists := engine.namespaces[namespace]
+	engine.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	if alerts.seen != nil {
+		alerts.seen.touch(hostname, now)
+	}
+
+	for name, w := range alerts.windows {
+		value, ok := toFloat64(metrics[name])
+		if !ok {
+			continue
+		}
+		w.add(value, now)
+	}
+
+	for name, detectors := range alerts.anomalies {
+		value, ok := toFloat64(metrics[name])
+		if !ok {
+			continue
+		}
+		for _, detector := range detectors {
+			detector.observe(value)
+		}
+	}
+
+	for _, tracker := range alerts.slos {
+		tracker.observe(metrics, now)
+	}
+}
+
+func (engine *Engine) Start() {
+	engine.done.Add(1)
+	go engine.evaluateLoop()
+}
+
+func (engine *Engine) evaluateLoop() {
+	defer engine.done.Done()
+
+	tick := time.NewTicker(engine.interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case now := <-tick.C:
+			engine.Notify(engine.evaluate(now))
+		case <-engine.stop:
+			return
+		}
+	}
+}
+
+func (engine *Engine) evaluate(now time.Time) []Alert {
+	notifications := make([]Alert, 0)
+
+	engine.mu.RLock()
+	defer engine.mu.RUnlock()
+
+	for _, alerts := range engine.namespaces {
+		for _, rule := range alerts.rules {
+			value, active := rule.evaluate(alerts.windows, now)
+			state := alerts.states[rule.Name]
+
+			switch {
+			case active && state.state == stateInactive:
+				state.state = statePending
+				state.activeSince = now
+				logrus.Debugf("alert %s of namespace %s pending: %v", rule.Name, alerts.name, value)
+			case !active && state.state == statePending:
+				state.state = stateInactive
+			case !active && state.state == stateFiring:
+				state.state = stateInactive
+				notifications = append(notifications, alerts.alert(rule, "resolved", value, state.activeSince, now))
+			}
+
+			if active && state.state == statePending && now.Sub(state.activeSince) >= rule.For.Duration() {
+				state.state = stateFiring
+				notifications = append(notifications, alerts.alert(rule, "firing", value, state.activeSince, time.Time{}))
+			}
+
+			alertMetrics.state.With(prometheus.Labels{"namespace": alerts.name, "alert": rule.Name}).Set(float64(state.state))
+		}
+
+		if alerts.absence != nil {
+			notifications = append(notifications, alerts.evaluateAbsence(now)...)
+		}
+
+		notifications = append(notifications, alerts.evaluateAnomalies(now)...)
+		notifications = append(notifications, alerts.evaluateSLOs(now)...)
+	}
+
+	return notifications
+}
+
+func (alerts *namespaceAlerts) alert(rule *Rule, state string, value float64, startsAt time.Time, endsAt time.Time) Alert {
+	return alerts.newAlert(rule.Name, state, value, rule.Value, rule.Labels, rule.Annotations, startsAt, endsAt)
+}
+
+// newAlert labels the alert with its name and the namespace labels on top of its own
+func (alerts *namespaceAlerts) newAlert(name string, state string, value float64, threshold float64, extraLabels map[string]string, annotations map[string]string, startsAt time.Time, endsAt time.Time) Alert {
+	labels := map[string]string{
+		"alertname": name,
+		"service":   alerts.service,
+		"group":     alerts.group,
+		"namespace": alerts.name,
+	}
+	for label, value := range extraLabels {
+		labels[label] = value
+	}
+
+	return Alert{
+		Name:        name,
+		State:       state,
+		Namespace:   alerts.name,
+		Service:     alerts.service,
+		Group:       alerts.group,
+		Value:       value,
+		Threshold:   threshold,
+		Labels:      labels,
+		Annotations: annotations,
+		StartsAt:    startsAt,
+		EndsAt:      endsAt,
+	}
+}
+
+// Notify sends the alerts to every notifier
+func (engine *Engine) Notify(alerts []Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	for _, alert := range alerts {
+		logrus.Infof("alert %s of namespace %s %s: %v", alert.Name, alert.Namespace, alert.State, alert.Value)
+	}
+
+	for _, notifier := range engine.notifiers {
+		if err := notifier.Notify(alerts); err != nil {
+			logrus.Errorf("alert notifier %s: %+v", notifier.Name(), err)
+			alertMetrics.notificationErrors.With(prometheus.Labels{"notifier": notifier.Name()}).Inc()
+			continue
+		}
+		alertMetrics.notifications.With(prometheus.Labels{"notifier": notifier.Name()}).Add(float64(len(alerts)))
+	}
+}
+
+func (engine *Engine) Close() {
+	close(engine.stop)
+	engine.done.Wait()
+
+	for _, notifier := range engine.notifiers {
+		if closer, ok := notifier.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
+func toFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,194 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+// newTestEngine does not register the alert metrics, which would fail for a second engine
+func newTestEngine() *Engine {
+	return &Engine{
+		interval:   time.Second,
+		namespaces: make(map[string]*namespaceAlerts),
+		stop:       make(chan struct{}),
+	}
+}
+
+func TestRemoveNamespace(t *testing.T) {
+	engine := newTestEngine()
+	rule := &Rule{Name: "errors", Type: "threshold", Metric: "errors", Aggregation: "sum", Op: ">", Value: 1}
+	engine.AddNamespace("removed", "service", "group", NamespaceConfig{Rules: []*Rule{rule}})
+
+	engine.Observe("removed", "host", map[string]any{"errors": 5.0})
+	if alerts := engine.evaluate(time.Now()); len(alerts) != 1 || alerts[0].State != "firing" {
+		t.Fatalf("expected the rule firing, got %v", alerts)
+	}
+
+	engine.RemoveNamespace("removed")
+	if engine.HasRules() {
+		t.Errorf("expected the rules of the namespace dropped")
+	}
+	// the other tests leave their series
+	if count := alertMetrics.state.DeletePartialMatch(prometheus.Labels{"namespace": "removed"}); count != 0 {
+		t.Errorf("expected the alert_state series deleted, got %d", count)
+	}
+
+	// the events of the namespace are ignored, and the replacing rules start empty
+	engine.Observe("removed", "host", map[string]any{"errors": 5.0})
+	engine.AddNamespace("removed", "service", "group", NamespaceConfig{Rules: []*Rule{rule}})
+	if alerts := engine.evaluate(time.Now()); len(alerts) != 0 {
+		t.Errorf("expected no alert from empty windows, got %v", alerts)
+	}
+	if state := testutil.ToFloat64(alertMetrics.state.With(prometheus.Labels{"namespace": "removed", "alert": "errors"})); state != stateInactive {
+		t.Errorf("expected the replacing rule inactive, got %v", state)
+	}
+}
+
+// a window bucket starts on each 5s
+var t0 = time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+type timedEvent struct {
+	at      time.Duration // before the evaluation
+	metrics map[string]any
+}
+
+func TestRuleEvaluate(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   Rule
+		events []timedEvent
+		value  float64
+		active bool
+	}{
+		{"avg", Rule{Type: "threshold", Metric: "errors", Aggregation: "avg", Op: ">", Value: 2},
+			[]timedEvent{{10 * time.Second, map[string]any{"errors": 1}}, {0, map[string]any{"errors": 3}}, {0, map[string]any{"errors": 5.0}}}, 3, true},
+		{"sum", Rule{Type: "threshold", Metric: "errors", Aggregation: "sum", Op: ">=", Value: 10},
+			[]timedEvent{{30 * time.Second, map[string]any{"errors": 4}}, {0, map[string]any{"errors": 5}}}, 9, false},
+		{"min", Rule{Type: "threshold", Metric: "errors", Aggregation: "min", Op: "<", Value: 2},
+			[]timedEvent{{30 * time.Second, map[string]any{"errors": 1}}, {0, map[string]any{"errors": 5}}}, 1, true},
+		{"max", Rule{Type: "threshold", Metric: "errors", Aggregation: "max", Op: "==", Value: 5},
+			[]timedEvent{{30 * time.Second, map[string]any{"errors": 1}}, {0, map[string]any{"errors": 5}}}, 5, true},
+		{"last", Rule{Type: "threshold", Metric: "errors", Aggregation: "last", Op: "!=", Value: 1},
+			[]timedEvent{{0, map[string]any{"errors": 1}}, {30 * time.Second, map[string]any{"errors": 5}}}, 1, false},
+		{"count", Rule{Type: "threshold", Metric: "errors", Aggregation: "count", Op: ">", Value: 2},
+			[]timedEvent{{0, map[string]any{"errors": 1}}, {0, map[string]any{"errors": 1}}, {0, map[string]any{"total": 1}}}, 2, false},
+		{"out of the window", Rule{Type: "threshold", Metric: "errors", Aggregation: "max", Op: ">", Value: 2},
+			[]timedEvent{{2 * time.Minute, map[string]any{"errors": 100}}, {0, map[string]any{"errors": 1}}}, 1, false},
+		{"no data", Rule{Type: "threshold", Metric: "errors", Aggregation: "count", Op: "<", Value: 1},
+			[]timedEvent{{0, map[string]any{"total": 1}}}, 0, false},
+		{"not a number", Rule{Type: "threshold", Metric: "errors", Aggregation: "count", Op: ">", Value: 0},
+			[]timedEvent{{0, map[string]any{"errors": "1"}}}, 0, false},
+		{"rate", Rule{Type: "rate", Metric: "errors", Op: ">", Value: 1},
+			[]timedEvent{{40 * time.Second, map[string]any{"errors": 60}}, {0, map[string]any{"errors": 60}}}, 2, true},
+		{"rate without data", Rule{Type: "rate", Metric: "errors", Op: "<", Value: 1},
+			nil, 0, true},
+		{"ratio", Rule{Type: "ratio", Metric: "errors", Total: "total", Op: ">", Value: 0.01},
+			[]timedEvent{{0, map[string]any{"errors": 5, "total": 50}}, {20 * time.Second, map[string]any{"total": 50}}}, 0.05, true},
+		{"ratio without total", Rule{Type: "ratio", Metric: "errors", Total: "total", Op: ">", Value: 0.01},
+			[]timedEvent{{0, map[string]any{"errors": 5}}}, 0, false},
+	}
+
+	for _, test := range tests {
+		engine := newTestEngine()
+		test.rule.Name = "rule"
+		test.rule.Window = prom.Duration(time.Minute)
+		engine.addNamespace("evaluated", "service", "group", NamespaceConfig{Rules: []*Rule{&test.rule}}, t0.Add(-time.Hour))
+
+		for _, event := range test.events {
+			engine.observe("evaluated", "host", event.metrics, t0.Add(-event.at))
+		}
+
+		value, active := test.rule.evaluate(engine.namespaces["evaluated"].windows, t0)
+		if value != test.value || active != test.active {
+			t.Errorf("%s: expected %v (%v), got %v (%v)", test.name, test.value, test.active, value, active)
+		}
+	}
+}
+
+func TestRuleTransitions(t *testing.T) {
+	rule := &Rule{Name: "errors", Type: "threshold", Metric: "errors", Aggregation: "sum", Op: ">", Value: 1,
+		Window: prom.Duration(time.Minute), For: prom.Duration(30 * time.Second)}
+
+	type step struct {
+		at        time.Duration // after t0
+		errors    any           // observed before the evaluation, unless nil
+		state     int
+		alert     string // notified state, if any
+		startsAt  time.Duration
+		endsAt    time.Duration // zero while firing
+		hasEndsAt bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"fires after holding, then resolves", []step{
+			{at: 0, errors: 5, state: statePending},
+			{at: 15 * time.Second, state: statePending},
+			{at: 30 * time.Second, state: stateFiring, alert: "firing", startsAt: 0},
+			{at: 45 * time.Second, errors: 5, state: stateFiring},
+			// the window holds the events from 45s until 1m45s
+			{at: 100 * time.Second, state: stateFiring},
+			{at: 105 * time.Second, state: stateInactive, alert: "resolved", startsAt: 0, endsAt: 105 * time.Second, hasEndsAt: true},
+		}},
+		{"pending too short", []step{
+			{at: 0, errors: 5, state: statePending},
+			{at: 20 * time.Second, state: statePending},
+			{at: 60 * time.Second, state: stateInactive},
+			{at: 90 * time.Second, state: stateInactive},
+		}},
+		{"pending again", []step{
+			{at: 0, errors: 5, state: statePending},
+			{at: 60 * time.Second, state: stateInactive},
+			{at: 65 * time.Second, errors: 5, state: statePending},
+			{at: 90 * time.Second, state: statePending},
+			{at: 95 * time.Second, state: stateFiring, alert: "firing", startsAt: 65 * time.Second},
+		}},
+	}
+
+	for _, test := range tests {
+		engine := newTestEngine()
+		engine.addNamespace("transitions", "service", "group", NamespaceConfig{Rules: []*Rule{rule}}, t0)
+
+		for _, step := range test.steps {
+			now := t0.Add(step.at)
+			if step.errors != nil {
+				engine.observe("transitions", "host", map[string]any{"errors": step.errors}, now)
+			}
+
+			alerts := engine.evaluate(now)
+			if state := testutil.ToFloat64(alertMetrics.state.With(prometheus.Labels{"namespace": "transitions", "alert": "errors"})); state != float64(step.state) {
+				t.Errorf("%s at %v: expected the state %d, got %v", test.name, step.at, step.state, state)
+			}
+
+			if len(step.alert) == 0 {
+				if len(alerts) != 0 {
+					t.Errorf("%s at %v: expected no notification, got %v", test.name, step.at, alerts)
+				}
+				continue
+			}
+
+			if len(alerts) != 1 {
+				t.Errorf("%s at %v: expected a %s notification, got %v", test.name, step.at, step.alert, alerts)
+				continue
+			}
+			alert := alerts[0]
+			endsAt := time.Time{}
+			if step.hasEndsAt {
+				endsAt = t0.Add(step.endsAt)
+			}
+			if alert.State != step.alert || !alert.StartsAt.Equal(t0.Add(step.startsAt)) || !alert.EndsAt.Equal(endsAt) {
+				t.Errorf("%s at %v: expected %s from %v until %v, got %s from %v until %v", test.name, step.at, step.alert, t0.Add(step.startsAt), endsAt, alert.State, alert.StartsAt, alert.EndsAt)
+			}
+			if alert.Labels["alertname"] != "errors" || alert.Labels["namespace"] != "transitions" || alert.Threshold != 1 {
+				t.Errorf("%s at %v: expected the rule labels and threshold, got %v %v", test.name, step.at, alert.Labels, alert.Threshold)
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	notifyTimeout = 10 * time.Second
+
+	// firing alerts are sent again to alertmanager so they do not resolve on their own
+	alertmanagerResendInterval = time.Minute
+)
+
+func postJSON(client *http.Client, url string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", res.Status)
+	}
+
+	return nil
+}
+
+/*
+ * WebhookNotifier posts {"alerts": [...]} to an url
+ */
+
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: notifyTimeout},
+	}
+}
+
+func (notifier *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (notifier *WebhookNotifier) Notify(alerts []Alert) error {
+	return postJSON(notifier.client, notifier.url, map[string]any{"alerts": alerts})
+}
+
+/*
+ * TopicNotifier publishes every alert as a json message keyed by namespace
+ */
+
+type TopicNotifier struct {
+	producer pulsar.Producer
+}
+
+func NewTopicNotifier(client pulsar.Client, topic string) (*TopicNotifier, error) {
+	producer, err := client.CreateProducer(pulsar.ProducerOptions{
+		Topic: topic,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TopicNotifier{producer: producer}, nil
+}
+
+func (notifier *TopicNotifier) Name() string {
+	return "topic"
+}
+
+func (notifier *TopicNotifier) Notify(alerts []Alert) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	for _, alert := range alerts {
+		payload, err := json.Marshal(alert)
+		if err != nil {
+			return err
+		}
+
+		if _, err := notifier.producer.Send(ctx, &pulsar.ProducerMessage{
+			Payload: payload,
+			Key:     alert.Namespace,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (notifier *TopicNotifier) Close() {
+	notifier.producer.Close()
+}
+
+// alertmanagerAlert is an alert of the alertmanager v2 api
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+/*
+ * AlertmanagerNotifier posts the alerts to the alertmanager api, resending the
+ * firing ones with an end in the future like prometheus does
+ */
+
+type AlertmanagerNotifier struct {
+	url    string
+	client *http.Client
+
+	mu     sync.Mutex
+	firing map[string]Alert
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func NewAlertmanagerNotifier(url string) *AlertmanagerNotifier {
+	notifier := &AlertmanagerNotifier{
+		url:    strings.TrimSuffix(url, "/") + "/api/v2/alerts",
+		client: &http.Client{Timeout: notifyTimeout},
+		firing: make(map[string]Alert),
+		stop:   make(chan struct{}),
+	}
+
+	notifier.done.Add(1)
+	go notifier.resendLoop()
+
+	return notifier
+}
+
+func (notifier *AlertmanagerNotifier) Name() string {
+	return "alertmanager"
+}
+
+func (notifier *AlertmanagerNotifier) Notify(alerts []Alert) error {
+	notifier.mu.Lock()
+	for _, alert := range alerts {
+		key := labelsKey(alert.Labels)
+		if alert.State == "firing" {
+			notifier.firing[key] = alert
+		} else {
+			delete(notifier.firing, key)
+		}
+	}
+	notifier.mu.Unlock()
+
+	return notifier.send(alerts)
+}
+
+// labelsKey identifies an alert like alertmanager does, by its labels
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString(name + "=" + labels[name] + "\xff")
+	}
+
+	return key.String()
+}
+
+func (notifier *AlertmanagerNotifier) send(alerts []Alert) error {
+	now := time.Now()
+
+	body := make([]alertmanagerAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		endsAt := alert.EndsAt
+		if alert.State == "firing" {
+			endsAt = now.Add(4 * alertmanagerResendInterval)
+		}
+
+		body = append(body, alertmanagerAlert{
+			Labels:      alert.Labels,
+			Annotations: alert.Annotations,
+			StartsAt:    alert.StartsAt,
+			EndsAt:      endsAt,
+		})
+	}
+
+	return postJSON(notifier.client, notifier.url, body)
+}
+
+func (notifier *AlertmanagerNotifier) resendLoop() {
+	defer notifier.done.Done()
+
+	tick := time.NewTicker(alertmanagerResendInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			notifier.mu.Lock()
+			alerts := make([]Alert, 0, len(notifier.firing))
+			for _, alert := range notifier.firing {
+				alerts = append(alerts, alert)
+			}
+			notifier.mu.Unlock()
+
+			if len(alerts) == 0 {
+				continue
+			}
+
+			if err := notifier.send(alerts); err != nil {
+				logrus.Errorf("alertmanager resend: %+v", err)
+			}
+		case <-notifier.stop:
+			return
+		}
+	}
+}
+
+func (notifier *AlertmanagerNotifier) Close() {
+	close(notifier.stop)
+	notifier.done.Wait()
+}
@@ -0,0 +1,116 @@
+package alert
+
+import (
+	"fmt"
+	"time"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+const defaultRuleWindow = 5 * time.Minute
+
+/*
+ * Rule is an alert of a namespace, its condition is evaluated over a window of the
+ * events values:
+ *   - threshold: aggregation (avg, sum, min, max, last, count) of metric
+ *   - rate: sum of metric per second
+ *   - ratio: sum of metric over sum of total, e.g. tech_error / total
+ */
+
+type Rule struct {
+	Name        string            `json:"name" yaml:"name"`
+	Type        string            `json:"type" yaml:"type"`
+	Metric      string            `json:"metric" yaml:"metric"`
+	Total       string            `json:"total" yaml:"total"`
+	Aggregation string            `json:"aggregation" yaml:"aggregation"`
+	Window      prom.Duration     `json:"window" yaml:"window"` // 5m by default
+	Op          string            `json:"op" yaml:"op"`
+	Value       float64           `json:"value" yaml:"value"`
+	For         prom.Duration     `json:"for" yaml:"for"` // time the condition holds before firing
+	Labels      map[string]string `json:"labels" yaml:"labels"`
+	Annotations map[string]string `json:"annotations" yaml:"annotations"`
+}
+
+var comparisons = map[string]func(a float64, b float64) bool{
+	">":  func(a float64, b float64) bool { return a > b },
+	">=": func(a float64, b float64) bool { return a >= b },
+	"<":  func(a float64, b float64) bool { return a < b },
+	"<=": func(a float64, b float64) bool { return a <= b },
+	"==": func(a float64, b float64) bool { return a == b },
+	"!=": func(a float64, b float64) bool { return a != b },
+}
+
+// Validate checks the rule against the metrics of its namespace
+func (rule *Rule) Validate(metrics map[string]*prom.Metric) error {
+	if len(rule.Name) == 0 {
+		return fmt.Errorf("alert rule without name")
+	}
+
+	if _, ok := comparisons[rule.Op]; !ok {
+		return fmt.Errorf("alert %s: unknown op %q", rule.Name, rule.Op)
+	}
+
+	if _, exists := metrics[rule.Metric]; !exists {
+		return fmt.Errorf("alert %s: unknown metric %q", rule.Name, rule.Metric)
+	}
+
+	switch rule.Type {
+	case "threshold":
+		switch rule.Aggregation {
+		case "", "avg", "sum", "min", "max", "last", "count":
+		default:
+			return fmt.Errorf("alert %s: unknown aggregation %q", rule.Name, rule.Aggregation)
+		}
+	case "rate":
+	case "ratio":
+		if _, exists := metrics[rule.Total]; !exists {
+			return fmt.Errorf("alert %s: unknown total metric %q", rule.Name, rule.Total)
+		}
+	default:
+		return fmt.Errorf("alert %s: unknown type %q", rule.Name, rule.Type)
+	}
+
+	return nil
+}
+
+func (rule *Rule) window() time.Duration {
+	if rule.Window == 0 {
+		return defaultRuleWindow
+	}
+
+	return rule.Window.Duration()
+}
+
+// metrics are the metrics whose values the rule needs
+func (rule *Rule) metrics() []string {
+	if rule.Type == "ratio" {
+		return []string{rule.Metric, rule.Total}
+	}
+
+	return []string{rule.Metric}
+}
+
+// evaluate gives the value of the rule and whether its condition holds, a
+// rule without data in its window does not hold
+func (rule *Rule) evaluate(windows map[string]*window, now time.Time) (float64, bool) {
+	aggregate := windows[rule.Metric].aggregate(rule.window(), now)
+
+	var value float64
+	switch rule.Type {
+	case "threshold":
+		if aggregate.count == 0 {
+			return 0, false
+		}
+		value = aggregate.get(rule.Aggregation)
+	case "rate":
+		value = aggregate.sum / rule.window().Seconds()
+	case "ratio":
+		total := windows[rule.Total].aggregate(rule.window(), now)
+		if total.sum == 0 {
+			return 0, false
+		}
+		value = aggregate.sum / total.sum
+	}
+
+	return value, comparisons[rule.Op](value, rule.Value)
+}
@@ -0,0 +1,273 @@
+package alert
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+const (
+	defaultSLOWindow = 30 * 24 * time.Hour
+
+	// number of buckets of the compliance window, and per short burn rate window
+	sloBuckets      = 720
+	burnRateBuckets = 5
+)
+
+// the multiwindow burn rates of the SRE workbook for a 30 days SLO
+var defaultBurnRates = []BurnRate{
+	{Long: prom.Duration(time.Hour), Short: prom.Duration(5 * time.Minute), Factor: 14.4},
+	{Long: prom.Duration(6 * time.Hour), Short: prom.Duration(30 * time.Minute), Factor: 6},
+	{Long: prom.Duration(24 * time.Hour), Short: prom.Duration(2 * time.Hour), Factor: 3},
+	{Long: prom.Duration(3 * 24 * time.Hour), Short: prom.Duration(6 * time.Hour), Factor: 1},
+}
+
+// BurnRate alerts when the error budget burns faster than factor over both windows
+type BurnRate struct {
+	Long   prom.Duration `json:"long" yaml:"long"`
+	Short  prom.Duration `json:"short" yaml:"short"`
+	Factor float64       `json:"factor" yaml:"factor"`
+}
+
+/*
+ * SLO is the ratio of good events over total events a namespace targets over a
+ * rolling window, its error budget is the 1 - target share of bad events.
+ * The windows are kept in memory from the start of the process: after a restart
+ * the compliance and budget only cover the time since, as slo_window_tracked_seconds tells
+ */
+
+type SLO struct {
+	Name        string            `json:"name" yaml:"name"` // slo by default
+	Good        string            `json:"good" yaml:"good"`
+	Total       string            `json:"total" yaml:"total"`
+	Target      float64           `json:"target" yaml:"target"`
+	Window      prom.Duration     `json:"window" yaml:"window"` // 720h (30 days) by default
+	BurnRates   []BurnRate        `json:"burn_rates" yaml:"burn_rates"`
+	Labels      map[string]string `json:"labels" yaml:"labels"`
+	Annotations map[string]string `json:"annotations" yaml:"annotations"`
+}
+
+var sloMetrics = struct {
+	compliance *prometheus.GaugeVec
+	budget     *prometheus.GaugeVec
+	burnRate   *prometheus.GaugeVec
+	tracked    *prometheus.GaugeVec
+}{
+	compliance: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_compliance",
+			Help: "The ratio of good over total events over the SLO window",
+		}, []string{"namespace", "slo"},
+	),
+	budget: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_error_budget_remaining",
+			Help: "The share of the error budget left over the SLO window, negative once exhausted",
+		}, []string{"namespace", "slo"},
+	),
+	burnRate: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_burn_rate",
+			Help: "The ratio of bad events over the error budget ratio, over a window",
+		}, []string{"namespace", "slo", "window"},
+	),
+	tracked: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_window_tracked_seconds",
+			Help: "The part of the SLO window tracked since the process started, the only one the compliance and budget cover (s)",
+		}, []string{"namespace", "slo"},
+	),
+}
+
+// Validate checks the SLO against the metrics of its namespace and sets its defaults
+func (slo *SLO) Validate(metrics map[string]*prom.Metric) error {
+	if len(slo.Name) == 0 {
+		slo.Name = "slo"
+	}
+
+	for _, metric := range []string{slo.Good, slo.Total} {
+		config, exists := metrics[metric]
+		if !exists {
+			return fmt.Errorf("slo %s: unknown metric %q", slo.Name, metric)
+		}
+		if config.Type != "counter" {
+			return fmt.Errorf("slo %s: metric %q must be a counter, not a %s", slo.Name, metric, config.Type)
+		}
+	}
+
+	if slo.Target <= 0 || slo.Target >= 1 {
+		return fmt.Errorf("slo %s: target must be in ]0, 1[", slo.Name)
+	}
+
+	if len(slo.BurnRates) == 0 {
+		slo.BurnRates = defaultBurnRates
+	}
+
+	for _, burnRate := range slo.BurnRates {
+		if burnRate.Short == 0 || burnRate.Long < burnRate.Short || burnRate.Factor <= 0 {
+			return fmt.Errorf("slo %s: burn rate needs 0 < short <= long windows and a positive factor", slo.Name)
+		}
+	}
+
+	return nil
+}
+
+func (slo *SLO) window() time.Duration {
+	if slo.Window == 0 {
+		return defaultSLOWindow
+	}
+
+	return slo.Window.Duration()
+}
+
+// resolution of a window split in n buckets, in whole seconds
+func resolution(length time.Duration, n int) time.Duration {
+	return max(time.Second, (length / time.Duration(n)).Truncate(time.Second))
+}
+
+func formatWindow(length time.Duration) string {
+	switch {
+	case length%(24*time.Hour) == 0:
+		return strconv.Itoa(int(length/(24*time.Hour))) + "d"
+	case length%time.Hour == 0:
+		return strconv.Itoa(int(length/time.Hour)) + "h"
+	case length%time.Minute == 0:
+		return strconv.Itoa(int(length/time.Minute)) + "m"
+	default:
+		return strconv.Itoa(int(length/time.Second)) + "s"
+	}
+}
+
+/*
+ * sloTracker keeps the good and total events over the SLO window at a coarse
+ * resolution, and over the longest burn rate window at a finer one
+ */
+
+type sloTracker struct {
+	config *SLO
+	start  time.Time
+
+	good      *window
+	total     *window
+	burnGood  *window
+	burnTotal *window
+
+	firing []bool
+	since  []time.Time
+}
+
+func newSLOTracker(config *SLO, now time.Time) *sloTracker {
+	var longest, shortest time.Duration = 0, config.BurnRates[0].Short.Duration()
+	for _, burnRate := range config.BurnRates {
+		longest = max(longest, burnRate.Long.Duration())
+		shortest = min(shortest, burnRate.Short.Duration())
+	}
+
+	burnResolution := resolution(shortest, burnRateBuckets)
+	sloResolution := resolution(config.window(), sloBuckets)
+
+	return &sloTracker{
+		config:    config,
+		start:     now,
+		good:      newWindowWithResolution(config.window(), sloResolution),
+		total:     newWindowWithResolution(config.window(), sloResolution),
+		burnGood:  newWindowWithResolution(longest, burnResolution),
+		burnTotal: newWindowWithResolution(longest, burnResolution),
+		firing:    make([]bool, len(config.BurnRates)),
+		since:     make([]time.Time, len(config.BurnRates)),
+	}
+}
+
+func (tracker *sloTracker) observe(metrics map[string]any, now time.Time) {
+	if good, ok := toFloat64(metrics[tracker.config.Good]); ok {
+		tracker.good.add(good, now)
+		tracker.burnGood.add(good, now)
+	}
+
+	if total, ok := toFloat64(metrics[tracker.config.Total]); ok {
+		tracker.total.add(total, now)
+		tracker.burnTotal.add(total, now)
+	}
+}
+
+// burnRate is 1 when the errors exactly spend the budget over the window
+func (tracker *sloTracker) burnRate(length time.Duration, now time.Time) float64 {
+	total := tracker.burnTotal.aggregate(length, now).sum
+	if total == 0 {
+		return 0
+	}
+
+	good := tracker.burnGood.aggregate(length, now).sum
+	return (1 - good/total) / (1 - tracker.config.Target)
+}
+
+// compliance is the ratio of good over total events over the SLO window, false without events
+func (tracker *sloTracker) compliance(now time.Time) (float64, bool) {
+	total := tracker.total.aggregate(tracker.config.window(), now).sum
+	if total == 0 {
+		return 0, false
+	}
+
+	return tracker.good.aggregate(tracker.config.window(), now).sum / total, true
+}
+
+// budgetRemaining is the share of the error budget left, 1 without errors and negative once exhausted
+func budgetRemaining(compliance float64, target float64) float64 {
+	return 1 - (1-compliance)/(1-target)
+}
+
+func (alerts *namespaceAlerts) evaluateSLOs(now time.Time) []Alert {
+	notifications := make([]Alert, 0)
+
+	for _, tracker := range alerts.slos {
+		config := tracker.config
+		labels := prometheus.Labels{"namespace": alerts.name, "slo": config.Name}
+
+		sloMetrics.tracked.With(labels).Set(min(now.Sub(tracker.start), config.window()).Seconds())
+		if compliance, ok := tracker.compliance(now); ok {
+			sloMetrics.compliance.With(labels).Set(compliance)
+			sloMetrics.budget.With(labels).Set(budgetRemaining(compliance, config.Target))
+		}
+
+		burnRates := make(map[time.Duration]float64)
+		for _, burnRate := range config.BurnRates {
+			for _, length := range []time.Duration{burnRate.Long.Duration(), burnRate.Short.Duration()} {
+				if _, exists := burnRates[length]; !exists {
+					burnRates[length] = tracker.burnRate(length, now)
+					sloMetrics.burnRate.With(prometheus.Labels{"namespace": alerts.name, "slo": config.Name, "window": formatWindow(length)}).Set(burnRates[length])
+				}
+			}
+		}
+
+		for i, burnRate := range config.BurnRates {
+			value := burnRates[burnRate.Long.Duration()]
+			burning := value > burnRate.Factor && burnRates[burnRate.Short.Duration()] > burnRate.Factor
+
+			alertLabels := map[string]string{
+				"slo":          config.Name,
+				"long_window":  formatWindow(burnRate.Long.Duration()),
+				"short_window": formatWindow(burnRate.Short.Duration()),
+			}
+			for label, labelValue := range config.Labels {
+				alertLabels[label] = labelValue
+			}
+
+			name := config.Name + "_burn_rate"
+			switch {
+			case burning && !tracker.firing[i]:
+				tracker.firing[i] = true
+				tracker.since[i] = now
+				notifications = append(notifications, alerts.newAlert(name, "firing", value, burnRate.Factor, alertLabels, config.Annotations, now, time.Time{}))
+			case !burning && tracker.firing[i]:
+				tracker.firing[i] = false
+				notifications = append(notifications, alerts.newAlert(name, "resolved", value, burnRate.Factor, alertLabels, config.Annotations, tracker.since[i], now))
+			}
+		}
+	}
+
+	return notifications
+}
@@ -0,0 +1,143 @@
+package alert
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+func TestBurnRate(t *testing.T) {
+	start := time.Date(2024, time.May, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		target float64
+		good   float64 // per minute
+		total  float64 // per minute
+		long   float64
+		short  float64
+	}{
+		{"no errors", 0.999, 100, 100, 0, 0},
+		{"exactly the budget", 0.999, 999, 1000, 1, 1},
+		{"fast burn", 0.999, 98.56, 100, 14.4, 14.4},
+		{"all errors", 0.99, 0, 100, 100, 100},
+		{"no events", 0.99, 0, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		slo := &SLO{Good: "good", Total: "total", Target: test.target, BurnRates: defaultBurnRates}
+		tracker := newSLOTracker(slo, start)
+
+		now := start
+		for i := 0; i < 60; i++ {
+			now = now.Add(time.Minute)
+			tracker.observe(map[string]any{"good": test.good, "total": test.total}, now)
+		}
+
+		if value := tracker.burnRate(time.Hour, now); math.Abs(value-test.long) > 1e-6 {
+			t.Errorf("%s: expected a 1h burn rate of %v, got %v", test.name, test.long, value)
+		}
+		if value := tracker.burnRate(5*time.Minute, now); math.Abs(value-test.short) > 1e-6 {
+			t.Errorf("%s: expected a 5m burn rate of %v, got %v", test.name, test.short, value)
+		}
+	}
+}
+
+func TestBurnRateWindows(t *testing.T) {
+	start := time.Date(2024, time.May, 15, 10, 0, 0, 0, time.UTC)
+	slo := &SLO{Good: "good", Total: "total", Target: 0.99, BurnRates: defaultBurnRates}
+	tracker := newSLOTracker(slo, start)
+
+	// an hour without errors, then 5 minutes of errors only
+	now := start
+	for i := 0; i < 65; i++ {
+		now = now.Add(time.Minute)
+		good := 100.0
+		if i >= 60 {
+			good = 0
+		}
+		tracker.observe(map[string]any{"good": good, "total": 100.0}, now)
+	}
+
+	tests := []struct {
+		length time.Duration
+		value  float64
+	}{
+		{5 * time.Minute, 100},
+		{time.Hour, 100 * 5.0 / 60},
+		{6 * time.Hour, 100 * 5.0 / 65},
+	}
+
+	for _, test := range tests {
+		if value := tracker.burnRate(test.length, now); math.Abs(value-test.value) > 1e-6 {
+			t.Errorf("%v: expected a burn rate of %v, got %v", test.length, test.value, value)
+		}
+	}
+}
+
+func TestBudgetRemaining(t *testing.T) {
+	tests := []struct {
+		compliance float64
+		target     float64
+		budget     float64
+	}{
+		{1, 0.999, 1},
+		{0.999, 0.999, 0},
+		{0.9995, 0.999, 0.5},
+		{0.998, 0.999, -1},
+		{0.95, 0.9, 0.5},
+	}
+
+	for _, test := range tests {
+		if budget := budgetRemaining(test.compliance, test.target); math.Abs(budget-test.budget) > 1e-9 {
+			t.Errorf("budgetRemaining(%v, %v): expected %v, got %v", test.compliance, test.target, test.budget, budget)
+		}
+	}
+}
+
+func TestSLOValidate(t *testing.T) {
+	metrics := map[string]*prom.Metric{
+		"good":    {Type: "counter"},
+		"total":   {Type: "counter"},
+		"latency": {Type: "gauge"},
+	}
+
+	tests := []struct {
+		name  string
+		slo   SLO
+		valid bool
+	}{
+		{"defaults", SLO{Good: "good", Total: "total", Target: 0.999}, true},
+		{"unknown metric", SLO{Good: "good", Total: "missing", Target: 0.999}, false},
+		{"gauge", SLO{Good: "latency", Total: "total", Target: 0.999}, false},
+		{"target of 1", SLO{Good: "good", Total: "total", Target: 1}, false},
+		{"no target", SLO{Good: "good", Total: "total"}, false},
+		{"short longer than long", SLO{Good: "good", Total: "total", Target: 0.99,
+			BurnRates: []BurnRate{{Long: prom.Duration(time.Minute), Short: prom.Duration(time.Hour), Factor: 1}}}, false},
+		{"no factor", SLO{Good: "good", Total: "total", Target: 0.99,
+			BurnRates: []BurnRate{{Long: prom.Duration(time.Hour), Short: prom.Duration(time.Minute)}}}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.slo.Validate(metrics); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestFormatWindow(t *testing.T) {
+	tests := map[time.Duration]string{
+		3 * 24 * time.Hour: "3d",
+		6 * time.Hour:      "6h",
+		30 * time.Minute:   "30m",
+		90 * time.Second:   "90s",
+	}
+
+	for length, expected := range tests {
+		if formatted := formatWindow(length); formatted != expected {
+			t.Errorf("formatWindow(%v): expected %s, got %s", length, expected, formatted)
+		}
+	}
+}
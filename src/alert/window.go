@@ -0,0 +1,119 @@
+package alert
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// resolution of the rule windows, the values of an event are added to the bucket of its arrival
+const windowResolution = 5 * time.Second
+
+type bucket struct {
+	start int64
+	sum   float64
+	min   float64
+	max   float64
+	last  float64
+	count int
+}
+
+type aggregate struct {
+	sum   float64
+	min   float64
+	max   float64
+	last  float64
+	count int
+}
+
+func (a aggregate) get(aggregation string) float64 {
+	switch aggregation {
+	case "sum":
+		return a.sum
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	case "last":
+		return a.last
+	case "count":
+		return float64(a.count)
+	default:
+		return a.sum / float64(a.count)
+	}
+}
+
+/*
+ * window is a ring of buckets covering the longest window of the rules of a metric
+ */
+
+type window struct {
+	resolution time.Duration
+
+	mu      sync.Mutex
+	buckets []bucket
+}
+
+func newWindow(length time.Duration) *window {
+	return newWindowWithResolution(length, windowResolution)
+}
+
+// newWindowWithResolution is for the long windows, resolution is a number of seconds
+func newWindowWithResolution(length time.Duration, resolution time.Duration) *window {
+	return &window{
+		resolution: resolution,
+		buckets:    make([]bucket, int(length/resolution)+1),
+	}
+}
+
+// grow extends the window when another rule needs a longer one
+func (w *window) grow(length time.Duration) {
+	if size := int(length/w.resolution) + 1; size > len(w.buckets) {
+		w.buckets = make([]bucket, size)
+	}
+}
+
+func (w *window) add(value float64, now time.Time) {
+	start := now.Truncate(w.resolution).Unix()
+	index := int((start / int64(w.resolution/time.Second)) % int64(len(w.buckets)))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	b := &w.buckets[index]
+	if b.start != start {
+		*b = bucket{start: start, min: math.Inf(1), max: math.Inf(-1)}
+	}
+
+	b.sum += value
+	b.min = math.Min(b.min, value)
+	b.max = math.Max(b.max, value)
+	b.last = value
+	b.count++
+}
+
+func (w *window) aggregate(length time.Duration, now time.Time) aggregate {
+	from := now.Add(-length).Unix()
+	result := aggregate{min: math.Inf(1), max: math.Inf(-1)}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var lastStart int64
+	for _, b := range w.buckets {
+		if b.count == 0 || b.start <= from {
+			continue
+		}
+
+		result.sum += b.sum
+		result.min = math.Min(result.min, b.min)
+		result.max = math.Max(result.max, b.max)
+		result.count += b.count
+		if b.start > lastStart {
+			lastStart = b.start
+			result.last = b.last
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,401 @@
+package flow
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"example.com/streaming-metrics/src/prom"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ackFlushInterval = 100 * time.Millisecond
+	ackBatchSize     = 1000
+	ackMaxRetries    = 5
+)
+
+type ackRequest struct {
+	msg pulsar.ConsumerMessage
+	err error
+}
+
+// MessageKey identifies a message of a topic partition, down to its index in a batch
+type MessageKey struct {
+	topic  string
+	ledger int64
+	entry  int64
+	batch  int32
+}
+
+func KeyOf(msg pulsar.ConsumerMessage) MessageKey {
+	id := msg.ID()
+	return MessageKey{
+		topic:  msg.Topic(),
+		ledger: id.LedgerID(),
+		entry:  id.EntryID(),
+		batch:  id.BatchIdx(),
+	}
+}
+
+func (key MessageKey) String() string {
+	return fmt.Sprintf("%s:%d:%d:%d", key.topic, key.ledger, key.entry, key.batch)
+}
+
+type inflightMsg struct {
+	msg      pulsar.ConsumerMessage
+	released bool
+	nacked   bool
+}
+
+type pendingAck struct {
+	msg      pulsar.ConsumerMessage
+	covered  int
+	attempts int
+}
+
+/*
+ * ackPartition holds the messages of a partition waiting to be acked. Individual
+ * acks keep the released messages, cumulative acks keep every message in the
+ * order it was received to ack the longest released prefix.
+ */
+
+type ackPartition struct {
+	released []pendingAck
+
+	inflight   []*inflightMsg
+	cumulative *pendingAck
+}
+
+/*
+ * Acknowledger acks the messages once every sink accepted their events, in
+ * batches per partition, retrying the failed acks
+ */
+
+type Acknowledger struct {
+	consumer   pulsar.Consumer
+	cumulative bool
+
+	requests   chan ackRequest
+	partitions map[string]*ackPartition
+	inflight   map[MessageKey]*inflightMsg
+	tracked    chan pulsar.ConsumerMessage
+	pending    int
+
+	stop    chan struct{}
+	stopped chan struct{}
+
+	// for the health checks, times in unix nanoseconds
+	lastReceived atomic.Int64
+	lastAcked    atomic.Int64
+	received     atomic.Int64
+	settled      atomic.Int64
+}
+
+// AckStats tell if the messages received are acked
+type AckStats struct {
+	LastReceived time.Time
+	LastAcked    time.Time
+	Unacked      int64 // received messages not acked, nacked or given up yet
+	Requests     int
+	RequestsCap  int
+}
+
+// NewAcknowledger acks individually, or cumulatively for the exclusive and failover subscriptions
+func NewAcknowledger(consumer pulsar.Consumer, cumulative bool) *Acknowledger {
+	a := &Acknowledger{
+		consumer:   consumer,
+		cumulative: cumulative,
+		requests:   make(chan ackRequest, 2000),
+		partitions: make(map[string]*ackPartition),
+		inflight:   make(map[MessageKey]*inflightMsg),
+		tracked:    make(chan pulsar.ConsumerMessage, 2000),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	a.lastAcked.Store(time.Now().UnixNano())
+
+	return a
+}
+
+func (a *Acknowledger) Stats() AckStats {
+	stats := AckStats{
+		LastAcked:   time.Unix(0, a.lastAcked.Load()),
+		Unacked:     a.received.Load() - a.settled.Load(),
+		Requests:    len(a.requests),
+		RequestsCap: cap(a.requests),
+	}
+	if lastReceived := a.lastReceived.Load(); lastReceived > 0 {
+		stats.LastReceived = time.Unix(0, lastReceived)
+	}
+
+	return stats
+}
+
+// Receive forwards the messages to the consumers, registering their order for the cumulative acks
+func (a *Acknowledger) Receive(consumeChan <-chan pulsar.ConsumerMessage, workChan chan<- pulsar.ConsumerMessage) {
+	for msg := range consumeChan {
+		a.lastReceived.Store(time.Now().UnixNano())
+		a.received.Add(1)
+		prom.MyBasePromMetrics.IncReadMsg(msg.Topic())
+		if a.cumulative {
+			a.tracked <- msg
+		}
+		workChan <- msg
+	}
+}
+
+// Ack is called once every sink accepted the events of msg
+func (a *Acknowledger) Ack(msg pulsar.ConsumerMessage) {
+	a.requests <- ackRequest{msg: msg}
+}
+
+// Nack asks for the redelivery of msg after a sink failed
+func (a *Acknowledger) Nack(msg pulsar.ConsumerMessage, err error) {
+	a.requests <- ackRequest{msg: msg, err: err}
+}
+
+func (a *Acknowledger) partition(topic string) *ackPartition {
+	partition, exists := a.partitions[topic]
+	if !exists {
+		partition = &ackPartition{}
+		a.partitions[topic] = partition
+	}
+
+	return partition
+}
+
+// track appends msg to its partition, a redelivered message takes the place
+// of its nacked copy to keep the order of the ids
+func (a *Acknowledger) track(msg pulsar.ConsumerMessage) {
+	key := KeyOf(msg)
+	if previous, exists := a.inflight[key]; exists && previous.nacked {
+		previous.msg = msg
+		previous.nacked = false
+		return
+	}
+
+	entry := &inflightMsg{msg: msg}
+	a.inflight[key] = entry
+
+	partition := a.partition(msg.Topic())
+	partition.inflight = append(partition.inflight, entry)
+}
+
+// drainTracked registers the received messages, a message is always tracked
+// before the consumers get it so before it can be released
+func (a *Acknowledger) drainTracked() {
+	for {
+		select {
+		case msg := <-a.tracked:
+			a.track(msg)
+		default:
+			return
+		}
+	}
+}
+
+func (a *Acknowledger) drainRequests() {
+	for {
+		select {
+		case request := <-a.requests:
+			a.handle(request)
+		default:
+			return
+		}
+	}
+}
+
+func (a *Acknowledger) handle(request ackRequest) {
+	if a.cumulative {
+		a.drainTracked()
+	}
+
+	if request.err != nil {
+		logrus.Warnf("nack msg %v: %+v", request.msg.ID(), request.err)
+		a.consumer.Nack(request.msg)
+		prom.MyBasePromMetrics.IncNackedMsg()
+		a.settled.Add(1)
+
+		if entry, exists := a.inflight[KeyOf(request.msg)]; a.cumulative && exists {
+			entry.nacked = true
+		}
+		return
+	}
+
+	a.pending++
+	if !a.cumulative {
+		partition := a.partition(request.msg.Topic())
+		partition.released = append(partition.released, pendingAck{msg: request.msg, covered: 1})
+		return
+	}
+
+	if entry, exists := a.inflight[KeyOf(request.msg)]; exists {
+		entry.released = true
+	}
+}
+
+func (a *Acknowledger) flush() {
+	for _, partition := range a.partitions {
+		if a.cumulative {
+			a.flushCumulative(partition)
+		} else {
+			a.flushIndividual(partition)
+		}
+	}
+	a.pending = 0
+}
+
+func (a *Acknowledger) flushIndividual(partition *ackPartition) {
+	failed := partition.released[:0]
+	for _, ack := range partition.released {
+		if a.ack(&ack, a.consumer.Ack(ack.msg)) {
+			failed = append(failed, ack)
+		}
+	}
+	partition.released = failed
+}
+
+// flushCumulative acks the last message of the released prefix, the messages
+// after a pending (or nacked) one wait for it
+func (a *Acknowledger) flushCumulative(partition *ackPartition) {
+	for len(partition.inflight) > 0 {
+		entry := partition.inflight[0]
+		if !entry.released {
+			break
+		}
+
+		partition.inflight = partition.inflight[1:]
+		delete(a.inflight, KeyOf(entry.msg))
+		if partition.cumulative == nil {
+			partition.cumulative = &pendingAck{}
+		}
+		partition.cumulative.msg = entry.msg
+		partition.cumulative.covered++
+		partition.cumulative.attempts = 0
+	}
+
+	if partition.cumulative == nil {
+		return
+	}
+
+	if !a.ack(partition.cumulative, a.consumer.AckCumulative(partition.cumulative.msg)) {
+		partition.cumulative = nil
+	}
+}
+
+// ack accounts for an ack attempt and tells if it must be retried
+func (a *Acknowledger) ack(ack *pendingAck, err error) bool {
+	if err == nil {
+		prom.MyBasePromMetrics.AddProcessedMsg(ack.covered)
+		a.lastAcked.Store(time.Now().UnixNano())
+		a.settled.Add(int64(ack.covered))
+		return false
+	}
+
+	ack.attempts++
+	if ack.attempts >= ackMaxRetries {
+		logrus.Errorf("consumer ack of %v failed %d times, giving up: %+v", ack.msg.ID(), ack.attempts, err)
+		prom.MyBasePromMetrics.IncAckFailures()
+		a.settled.Add(int64(ack.covered))
+		return false
+	}
+
+	logrus.Warnf("consumer ack of %v failed, retrying: %+v", ack.msg.ID(), err)
+	prom.MyBasePromMetrics.IncAckRetries()
+	return true
+}
+
+func (a *Acknowledger) Run() {
+	defer close(a.stopped)
+
+	lastInstant := time.Now()
+	logTick := time.NewTicker(time.Minute)
+	defer logTick.Stop()
+
+	flushTick := time.NewTicker(ackFlushInterval)
+	defer flushTick.Stop()
+
+	var ack float64 = 0
+	for {
+		select {
+		case msg := <-a.tracked:
+			a.track(msg)
+
+		case request := <-a.requests:
+			if request.err == nil {
+				ack++
+			}
+			a.handle(request)
+			if a.pending >= ackBatchSize {
+				a.flush()
+			}
+
+		case <-flushTick.C:
+			a.flush()
+
+		case <-a.stop:
+			a.drainRequests()
+			a.flush()
+			return
+
+		case <-logTick.C:
+			since := time.Since(lastInstant)
+			lastInstant = time.Now()
+			logrus.Infof("Ack rate: %.3f msg/s", ack/float64(since/time.Second))
+			ack = 0
+		}
+	}
+}
+
+// Close flushes the released messages, the sinks must be closed before
+func (a *Acknowledger) Close() {
+	close(a.stop)
+	<-a.stopped
+}
+
+// releaser hands a message to the Acknowledger once every sink is done with it
+type releaser struct {
+	acknowledger *Acknowledger
+	sinks        []Sink
+}
+
+func newReleaser(acknowledger *Acknowledger, outputs []Output) *releaser {
+	sinks := make([]Sink, 0)
+	for _, output := range outputs {
+		if sink, ok := output.(Sink); ok {
+			sinks = append(sinks, sink)
+		}
+	}
+
+	return &releaser{
+		acknowledger: acknowledger,
+		sinks:        sinks,
+	}
+}
+
+func (r *releaser) release(msg pulsar.ConsumerMessage) {
+	if len(r.sinks) == 0 {
+		r.acknowledger.Ack(msg)
+		return
+	}
+
+	pending := atomic.Int32{}
+	pending.Store(int32(len(r.sinks)))
+	failed := atomic.Bool{}
+	done := func(err error) {
+		if err != nil && !failed.Swap(true) {
+			r.acknowledger.Nack(msg, err)
+		}
+
+		if pending.Add(-1) == 0 && !failed.Load() {
+			r.acknowledger.Ack(msg)
+		}
+	}
+
+	for _, sink := range r.sinks {
+		sink.Release(msg, done)
+	}
+}
@@ -0,0 +1,199 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+func TestMain(m *testing.M) {
+	prom.SetupPrometheus(false)
+	os.Exit(m.Run())
+}
+
+type testMessage struct {
+	pulsar.Message
+	topic string
+	id    pulsar.MessageID
+}
+
+func (msg testMessage) Topic() string {
+	return msg.topic
+}
+
+func (msg testMessage) ID() pulsar.MessageID {
+	return msg.id
+}
+
+func message(topic string, entry int64) pulsar.ConsumerMessage {
+	return pulsar.ConsumerMessage{Message: testMessage{topic: topic, id: pulsar.NewMessageID(1, entry, 0, 0)}}
+}
+
+// recordingConsumer records the acks as topic:entry, failing the first failures[topic:entry] ones
+type recordingConsumer struct {
+	pulsar.Consumer
+
+	acked    []string
+	nacked   []string
+	failures map[string]int
+}
+
+func name(msg pulsar.Message) string {
+	return fmt.Sprintf("%s:%d", msg.Topic(), msg.ID().EntryID())
+}
+
+func (consumer *recordingConsumer) ack(msg pulsar.Message) error {
+	if consumer.failures[name(msg)] > 0 {
+		consumer.failures[name(msg)]--
+		return errors.New("ack failed")
+	}
+
+	consumer.acked = append(consumer.acked, name(msg))
+	return nil
+}
+
+func (consumer *recordingConsumer) Ack(msg pulsar.Message) error {
+	return consumer.ack(msg)
+}
+
+func (consumer *recordingConsumer) AckCumulative(msg pulsar.Message) error {
+	return consumer.ack(msg)
+}
+
+func (consumer *recordingConsumer) Nack(msg pulsar.Message) {
+	consumer.nacked = append(consumer.nacked, name(msg))
+}
+
+type ackStep struct {
+	op    string // ack, nack, redeliver or flush
+	topic string
+	entry int64
+}
+
+func TestCumulativeAckOrdering(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []ackStep
+		acked []string
+	}{
+		{
+			"in order",
+			[]ackStep{{"ack", "p0", 1}, {"ack", "p0", 2}, {op: "flush"}},
+			[]string{"p0:2"},
+		},
+		{
+			"waits for the first message",
+			[]ackStep{{"ack", "p0", 2}, {"ack", "p0", 3}, {op: "flush"}, {"ack", "p0", 1}, {op: "flush"}},
+			[]string{"p0:3"},
+		},
+		{
+			"nacked message waits for its redelivery",
+			[]ackStep{{"ack", "p0", 1}, {"nack", "p0", 2}, {"ack", "p0", 3}, {op: "flush"},
+				{"redeliver", "p0", 2}, {op: "flush"}, {"ack", "p0", 2}, {op: "flush"}},
+			[]string{"p0:1", "p0:3"},
+		},
+		{
+			"partitions are independent",
+			[]ackStep{{"ack", "p1", 1}, {"ack", "p0", 2}, {op: "flush"}, {"ack", "p0", 1}, {"ack", "p1", 2}, {op: "flush"}},
+			[]string{"p1:1", "p0:2", "p1:2"},
+		},
+		{
+			"nothing released",
+			[]ackStep{{op: "flush"}},
+			[]string{},
+		},
+	}
+
+	for _, test := range tests {
+		consumer := &recordingConsumer{failures: make(map[string]int)}
+		a := NewAcknowledger(consumer, true)
+		for _, topic := range []string{"p0", "p1"} {
+			for entry := int64(1); entry <= 4; entry++ {
+				a.track(message(topic, entry))
+			}
+		}
+
+		acked := make([]string, 0)
+		for _, step := range test.steps {
+			switch step.op {
+			case "ack":
+				a.handle(ackRequest{msg: message(step.topic, step.entry)})
+			case "nack":
+				a.handle(ackRequest{msg: message(step.topic, step.entry), err: errors.New("sink failed")})
+			case "redeliver":
+				a.track(message(step.topic, step.entry))
+			case "flush":
+				consumer.acked = nil
+				a.flush()
+				// the order of the partitions is not defined within a flush
+				sort.Strings(consumer.acked)
+				acked = append(acked, consumer.acked...)
+			}
+		}
+
+		if !reflect.DeepEqual(acked, test.acked) {
+			t.Errorf("%s: expected the acks %v, got %v", test.name, test.acked, acked)
+		}
+	}
+}
+
+func TestAckRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		cumulative bool
+		failures   int
+		flushes    int
+		acked      bool
+		unacked    int64
+	}{
+		{"individual", false, 0, 1, true, 0},
+		{"individual retried", false, 2, 3, true, 0},
+		{"individual pending retry", false, 2, 2, false, 1},
+		{"individual given up", false, ackMaxRetries, ackMaxRetries, false, 0},
+		{"cumulative retried", true, 1, 2, true, 0},
+		{"cumulative given up", true, ackMaxRetries, ackMaxRetries + 1, false, 0},
+	}
+
+	for _, test := range tests {
+		consumer := &recordingConsumer{failures: map[string]int{"p0:1": test.failures}}
+		a := NewAcknowledger(consumer, test.cumulative)
+		a.received.Add(1)
+
+		msg := message("p0", 1)
+		if test.cumulative {
+			a.track(msg)
+		}
+		a.handle(ackRequest{msg: msg})
+
+		for i := 0; i < test.flushes; i++ {
+			a.flush()
+		}
+
+		if acked := len(consumer.acked) == 1; acked != test.acked {
+			t.Errorf("%s: expected acked %v, got the acks %v", test.name, test.acked, consumer.acked)
+		}
+		if unacked := a.Stats().Unacked; unacked != test.unacked {
+			t.Errorf("%s: expected %d unacked, got %d", test.name, test.unacked, unacked)
+		}
+	}
+}
+
+func TestMessageKey(t *testing.T) {
+	msg := pulsar.ConsumerMessage{Message: testMessage{topic: "persistent://public/default/events-partition-0", id: pulsar.NewMessageID(12, 34, 5, 0)}}
+
+	if key := KeyOf(msg).String(); key != "persistent://public/default/events-partition-0:12:34:5" {
+		t.Errorf("unexpected key %s", key)
+	}
+
+	other := pulsar.ConsumerMessage{Message: testMessage{topic: "persistent://public/default/events-partition-0", id: pulsar.NewMessageID(12, 34, 6, 0)}}
+	if KeyOf(msg) == KeyOf(other) {
+		t.Errorf("expected the messages of a batch to have different keys")
+	}
+}
@@ -11,8 +11,11 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func Consumer(consumeChan <-chan pulsar.ConsumerMessage, ackChan chan<- pulsar.ConsumerMessage, namespaces map[string]*Namespace, filterRoot *FilterRoot) {
+func Consumer(consumeChan <-chan pulsar.ConsumerMessage, acknowledger *Acknowledger, namespaces *Namespaces, filterRoot *FilterRoot, outputs []Output) {
 	var nRead float64 = 0
+	releaser := newReleaser(acknowledger, outputs)
+	state := registerConsumer()
+	defer unregisterConsumer(state)
 
 	lastInstant := time.Now()
 	lastPublishTime := time.Unix(0, 0)
@@ -20,34 +23,38 @@ func Consumer(consumeChan <-chan pulsar.ConsumerMessage, ackChan chan<- pulsar.C
 	defer log_tick.Stop()
 
 	for {
+		state.busy.Store(0)
+
 		select {
 		case msg := <-consumeChan:
 			nRead += 1
 			lastPublishTime = msg.PublishTime()
 			consumeStart := time.Now()
+			state.busy.Store(consumeStart.UnixNano())
 
 			var msgJson map[string]any
 			if err := json.Unmarshal(msg.Payload(), &msgJson); err != nil {
-				logrus.Errorf("filter unmarshal msg: %+v", err)
-				ackChan <- msg
+				prom.ReportError("unmarshal", "", "filter unmarshal msg: %+v", err)
+				releaser.release(msg)
 				continue
 			}
 
 			hostnameAny, ok := msgJson["hstnm"]
 			if !ok {
-				logrus.Error("No hostname found")
-				ackChan <- msg
+				prom.ReportError("no_hostname", "", "No hostname found")
+				releaser.release(msg)
 				continue
 			}
 
 			hostname, ok := hostnameAny.(string)
 			if !ok {
-				logrus.Error("Hostname is not a string")
-				ackChan <- msg
+				prom.ReportError("hostname_type", "", "Hostname is not a string: %T", hostnameAny)
+				releaser.release(msg)
 				continue
 			}
 
 			events := filterEvents(msgJson, filterRoot)
+			publishTaps(msg, msgJson, hostname, events, namespaces)
 
 			filterDur := time.Since(consumeStart)
 			prom.MyBasePromMetrics.ObserveFilterTime(filterDur)
@@ -56,13 +63,21 @@ func Consumer(consumeChan <-chan pulsar.ConsumerMessage, ackChan chan<- pulsar.C
 			for _, event := range events {
 				prom.MyBasePromMetrics.IncNamespaceFilteredMsg(event.namespace)
 
-				namespace, ok := namespaces[event.namespace]
+				namespace, ok := namespaces.Get(event.namespace)
 				if !ok {
-					logrus.Errorf("No namespace named: %s", event.namespace)
+					prom.ReportError("unknown_namespace", event.namespace, "No namespace named: %s", event.namespace)
 					continue
 				}
 
 				updateMetrics(*namespace, hostname, event)
+				prom.MyBasePromMetrics.SetLastEventTime(namespace.Name, hostname, time.Now())
+				if eventTime, ok := event.parseTime(); ok {
+					prom.MyBasePromMetrics.SetEventLag(msg.Topic(), time.Since(eventTime))
+				}
+
+				for _, output := range outputs {
+					output.Push(namespace, hostname, event, msg)
+				}
 			}
 
 			pushDur := time.Since(pushStart)
@@ -70,8 +85,9 @@ func Consumer(consumeChan <-chan pulsar.ConsumerMessage, ackChan chan<- pulsar.C
 
 			processDur := time.Since(consumeStart)
 			prom.MyBasePromMetrics.ObserveProcessingTime(processDur)
+			prom.MyBasePromMetrics.SetPublishLag(msg.Topic(), time.Since(lastPublishTime))
 
-			ackChan <- msg
+			releaser.release(msg)
 
 		case <-log_tick.C:
 			since := time.Since(lastInstant)
@@ -97,7 +113,7 @@ func filterEvents(msgJson map[string]any, filterRoot *FilterRoot) []Event {
 	case []interface{}:
 		results = r
 	default:
-		logrus.Errorf("Unknown type: %T", r)
+		prom.ReportError("group_result_type", "", "Unknown type: %T", r)
 	}
 	logrus.Tracef("groups matched: %+v", results)
 
@@ -107,16 +123,16 @@ func filterEvents(msgJson map[string]any, filterRoot *FilterRoot) []Event {
 		case string:
 			groupName = gn
 		default:
-			logrus.Errorf("Unknown type for element: %T", gn)
+			prom.ReportError("group_result_type", "", "Unknown type for element: %T", gn)
 		}
 
-		groupFilters, ok := filterRoot.groups[groupName]
-		if !ok {
-			logrus.Errorf("filter_root group does not exist: %s", groupName)
+		groupFilters := filterRoot.GetGroup(groupName)
+		if groupFilters == nil {
+			prom.ReportError("unknown_group", "", "filter_root group does not exist: %s", groupName)
 			continue
 		}
 
-		for _, filter := range groupFilters.children {
+		for _, filter := range groupFilters.Children() {
 			event := filterEventByNamespace(filter, msgJson)
 			if event == nil {
 				continue
@@ -129,28 +145,44 @@ func filterEvents(msgJson map[string]any, filterRoot *FilterRoot) []Event {
 	return filteredEvents
 }
 
-func filterEventByNamespace(filter *LeafNode, msgJson any) *Event {
-	iter := filter.Filter.Run(msgJson)
+// runNamespaceFilter gives the first output of a namespace filter, or the error it returned
+func runNamespaceFilter(filter *LeafNode, msgJson any) (any, bool, error) {
+	v, ok := filter.Filter.Run(msgJson).Next()
+	if !ok {
+		return nil, false, nil
+	}
+	if err, ok := v.(error); ok {
+		return nil, true, err
+	}
 
-	v, ok := iter.Next()
+	return v, true, nil
+}
+
+func filterEventByNamespace(filter *LeafNode, msgJson any) *Event {
+	v, ok, err := runNamespaceFilter(filter, msgJson)
 	if !ok {
 		return nil
 	}
-	if _, ok := v.(error); ok {
+	if err != nil {
 		// ignore -- msg is not important for this namespace
-		logrus.Tracef("filter next err: %+v", v.(error))
+		logrus.Tracef("filter next err: %+v", err)
+		return nil
+	}
+
+	event, err := parseEvent(v)
+	if err != nil {
+		prom.ReportError("malformed_event", filter.Namespace, "filterEventByNamespace %+v", err)
 		return nil
 	}
 
-	event := eventFromAny(v)
 	return event
 }
 
 func updateMetrics(namespace Namespace, hostname string, event Event) {
-	for eventMetricName, eventMetric := range event.metrics.(map[string]interface{}) {
+	for eventMetricName, eventMetric := range event.metrics {
 		metric, exists := namespace.Metrics[eventMetricName]
 		if !exists {
-			logrus.Errorf("updateMetrics prometheus metric %v not found", eventMetricName)
+			prom.ReportError("unknown_metric", namespace.Name, "updateMetrics prometheus metric %v not found", eventMetricName)
 			continue
 		}
 
@@ -163,28 +195,3 @@ func updateMetrics(namespace Namespace, hostname string, event Event) {
 		metric.Update(eventMetric, extraLabels)
 	}
 }
-
-func Acknowledger(consumer pulsar.Consumer, ack_chan <-chan pulsar.ConsumerMessage) {
-	lastInstant := time.Now()
-	tick := time.NewTicker(time.Minute)
-	defer tick.Stop()
-
-	var ack float64 = 0
-	for {
-		select {
-		case msg := <-ack_chan:
-			if err := consumer.Ack(msg); err != nil {
-				logrus.Warnf("consumer.Acks err: %+v", err)
-			}
-			ack++
-
-			prom.MyBasePromMetrics.IncProcessedMsg()
-
-		case <-tick.C:
-			since := time.Since(lastInstant)
-			lastInstant = time.Now()
-			logrus.Infof("Ack rate: %.3f msg/s", ack/float64(since/time.Second))
-			ack = 0
-		}
-	}
-}
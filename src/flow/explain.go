@@ -0,0 +1,199 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// outcomes of a namespace filter run on a message
+const (
+	OutcomeEvent        = "event"
+	OutcomeFilterError  = "filter_error"
+	OutcomeRuntimeError = "runtime_error"
+	OutcomeMalformed    = "malformed"
+	OutcomeNoOutput     = "no_output"
+)
+
+// Explanation is what the consumers would do with a message, computed without updating the metrics
+type Explanation struct {
+	Hostname   string                 `json:"hostname,omitempty"`
+	Groups     []string               `json:"groups"`
+	Errors     []string               `json:"errors,omitempty"` // why the message or some of its groups are dropped
+	Namespaces []NamespaceExplanation `json:"namespaces"`
+}
+
+type NamespaceExplanation struct {
+	Namespace string         `json:"namespace"`
+	Group     string         `json:"group"`
+	Outcome   string         `json:"outcome"`
+	Reason    any            `json:"reason,omitempty"` // argument of filter_error
+	Error     string         `json:"error,omitempty"`
+	Output    any            `json:"output,omitempty"` // filter output, when malformed
+	Event     *TapEvent      `json:"event,omitempty"`
+	Updates   []MetricUpdate `json:"updates,omitempty"`
+}
+
+// MetricUpdate is an update of a metric by an event, skipped when it has an error
+type MetricUpdate struct {
+	Metric    string            `json:"metric"`
+	Type      string            `json:"type,omitempty"`
+	Operation string            `json:"operation,omitempty"`
+	Value     any               `json:"value"`
+	Labels    map[string]string `json:"labels"`
+	Error     string            `json:"error,omitempty"`
+}
+
+/*
+ * Explain runs the group filter then the filters of the matched groups on a
+ * message, the same way the consumers do, and reports each step
+ */
+
+func Explain(payload []byte, namespaces *Namespaces, filterRoot *FilterRoot) Explanation {
+	explanation := Explanation{
+		Groups:     make([]string, 0),
+		Namespaces: make([]NamespaceExplanation, 0),
+	}
+
+	var msgJson map[string]any
+	if err := json.Unmarshal(payload, &msgJson); err != nil {
+		explanation.Errors = append(explanation.Errors, fmt.Sprintf("unmarshal msg: %v", err))
+		return explanation
+	}
+
+	hostname, ok := msgJson["hstnm"].(string)
+	if !ok {
+		explanation.Errors = append(explanation.Errors, "no hostname found, or hostname is not a string")
+		return explanation
+	}
+	explanation.Hostname = hostname
+
+	for _, groupName := range explanation.explainGroups(msgJson, filterRoot) {
+		group := filterRoot.GetGroup(groupName)
+		if group == nil {
+			explanation.Errors = append(explanation.Errors, fmt.Sprintf("filter_root group does not exist: %s", groupName))
+			continue
+		}
+
+		for _, leaf := range group.Children() {
+			explanation.Namespaces = append(explanation.Namespaces, explainNamespace(leaf, groupName, hostname, msgJson, namespaces))
+		}
+	}
+
+	return explanation
+}
+
+func (explanation *Explanation) explainGroups(msgJson map[string]any, filterRoot *FilterRoot) []string {
+	v, ok := filterRoot.groupFilter.Run(msgJson).Next()
+	if !ok {
+		return nil
+	}
+
+	var results []any
+	switch r := v.(type) {
+	case []any:
+		results = r
+	case error:
+		explanation.Errors = append(explanation.Errors, "group filter: "+r.Error())
+		return nil
+	default:
+		explanation.Errors = append(explanation.Errors, fmt.Sprintf("group filter returned %T, not an array", r))
+		return nil
+	}
+
+	for _, result := range results {
+		groupName, ok := result.(string)
+		if !ok {
+			explanation.Errors = append(explanation.Errors, fmt.Sprintf("group filter returned a %T element, not a string", result))
+			continue
+		}
+
+		explanation.Groups = append(explanation.Groups, groupName)
+	}
+
+	return explanation.Groups
+}
+
+func explainNamespace(leaf *LeafNode, group string, hostname string, msgJson map[string]any, namespaces *Namespaces) NamespaceExplanation {
+	explanation := NamespaceExplanation{
+		Namespace: leaf.Namespace,
+		Group:     group,
+	}
+
+	v, ok, err := runNamespaceFilter(leaf, msgJson)
+	if !ok {
+		explanation.Outcome = OutcomeNoOutput
+		return explanation
+	}
+
+	if err != nil {
+		var filterErr *FilterError
+		if errors.As(err, &filterErr) {
+			explanation.Outcome = OutcomeFilterError
+			explanation.Reason = filterErr.Reason
+		} else {
+			explanation.Outcome = OutcomeRuntimeError
+		}
+		explanation.Error = err.Error()
+		return explanation
+	}
+
+	event, err := parseEvent(v)
+	if err != nil {
+		explanation.Outcome = OutcomeMalformed
+		explanation.Error = err.Error()
+		explanation.Output = v
+		return explanation
+	}
+
+	explanation.Outcome = OutcomeEvent
+	explanation.Event = &TapEvent{
+		Namespace: event.namespace,
+		Time:      event.time,
+		Metrics:   event.metrics,
+	}
+
+	namespace, exists := namespaces.Get(event.namespace)
+	if !exists {
+		explanation.Error = "no namespace named: " + event.namespace
+		return explanation
+	}
+
+	explanation.Updates = explainUpdates(namespace, hostname, event)
+	return explanation
+}
+
+// explainUpdates lists the updates of updateMetrics, before any series limit folds the labels
+func explainUpdates(namespace *Namespace, hostname string, event *Event) []MetricUpdate {
+	updates := make([]MetricUpdate, 0)
+
+	for eventMetricName, eventMetric := range event.metrics {
+		update := MetricUpdate{
+			Metric: eventMetricName,
+			Value:  eventMetric,
+			Labels: map[string]string{
+				"service":   namespace.Service,
+				"group":     namespace.Group,
+				"namespace": namespace.Name,
+				"hostname":  hostname,
+			},
+		}
+
+		metric, exists := namespace.Metrics[eventMetricName]
+		if exists {
+			update.Type = metric.Type
+			update.Operation = metric.Operation
+			if err := metric.Check(eventMetric); err != nil {
+				update.Error = err.Error()
+			}
+		} else {
+			update.Error = fmt.Sprintf("prometheus metric %v not found", eventMetricName)
+		}
+
+		updates = append(updates, update)
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Metric < updates[j].Metric })
+
+	return updates
+}
@@ -1,6 +1,12 @@
 package flow
 
-import "github.com/itchyny/gojq"
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/itchyny/gojq"
+)
 
 /*
  * Filter root
@@ -8,29 +14,93 @@ import "github.com/itchyny/gojq"
 
 type FilterRoot struct {
 	groupFilter *gojq.Code
-	groups      map[string]*GroupNode
+	groupPath   string
+	groupSource string
+
+	mu     sync.RWMutex
+	groups map[string]*GroupNode
 }
 
-func NewFilterTree(groupFilter *gojq.Code) *FilterRoot {
+// NewFilterTree keeps the path and source of the group filter for inspection
+func NewFilterTree(groupFilter *gojq.Code, path string, source string) *FilterRoot {
 	return &FilterRoot{
 		groupFilter: groupFilter,
+		groupPath:   path,
+		groupSource: source,
 		groups:      make(map[string]*GroupNode),
 	}
 }
 
 func (r *FilterRoot) HasGroup(group string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	_, ok := r.groups[group]
 	return ok
 }
 
 func (r *FilterRoot) AddGroup(group string, node *GroupNode) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.groups[group] = node
 }
 
 func (r *FilterRoot) GetGroup(group string) *GroupNode {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	return r.groups[group]
 }
 
+// Groups are sorted by name
+func (r *FilterRoot) Groups() []*GroupNode {
+	r.mu.RLock()
+	groups := make([]*GroupNode, 0, len(r.groups))
+	for _, group := range r.groups {
+		groups = append(groups, group)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+
+	return groups
+}
+
+func (r *FilterRoot) GroupFilterSource() (path string, source string) {
+	return r.groupPath, r.groupSource
+}
+
+// SetLeaf adds the filter of a namespace to a group, replacing its previous filter
+// in any group, and tells if the group was created
+func (r *FilterRoot) SetLeaf(group string, leaf *LeafNode) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, node := range r.groups {
+		node.removeChild(leaf.Namespace)
+	}
+
+	node, exists := r.groups[group]
+	if !exists {
+		node = NewGroupNode(group)
+		r.groups[group] = node
+	}
+	node.AddChild(leaf)
+
+	return !exists
+}
+
+// RemoveLeaf removes the filter of a namespace, its group stays even when empty
+func (r *FilterRoot) RemoveLeaf(namespace string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, node := range r.groups {
+		node.removeChild(namespace)
+	}
+}
+
 /*
  * GroupNode
  */
@@ -38,6 +108,9 @@ func (r *FilterRoot) GetGroup(group string) *GroupNode {
 type GroupNode struct {
 	name string
 	//group_filter *gojq.Code
+
+	// children are copied on write, a slice returned by Children is never changed
+	mu       sync.RWMutex
 	children []*LeafNode
 }
 
@@ -50,7 +123,35 @@ func NewGroupNode(name string /*, group_filter *gojq.Code*/) *GroupNode {
 }
 
 func (gf *GroupNode) AddChild(leaf *LeafNode) {
-	gf.children = append(gf.children, leaf)
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+
+	children := make([]*LeafNode, 0, len(gf.children)+1)
+	gf.children = append(append(children, gf.children...), leaf)
+}
+
+func (gf *GroupNode) removeChild(namespace string) {
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+
+	children := make([]*LeafNode, 0, len(gf.children))
+	for _, leaf := range gf.children {
+		if leaf.Namespace != namespace {
+			children = append(children, leaf)
+		}
+	}
+	gf.children = children
+}
+
+func (gf *GroupNode) Name() string {
+	return gf.name
+}
+
+func (gf *GroupNode) Children() []*LeafNode {
+	gf.mu.RLock()
+	defer gf.mu.RUnlock()
+
+	return gf.children
 }
 
 /*
@@ -59,4 +160,23 @@ func (gf *GroupNode) AddChild(leaf *LeafNode) {
 
 type LeafNode struct {
 	Filter *gojq.Code
+
+	// Namespace of the filter, with the path and source it was compiled from
+	Namespace string
+	Path      string
+	Source    string
+}
+
+/*
+ * FilterError is returned by filter_error($reason) when a message is not
+ * relevant for a group or a namespace
+ */
+
+type FilterError struct {
+	Scope  string // group or namespace
+	Reason any
+}
+
+func (err *FilterError) Error() string {
+	return fmt.Sprintf("filter_error: not relevant msg for %s: %v", err.Scope, err.Reason)
 }
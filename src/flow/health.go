@@ -0,0 +1,56 @@
+package flow
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// consumerState is the message a consumer goroutine is on, busy is 0 when waiting
+type consumerState struct {
+	busy atomic.Int64
+}
+
+var consumers = struct {
+	mu     sync.Mutex
+	states map[*consumerState]struct{}
+}{
+	states: make(map[*consumerState]struct{}),
+}
+
+func registerConsumer() *consumerState {
+	state := &consumerState{}
+
+	consumers.mu.Lock()
+	consumers.states[state] = struct{}{}
+	consumers.mu.Unlock()
+
+	return state
+}
+
+func unregisterConsumer(state *consumerState) {
+	consumers.mu.Lock()
+	delete(consumers.states, state)
+	consumers.mu.Unlock()
+}
+
+// ConsumerStats are the running consumer goroutines, and the longest time one spent on its message
+type ConsumerStats struct {
+	Alive       int
+	LongestBusy time.Duration
+}
+
+func Consumers() ConsumerStats {
+	consumers.mu.Lock()
+	defer consumers.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	stats := ConsumerStats{Alive: len(consumers.states)}
+	for state := range consumers.states {
+		if busy := state.busy.Load(); busy > 0 {
+			stats.LongestBusy = max(stats.LongestBusy, time.Duration(now-busy))
+		}
+	}
+
+	return stats
+}
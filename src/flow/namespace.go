@@ -1,16 +1,22 @@
 package flow
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 
+	"example.com/streaming-metrics/src/alert"
 	"example.com/streaming-metrics/src/prom"
 )
 
 type Event struct {
 	namespace string
 	time      string
-	metrics   any
+	metrics   map[string]any
 }
 
 type Namespace struct {
@@ -18,6 +24,27 @@ type Namespace struct {
 	Group   string                  `json:"group" yaml:"group"`
 	Service string                  `json:"service" yaml:"service"`
 	Metrics map[string]*prom.Metric `json:"metrics" yaml:"metrics"`
+
+	// Max series shared by all the metrics of the namespace, 0 is unlimited
+	MaxSeries int `json:"max_series" yaml:"max_series"`
+
+	// Destination topic of the namespace events, overrides the default destination topic
+	DestTopic string `json:"dest_topic" yaml:"dest_topic"`
+
+	// Alert rules evaluated over the namespace metrics
+	Alerts []*alert.Rule `json:"alerts,omitempty" yaml:"alerts"`
+
+	// Alerts raised when the namespace or one of its hostnames stops receiving events
+	Absence *alert.Absence `json:"absence,omitempty" yaml:"absence"`
+
+	// Opt-in anomaly detection of metrics of the namespace
+	Anomalies []*alert.Anomaly `json:"anomalies,omitempty" yaml:"anomalies"`
+
+	// Service level objectives tracked over the namespace metrics
+	SLOs []*alert.SLO `json:"slos,omitempty" yaml:"slos"`
+
+	// File the namespace was loaded from
+	Path string `json:"path,omitempty" yaml:"-"`
 }
 
 /*
@@ -25,24 +52,86 @@ type Namespace struct {
  */
 
 func NewNamespace(buf []byte) *Namespace {
+	namespace, err := ParseNamespace(buf)
+	if err != nil {
+		logrus.Errorf("NewNamespace: %+v", err)
+		return nil
+	}
+
+	namespace.Register()
+	return namespace
+}
+
+// ParseNamespace validates a namespace configuration, without registering its metrics
+func ParseNamespace(buf []byte) (*Namespace, error) {
 	var namespace Namespace
 
 	if err := yaml.Unmarshal(buf, &namespace); err != nil {
-		logrus.Errorf("NewNamespace: %+v", err)
-		return nil
+		return nil, err
+	}
+
+	if !namespace.validateConfig() {
+		return nil, errors.New("not a valid config")
 	}
 
 	for metricName, metric := range namespace.Metrics {
 		metric.Name = metricName
-		metric.AddPromMetric()
+		if err := metric.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", namespace.Name, err)
+		}
 	}
 
-	if !namespace.validateConfig() {
-		logrus.Errorf("NewNamespace: not a valid config")
-		return nil
+	for _, rule := range namespace.Alerts {
+		if err := rule.Validate(namespace.Metrics); err != nil {
+			return nil, fmt.Errorf("%s: %w", namespace.Name, err)
+		}
+	}
+
+	for _, anomaly := range namespace.Anomalies {
+		if err := anomaly.Validate(namespace.Metrics); err != nil {
+			return nil, fmt.Errorf("%s: %w", namespace.Name, err)
+		}
+	}
+
+	for _, slo := range namespace.SLOs {
+		if err := slo.Validate(namespace.Metrics); err != nil {
+			return nil, fmt.Errorf("%s: %w", namespace.Name, err)
+		}
+	}
+
+	if namespace.Absence != nil {
+		if err := namespace.Absence.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", namespace.Name, err)
+		}
+	}
+
+	return &namespace, nil
+}
+
+// Register creates the prometheus metrics of the namespace, or reuses those of the same name
+func (namespace *Namespace) Register() {
+	seriesLimit := prom.NewSeriesLimit(namespace.MaxSeries)
+	for _, metric := range namespace.Metrics {
+		metric.AddPromMetric(seriesLimit)
+	}
+}
+
+// CheckRegistered tells if a metric of the namespace would reuse a vector created with other options
+func (namespace *Namespace) CheckRegistered() error {
+	for _, metric := range namespace.Metrics {
+		if err := metric.CheckRegistered(); err != nil {
+			return fmt.Errorf("%s: %w", namespace.Name, err)
+		}
 	}
 
-	return &namespace
+	return nil
+}
+
+// DeleteSeries deletes the series of the namespace metrics, once it is deleted or replaced
+func (namespace *Namespace) DeleteSeries() {
+	for _, metric := range namespace.Metrics {
+		metric.DeleteSeries(namespace.Name)
+	}
 }
 
 func (namespace *Namespace) validateConfig() bool {
@@ -61,26 +150,113 @@ func (namespace *Namespace) validateConfig() bool {
 	return true
 }
 
-func eventFromAny(in any) *Event {
+// parseEvent checks the output of a namespace filter is a log() call
+func parseEvent(in any) (*Event, error) {
 	switch v := in.(type) {
 	case map[string]any:
 		namespace, ok_namespace := v["namespace"].(string)
 		time, ok_time := v["time"].(string)
-		metrics, ok_metrics := v["metrics"]
+		metricsAny, ok_metrics := v["metrics"]
 
 		if !ok_namespace || !ok_time || !ok_metrics {
-			logrus.Errorf("eventFromAny missing field from in map filter - status: namespace(%t) time(%t) metrics(%t)", ok_namespace, ok_time, ok_metrics)
-			return nil
+			return nil, fmt.Errorf("missing field from in map filter - status: namespace(%t) time(%t) metrics(%t)", ok_namespace, ok_time, ok_metrics)
+		}
+
+		metrics, ok := metricsAny.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("metrics is %T, not an object", metricsAny)
 		}
 
 		return &Event{
 			namespace: namespace,
 			time:      time,
 			metrics:   metrics,
-		}
+		}, nil
 	default:
-		logrus.Errorf("eventFromAny filter did not return a map: %+v", in)
-		return nil
+		return nil, fmt.Errorf("filter did not return a map: %+v", in)
+	}
+}
+
+/*
+ * Namespaces are the loaded namespaces, read by the consumers while the admin
+ * api adds, replaces or deletes some
+ */
+
+type Namespaces struct {
+	mu         sync.RWMutex
+	namespaces map[string]*Namespace
+	watchers   []Watcher
+}
+
+func NewNamespaces(namespaces map[string]*Namespace) *Namespaces {
+	catalog := &Namespaces{namespaces: make(map[string]*Namespace, len(namespaces))}
+	for name, namespace := range namespaces {
+		catalog.namespaces[name] = namespace
 	}
 
+	return catalog
+}
+
+func (catalog *Namespaces) Get(name string) (*Namespace, bool) {
+	catalog.mu.RLock()
+	defer catalog.mu.RUnlock()
+
+	namespace, exists := catalog.namespaces[name]
+	return namespace, exists
+}
+
+// All are sorted by name
+func (catalog *Namespaces) All() []*Namespace {
+	catalog.mu.RLock()
+	namespaces := make([]*Namespace, 0, len(catalog.namespaces))
+	for _, namespace := range catalog.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	catalog.mu.RUnlock()
+
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces
+}
+
+func (catalog *Namespaces) Len() int {
+	catalog.mu.RLock()
+	defer catalog.mu.RUnlock()
+
+	return len(catalog.namespaces)
+}
+
+// Watch notifies the watcher of the namespaces put and deleted from now on
+func (catalog *Namespaces) Watch(watcher Watcher) {
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+
+	catalog.watchers = append(catalog.watchers, watcher)
+}
+
+func (catalog *Namespaces) Put(namespace *Namespace) {
+	catalog.mu.Lock()
+	previous := catalog.namespaces[namespace.Name]
+	catalog.namespaces[namespace.Name] = namespace
+	watchers := catalog.watchers
+	catalog.mu.Unlock()
+
+	for _, watcher := range watchers {
+		watcher.NamespaceChanged(previous, namespace)
+	}
+}
+
+func (catalog *Namespaces) Delete(name string) {
+	catalog.mu.Lock()
+	previous, exists := catalog.namespaces[name]
+	delete(catalog.namespaces, name)
+	watchers := catalog.watchers
+	catalog.mu.Unlock()
+
+	if !exists {
+		return
+	}
+
+	for _, watcher := range watchers {
+		watcher.NamespaceChanged(previous, nil)
+	}
 }
@@ -0,0 +1,58 @@
+package flow
+
+import (
+	"testing"
+
+	"github.com/itchyny/gojq"
+)
+
+func compileLeaf(t *testing.T, source string) *LeafNode {
+	program, err := gojq.Parse(source)
+	if err != nil {
+		t.Fatalf("parse %s: %v", source, err)
+	}
+	code, err := gojq.Compile(program)
+	if err != nil {
+		t.Fatalf("compile %s: %v", source, err)
+	}
+
+	return &LeafNode{Filter: code, Namespace: "orders", Source: source}
+}
+
+// the consumers and the explain endpoint agree on the events they accept
+func TestNamespaceFilterOutputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		outcome string
+	}{
+		{"event", `{namespace: "orders", time: "2024-01-01T00:00:00Z", metrics: {requests: 1}}`, OutcomeEvent},
+		{"no output", `empty`, OutcomeNoOutput},
+		{"error", `error("skip")`, OutcomeRuntimeError},
+		{"not an object", `"orders"`, OutcomeMalformed},
+		{"missing time", `{namespace: "orders", metrics: {}}`, OutcomeMalformed},
+		{"metrics array", `{namespace: "orders", time: "t", metrics: [1]}`, OutcomeMalformed},
+		{"metrics number", `{namespace: "orders", time: "t", metrics: 1}`, OutcomeMalformed},
+		{"metrics null", `{namespace: "orders", time: "t", metrics: null}`, OutcomeMalformed},
+	}
+
+	namespaces := NewNamespaces(nil)
+	msgJson := map[string]any{"hstnm": "host"}
+
+	for _, test := range tests {
+		leaf := compileLeaf(t, test.filter)
+
+		explanation := explainNamespace(leaf, "group", "host", msgJson, namespaces)
+		if explanation.Outcome != test.outcome {
+			t.Errorf("%s: expected the outcome %s, got %s (%s)", test.name, test.outcome, explanation.Outcome, explanation.Error)
+		}
+
+		event := filterEventByNamespace(leaf, msgJson)
+		if (event != nil) != (test.outcome == OutcomeEvent) {
+			t.Errorf("%s: the consumers and the explain endpoint disagree, event %v for the outcome %s", test.name, event, test.outcome)
+		}
+		if event != nil && event.Metrics()["requests"] != 1 {
+			t.Errorf("%s: expected the event metrics, got %v", test.name, event.Metrics())
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package flow
+
+import (
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/sirupsen/logrus"
+)
+
+// Output receives every event applied to the metrics of a namespace,
+// with the message it was generated from
+type Output interface {
+	Push(namespace *Namespace, hostname string, event Event, msg pulsar.ConsumerMessage)
+	Close()
+}
+
+// Watcher is an output built from the namespaces, notified when the admin api
+// creates (previous is nil), replaces or deletes (namespace is nil) one
+type Watcher interface {
+	Output
+	NamespaceChanged(previous *Namespace, namespace *Namespace)
+}
+
+// Sink is an output that writes the events durably, a message is only acked
+// once every sink called done without error after its Release
+type Sink interface {
+	Output
+	Release(msg pulsar.ConsumerMessage, done func(error))
+}
+
+func (event Event) Namespace() string {
+	return event.namespace
+}
+
+// RawTime is the time as returned by the filter
+func (event Event) RawTime() string {
+	return event.time
+}
+
+// Time parses the event time, falling back to now when it is not RFC 3339
+func (event Event) Time() time.Time {
+	t, ok := event.parseTime()
+	if !ok {
+		return time.Now()
+	}
+
+	return t
+}
+
+func (event Event) parseTime() (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, event.time)
+	if err != nil {
+		logrus.Tracef("event time %v is not RFC 3339: %+v", event.time, err)
+		return t, false
+	}
+
+	return t, true
+}
+
+func (event Event) Metrics() map[string]any {
+	return event.metrics
+}
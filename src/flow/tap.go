@@ -0,0 +1,190 @@
+package flow
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+const tapBufferSize = 64
+
+var ErrTooManyTaps = errors.New("too many taps")
+
+// TapMessage is a raw message with the events it produced for the tapped namespace or group
+type TapMessage struct {
+	MessageID   string         `json:"message_id"`
+	PublishTime time.Time      `json:"publish_time"`
+	Hostname    string         `json:"hostname"`
+	Message     map[string]any `json:"message"`
+	Events      []TapEvent     `json:"events"`
+	Dropped     uint64         `json:"dropped"` // messages not sent to this tap so far, over its rate or buffer
+}
+
+type TapEvent struct {
+	Namespace string `json:"namespace"`
+	Time      string `json:"time"`
+	Metrics   any    `json:"metrics"`
+}
+
+type TapFilter struct {
+	Namespace string
+	Group     string
+	Hostname  string
+	Sample    float64 // share of the messages tapped, in ]0, 1]
+}
+
+/*
+ * Tap receives the messages matching its filter, dropping them when its client
+ * is slower than the consumers or above the rate
+ */
+
+type Tap struct {
+	filter   TapFilter
+	messages chan TapMessage
+	dropped  atomic.Uint64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	rate   float64
+}
+
+func (tap *Tap) Messages() <-chan TapMessage {
+	return tap.messages
+}
+
+// allow takes a token of the bucket refilled at rate per second, holding a second of burst,
+// at least one message so a rate below 1 still lets messages through
+func (tap *Tap) allow(now time.Time) bool {
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+
+	tap.tokens = min(tap.burst(), tap.tokens+now.Sub(tap.last).Seconds()*tap.rate)
+	tap.last = now
+	if tap.tokens < 1 {
+		return false
+	}
+
+	tap.tokens--
+	return true
+}
+
+/*
+ * taps registry, the consumers only check the active count when nobody taps
+ */
+
+var taps = struct {
+	active atomic.Int32
+
+	mu   sync.RWMutex
+	taps map[*Tap]struct{}
+}{
+	taps: make(map[*Tap]struct{}),
+}
+
+// Subscribe starts a tap, up to maxTaps at once, each receiving at most rate messages per second
+func Subscribe(filter TapFilter, maxTaps int, rate float64) (*Tap, error) {
+	taps.mu.Lock()
+	defer taps.mu.Unlock()
+
+	if len(taps.taps) >= maxTaps {
+		return nil, ErrTooManyTaps
+	}
+
+	tap := &Tap{
+		filter:   filter,
+		messages: make(chan TapMessage, tapBufferSize),
+		tokens:   max(1, rate),
+		last:     time.Now(),
+		rate:     rate,
+	}
+	taps.taps[tap] = struct{}{}
+	taps.active.Store(int32(len(taps.taps)))
+
+	return tap, nil
+}
+
+func (tap *Tap) burst() float64 {
+	return max(1, tap.rate)
+}
+
+func Unsubscribe(tap *Tap) {
+	taps.mu.Lock()
+	defer taps.mu.Unlock()
+
+	delete(taps.taps, tap)
+	taps.active.Store(int32(len(taps.taps)))
+}
+
+// matching keeps the events of the tapped namespace or group
+func (filter *TapFilter) matching(hostname string, events []Event, namespaces *Namespaces) []TapEvent {
+	if len(filter.Hostname) > 0 && filter.Hostname != hostname {
+		return nil
+	}
+
+	matching := make([]TapEvent, 0)
+	for _, event := range events {
+		if len(filter.Namespace) > 0 && event.namespace != filter.Namespace {
+			continue
+		}
+
+		if len(filter.Group) > 0 {
+			namespace, exists := namespaces.Get(event.namespace)
+			if !exists || namespace.Group != filter.Group {
+				continue
+			}
+		}
+
+		matching = append(matching, TapEvent{
+			Namespace: event.namespace,
+			Time:      event.time,
+			Metrics:   event.metrics,
+		})
+	}
+
+	return matching
+}
+
+// publishTaps hands the message to the taps it matches, never blocking the consumer
+func publishTaps(msg pulsar.ConsumerMessage, msgJson map[string]any, hostname string, events []Event, namespaces *Namespaces) {
+	if taps.active.Load() == 0 {
+		return
+	}
+
+	taps.mu.RLock()
+	defer taps.mu.RUnlock()
+
+	now := time.Now()
+	for tap := range taps.taps {
+		matching := tap.filter.matching(hostname, events, namespaces)
+		if len(matching) == 0 {
+			continue
+		}
+
+		if tap.filter.Sample < 1 && rand.Float64() >= tap.filter.Sample {
+			continue
+		}
+
+		if !tap.allow(now) {
+			tap.dropped.Add(1)
+			continue
+		}
+
+		select {
+		case tap.messages <- TapMessage{
+			MessageID:   msg.ID().String(),
+			PublishTime: msg.PublishTime(),
+			Hostname:    hostname,
+			Message:     msgJson,
+			Events:      matching,
+			Dropped:     tap.dropped.Load(),
+		}:
+		default:
+			tap.dropped.Add(1)
+		}
+	}
+}
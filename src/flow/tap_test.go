@@ -0,0 +1,39 @@
+package flow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTapAllow(t *testing.T) {
+	start := time.Date(2024, time.May, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		rate    float64
+		elapsed time.Duration // between the messages
+		allowed int           // of 10 messages
+	}{
+		{10, 0, 10},
+		{5, 0, 5},
+		{0.5, 0, 1},
+		{0.5, time.Second, 5},
+		{0.1, time.Second, 1},
+	}
+
+	for _, test := range tests {
+		tap := &Tap{rate: test.rate, tokens: max(1, test.rate), last: start}
+
+		allowed := 0
+		now := start
+		for i := 0; i < 10; i++ {
+			if tap.allow(now) {
+				allowed++
+			}
+			now = now.Add(test.elapsed)
+		}
+
+		if allowed != test.allowed {
+			t.Errorf("rate %v every %v: expected %d messages, got %d", test.rate, test.elapsed, test.allowed, allowed)
+		}
+	}
+}
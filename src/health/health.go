@@ -0,0 +1,302 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/flow"
+)
+
+const (
+	StatusOk   = "ok"
+	StatusWarn = "warn" // reported, the process stays ready
+	StatusFail = "fail"
+
+	// share of a channel capacity above which it is reported saturated
+	saturationWarning = 0.9
+)
+
+type Check struct {
+	Status string         `json:"status"`
+	Detail string         `json:"detail,omitempty"`
+	Values map[string]any `json:"values,omitempty"`
+}
+
+// Report is the breakdown of the checks, its status is the worst of theirs
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+func (report *Report) add(name string, check Check) {
+	report.Checks[name] = check
+	if check.Status == StatusFail || (check.Status == StatusWarn && report.Status == StatusOk) {
+		report.Status = check.Status
+	}
+}
+
+type Options struct {
+	Consumers     int           // number of consumer goroutines started
+	StallTimeout  time.Duration // a consumer on the same message for longer is stalled, failing readiness
+	MaxIdle       time.Duration // max time without receiving a message, 0 disables
+	MaxAckDelay   time.Duration // max time without an ack while messages wait for one
+	ProbeInterval time.Duration // time between the checks of the pulsar connection
+}
+
+type channel struct {
+	name string
+	len  func() int
+	cap  int
+}
+
+type probe struct {
+	start   time.Time
+	end     time.Time
+	err     error
+	pending bool
+}
+
+/*
+ * Checker tells if the consumers are alive, and ready when the pulsar
+ * connection answers and the messages flow from the consumer to the acks.
+ * A stalled consumer is not ready rather than dead: it can be waiting on a
+ * slow sink, which a restart does not fix.
+ */
+
+type Checker struct {
+	options      Options
+	consumer     pulsar.Consumer
+	acknowledger *flow.Acknowledger
+	channels     []channel
+
+	probe atomic.Pointer[probe]
+}
+
+var (
+	started atomic.Bool
+	current atomic.Pointer[Checker]
+)
+
+// Setup exposes /live and /ready, not ready until the process is started
+func Setup() {
+	http.HandleFunc("/live", liveHandler)
+	http.HandleFunc("/ready", readyHandler)
+}
+
+// SetStarted tells if the process is past its startup and not shutting down
+func SetStarted(value bool) {
+	started.Store(value)
+}
+
+func NewChecker(options Options, consumer pulsar.Consumer, acknowledger *flow.Acknowledger) *Checker {
+	return &Checker{
+		options:      options,
+		consumer:     consumer,
+		acknowledger: acknowledger,
+	}
+}
+
+// AddChannel reports the occupancy of a channel
+func AddChannel[T any](checker *Checker, name string, ch chan T) {
+	checker.channels = append(checker.channels, channel{
+		name: name,
+		len:  func() int { return len(ch) },
+		cap:  cap(ch),
+	})
+}
+
+// Start makes the checker answer /live and /ready, and probes pulsar until the process exits
+func (checker *Checker) Start() {
+	checker.probe.Store(&probe{pending: true, start: time.Now()})
+	current.Store(checker)
+
+	go func() {
+		tick := time.NewTicker(checker.options.ProbeInterval)
+		defer tick.Stop()
+
+		for {
+			checker.probePulsar()
+			<-tick.C
+		}
+	}()
+}
+
+// probePulsar asks the last message ids of the topics, failing when the broker is unreachable
+func (checker *Checker) probePulsar() {
+	start := time.Now()
+	previous := checker.probe.Load()
+	checker.probe.Store(&probe{pending: true, start: start, end: previous.end, err: previous.err})
+
+	_, err := checker.consumer.GetLastMessageIDs()
+	if err != nil {
+		logrus.Warnf("health pulsar probe: %+v", err)
+	}
+	checker.probe.Store(&probe{start: start, end: time.Now(), err: err})
+}
+
+func (checker *Checker) checkPulsar(now time.Time) Check {
+	probe := checker.probe.Load()
+	switch {
+	case probe.pending && now.Sub(probe.start) > checker.options.ProbeInterval:
+		return Check{Status: StatusFail, Detail: fmt.Sprintf("no answer for %.0fs", now.Sub(probe.start).Seconds())}
+	case probe.end.IsZero():
+		return Check{Status: StatusOk, Detail: "first probe pending"}
+	case probe.err != nil:
+		return Check{Status: StatusFail, Detail: probe.err.Error(), Values: map[string]any{"checked_at": probe.end}}
+	default:
+		return Check{Status: StatusOk, Values: map[string]any{"checked_at": probe.end}}
+	}
+}
+
+func (checker *Checker) checkReceived(stats flow.AckStats, now time.Time) Check {
+	check := Check{Status: StatusOk, Values: map[string]any{}}
+	if stats.LastReceived.IsZero() {
+		check.Detail = "no message received yet"
+	} else {
+		check.Values["last"] = stats.LastReceived
+		check.Values["seconds_since"] = now.Sub(stats.LastReceived).Seconds()
+	}
+
+	if checker.options.MaxIdle > 0 && !stats.LastReceived.IsZero() && now.Sub(stats.LastReceived) > checker.options.MaxIdle {
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("no message for more than %v", checker.options.MaxIdle)
+	}
+
+	return check
+}
+
+func (checker *Checker) checkAcked(stats flow.AckStats, now time.Time) Check {
+	check := Check{
+		Status: StatusOk,
+		Values: map[string]any{
+			"last":          stats.LastAcked,
+			"seconds_since": now.Sub(stats.LastAcked).Seconds(),
+			"unacked":       stats.Unacked,
+		},
+	}
+
+	if stats.Unacked > 0 && now.Sub(stats.LastAcked) > checker.options.MaxAckDelay {
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("%d messages waiting, no ack for more than %v", stats.Unacked, checker.options.MaxAckDelay)
+	}
+
+	return check
+}
+
+func (checker *Checker) checkConsumers(stats flow.ConsumerStats) Check {
+	check := Check{
+		Status: StatusOk,
+		Values: map[string]any{
+			"alive":    stats.Alive,
+			"expected": checker.options.Consumers,
+		},
+	}
+
+	if stats.Alive < checker.options.Consumers {
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("%d consumers stopped", checker.options.Consumers-stats.Alive)
+	}
+
+	return check
+}
+
+func (checker *Checker) checkStalled(stats flow.ConsumerStats) Check {
+	check := Check{
+		Status: StatusOk,
+		Values: map[string]any{"longest_busy_seconds": stats.LongestBusy.Seconds()},
+	}
+
+	if stats.LongestBusy > checker.options.StallTimeout {
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("a consumer is on the same message for more than %v", checker.options.StallTimeout)
+	}
+
+	return check
+}
+
+func (checker *Checker) checkChannels(stats flow.AckStats) Check {
+	check := Check{Status: StatusOk, Values: map[string]any{}}
+
+	channels := append([]channel{}, checker.channels...)
+	channels = append(channels, channel{name: "ack", len: func() int { return stats.Requests }, cap: stats.RequestsCap})
+	for _, channel := range channels {
+		length := channel.len()
+		saturation := 1.0
+		if channel.cap > 0 {
+			saturation = float64(length) / float64(channel.cap)
+		}
+		check.Values[channel.name] = map[string]any{"len": length, "cap": channel.cap, "saturation": saturation}
+
+		if saturation >= saturationWarning {
+			check.Status = StatusWarn
+			check.Detail = "saturated channels slow down the consumers"
+		}
+	}
+
+	return check
+}
+
+func (checker *Checker) live() Report {
+	report := Report{Status: StatusOk, Checks: make(map[string]Check)}
+	report.add("consumers", checker.checkConsumers(flow.Consumers()))
+
+	return report
+}
+
+func (checker *Checker) ready() Report {
+	now := time.Now()
+	stats := checker.acknowledger.Stats()
+
+	report := checker.live()
+	report.add("stalled", checker.checkStalled(flow.Consumers()))
+	report.add("pulsar", checker.checkPulsar(now))
+	report.add("received", checker.checkReceived(stats, now))
+	report.add("acked", checker.checkAcked(stats, now))
+	report.add("channels", checker.checkChannels(stats))
+
+	return report
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status == StatusFail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logrus.Errorf("health encode: %+v", err)
+	}
+}
+
+// liveHandler fails when the consumers stopped, a restart being the fix
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	report := Report{Status: StatusOk, Checks: make(map[string]Check)}
+	if checker := current.Load(); checker != nil {
+		report = checker.live()
+	}
+
+	writeReport(w, report)
+}
+
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	report := Report{Status: StatusOk, Checks: make(map[string]Check)}
+	if checker := current.Load(); checker != nil {
+		report = checker.ready()
+	}
+
+	if !started.Load() {
+		report.add("startup", Check{Status: StatusFail, Detail: "starting or shutting down"})
+	} else {
+		report.add("startup", Check{Status: StatusOk})
+	}
+
+	writeReport(w, report)
+}
@@ -0,0 +1,173 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/streaming-metrics/src/flow"
+)
+
+var now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestChecker() *Checker {
+	return NewChecker(Options{
+		Consumers:     4,
+		StallTimeout:  time.Minute,
+		MaxIdle:       5 * time.Minute,
+		MaxAckDelay:   30 * time.Second,
+		ProbeInterval: 10 * time.Second,
+	}, nil, nil)
+}
+
+func TestReportStatus(t *testing.T) {
+	tests := []struct {
+		statuses []string
+		status   string
+	}{
+		{nil, StatusOk},
+		{[]string{StatusOk, StatusOk}, StatusOk},
+		{[]string{StatusOk, StatusWarn}, StatusWarn},
+		{[]string{StatusWarn, StatusFail, StatusOk}, StatusFail},
+		{[]string{StatusFail, StatusWarn}, StatusFail},
+	}
+
+	for _, test := range tests {
+		report := Report{Status: StatusOk, Checks: make(map[string]Check)}
+		for i, status := range test.statuses {
+			report.add(string(rune('a'+i)), Check{Status: status})
+		}
+
+		if report.Status != test.status || len(report.Checks) != len(test.statuses) {
+			t.Errorf("%v: expected %s, got %s with %d checks", test.statuses, test.status, report.Status, len(report.Checks))
+		}
+	}
+}
+
+func TestCheckConsumers(t *testing.T) {
+	checker := newTestChecker()
+
+	tests := []struct {
+		name    string
+		stats   flow.ConsumerStats
+		alive   string
+		stalled string
+	}{
+		{"all waiting", flow.ConsumerStats{Alive: 4}, StatusOk, StatusOk},
+		{"busy", flow.ConsumerStats{Alive: 4, LongestBusy: 59 * time.Second}, StatusOk, StatusOk},
+		{"stalled", flow.ConsumerStats{Alive: 4, LongestBusy: 2 * time.Minute}, StatusOk, StatusFail},
+		{"stopped", flow.ConsumerStats{Alive: 3}, StatusFail, StatusOk},
+	}
+
+	for _, test := range tests {
+		if check := checker.checkConsumers(test.stats); check.Status != test.alive {
+			t.Errorf("%s: expected the consumers %s, got %s (%s)", test.name, test.alive, check.Status, check.Detail)
+		}
+		if check := checker.checkStalled(test.stats); check.Status != test.stalled {
+			t.Errorf("%s: expected the stall %s, got %s (%s)", test.name, test.stalled, check.Status, check.Detail)
+		}
+	}
+}
+
+func TestCheckPulsar(t *testing.T) {
+	checker := newTestChecker()
+
+	tests := []struct {
+		name   string
+		probe  probe
+		status string
+	}{
+		{"first probe", probe{pending: true, start: now.Add(-time.Second)}, StatusOk},
+		{"first probe timed out", probe{pending: true, start: now.Add(-time.Minute)}, StatusFail},
+		{"answered", probe{start: now.Add(-2 * time.Second), end: now.Add(-time.Second)}, StatusOk},
+		{"failed", probe{start: now.Add(-2 * time.Second), end: now.Add(-time.Second), err: errors.New("unreachable")}, StatusFail},
+		{"probing after an answer", probe{pending: true, start: now.Add(-time.Second), end: now.Add(-10 * time.Second)}, StatusOk},
+		{"probing after a failure", probe{pending: true, start: now.Add(-time.Second), end: now.Add(-10 * time.Second), err: errors.New("unreachable")}, StatusFail},
+		{"probe timed out", probe{pending: true, start: now.Add(-time.Minute), end: now.Add(-70 * time.Second)}, StatusFail},
+	}
+
+	for _, test := range tests {
+		checker.probe.Store(&test.probe)
+		if check := checker.checkPulsar(now); check.Status != test.status {
+			t.Errorf("%s: expected %s, got %s (%s)", test.name, test.status, check.Status, check.Detail)
+		}
+	}
+}
+
+func TestCheckReceived(t *testing.T) {
+	checker := newTestChecker()
+
+	tests := []struct {
+		name     string
+		received time.Time
+		maxIdle  time.Duration
+		status   string
+	}{
+		{"nothing yet", time.Time{}, 5 * time.Minute, StatusOk},
+		{"recent", now.Add(-time.Minute), 5 * time.Minute, StatusOk},
+		{"idle", now.Add(-10 * time.Minute), 5 * time.Minute, StatusFail},
+		{"idle check disabled", now.Add(-10 * time.Minute), 0, StatusOk},
+	}
+
+	for _, test := range tests {
+		checker.options.MaxIdle = test.maxIdle
+		if check := checker.checkReceived(flow.AckStats{LastReceived: test.received}, now); check.Status != test.status {
+			t.Errorf("%s: expected %s, got %s (%s)", test.name, test.status, check.Status, check.Detail)
+		}
+	}
+}
+
+func TestCheckAcked(t *testing.T) {
+	checker := newTestChecker()
+
+	tests := []struct {
+		name   string
+		stats  flow.AckStats
+		status string
+	}{
+		{"acking", flow.AckStats{LastAcked: now.Add(-time.Second), Unacked: 100}, StatusOk},
+		{"nothing to ack", flow.AckStats{LastAcked: now.Add(-time.Hour)}, StatusOk},
+		{"no ack", flow.AckStats{LastAcked: now.Add(-time.Minute), Unacked: 1}, StatusFail},
+	}
+
+	for _, test := range tests {
+		if check := checker.checkAcked(test.stats, now); check.Status != test.status {
+			t.Errorf("%s: expected %s, got %s (%s)", test.name, test.status, check.Status, check.Detail)
+		}
+	}
+}
+
+func TestCheckChannels(t *testing.T) {
+	checker := newTestChecker()
+	consume := make(chan int, 10)
+	AddChannel(checker, "consume", consume)
+
+	tests := []struct {
+		name     string
+		consumed int
+		requests int
+		status   string
+	}{
+		{"empty", 0, 0, StatusOk},
+		{"busy", 8, 50, StatusOk},
+		{"consume saturated", 9, 0, StatusWarn},
+		{"ack saturated", 0, 95, StatusWarn},
+	}
+
+	for _, test := range tests {
+		for len(consume) < test.consumed {
+			consume <- 0
+		}
+		for len(consume) > test.consumed {
+			<-consume
+		}
+
+		check := checker.checkChannels(flow.AckStats{Requests: test.requests, RequestsCap: 100})
+		if check.Status != test.status {
+			t.Errorf("%s: expected %s, got %s", test.name, test.status, check.Status)
+		}
+		if len(check.Values) != 2 {
+			t.Errorf("%s: expected the consume and ack channels, got %v", test.name, check.Values)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jnovack/flag"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// options whose whole value is a secret, the urls only have their password and query values redacted
+var secretOptions = map[string]bool{
+	"db_dsn": true,
+}
+
+// options whose url paths hold a secret, as the webhook tokens
+var secretPathOptions = map[string]bool{
+	"alert_webhook_url": true,
+}
+
+/*
+ * Config files set the options not given as flags or environment variables.
+ * Their keys are the flag names, or nested tables whose keys join with "_":
+ *
+ *   pulsar:
+ *     url: pulsar://localhost:6650
+ *     topic: [persistent://public/default/a, persistent://public/default/b]
+ *
+ * sets pulsar_url and pulsar_topic, the lists being joined with ";"
+ */
+
+func applyConfigFile(path string) error {
+	values, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, name := range sortedKeys(values) {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("config %s: unknown option %s", path, name)
+		}
+
+		if set[name] {
+			continue
+		}
+
+		if err := flag.Set(name, values[name]); err != nil {
+			return fmt.Errorf("config %s: %s: %w", path, name, err)
+		}
+	}
+
+	return nil
+}
+
+func loadConfigFile(path string) (map[string]string, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]any
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(buf, &tree)
+	case ".toml":
+		tree, err = parseToml(string(buf))
+	default:
+		return nil, fmt.Errorf("config %s: expected a .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	if err := flatten("", tree, values, make(map[string]bool)); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+func flatten(prefix string, tree map[string]any, values map[string]string, seen map[string]bool) error {
+	for key, value := range tree {
+		name := key
+		if len(prefix) > 0 {
+			name = prefix + "_" + key
+		}
+
+		switch v := value.(type) {
+		case map[string]any:
+			if err := flatten(name, v, values, seen); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ";")
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+
+		if _, nested := value.(map[string]any); !nested && seen[name] {
+			return fmt.Errorf("option %s is set twice", name)
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
+
+/*
+ * parseToml reads the toml subset of the config files: [table] headers, and
+ * key = value lines of strings, numbers, booleans and one line arrays of those
+ */
+
+func parseToml(content string) (map[string]any, error) {
+	tree := make(map[string]any)
+	table := tree
+
+	for n, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		if len(line) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: unsupported table header %q", n+1, line)
+			}
+
+			table = tree
+			for _, key := range strings.Split(strings.Trim(line, "[]"), ".") {
+				key = strings.TrimSpace(key)
+				child, ok := table[key].(map[string]any)
+				if !ok {
+					child = make(map[string]any)
+					table[key] = child
+				}
+				table = child
+			}
+			continue
+		}
+
+		key, raw, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected key = value", n+1)
+		}
+
+		value, err := parseTomlValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		table[strings.Trim(strings.TrimSpace(key), `"`)] = value
+	}
+
+	return tree, nil
+}
+
+// stripComment cuts the line at the first # outside of a string
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+func parseTomlValue(raw string) (any, error) {
+	switch {
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return nil, errors.New("arrays must be on one line")
+		}
+
+		items := make([]any, 0)
+		for _, item := range splitArray(raw[1 : len(raw)-1]) {
+			if item = strings.TrimSpace(item); len(item) == 0 {
+				continue
+			}
+
+			value, err := parseTomlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return nil, fmt.Errorf("unterminated string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+
+	case raw == "true" || raw == "false":
+		return raw == "true", nil
+	}
+
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(raw, "_", ""), 64); err != nil {
+		return nil, fmt.Errorf("unsupported value %s", raw)
+	}
+	return strings.ReplaceAll(raw, "_", ""), nil
+}
+
+// splitArray splits the items of an array on the commas outside of strings
+func splitArray(raw string) []string {
+	items := make([]string, 0)
+	var quote rune
+	start := 0
+	for i, c := range raw {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == ',':
+			items = append(items, raw[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(items, raw[start:])
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// redact hides the secret options, and the passwords, query values and secret paths of the urls
+func redact(name string, value string) string {
+	if len(value) == 0 {
+		return value
+	}
+
+	if secretOptions[name] {
+		return redacted
+	}
+
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		parts[i] = redactUrl(part, secretPathOptions[name])
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// redactUrl leaves the values that are not urls with a host, a host:port only
+// being parsed as one when it has a user (user:password@host:port)
+func redactUrl(value string, secretPath bool) string {
+	schemeless := !strings.Contains(value, "://") && strings.Contains(value, "@")
+	raw := value
+	if schemeless {
+		raw = "//" + value
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || len(u.Host) == 0 {
+		return value
+	}
+
+	changed := false
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		changed = true
+	}
+
+	if len(u.RawQuery) > 0 {
+		query := u.Query()
+		for key := range query {
+			query[key] = []string{"xxxxx"}
+		}
+		u.RawQuery = query.Encode()
+		changed = true
+	}
+
+	if secretPath && len(strings.Trim(u.Path, "/")) > 0 {
+		u.Path = "/xxxxx"
+		u.RawPath = ""
+		changed = true
+	}
+
+	if !changed {
+		return value
+	}
+
+	if schemeless {
+		return strings.TrimPrefix(u.String(), "//")
+	}
+	return u.String()
+}
+
+// effectiveOptions are the values of all the options, the secrets redacted
+func effectiveOptions() map[string]any {
+	options := make(map[string]any)
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if redactedValue := redact(f.Name, value); redactedValue != value {
+			options[f.Name] = redactedValue
+		} else if getter, ok := f.Value.(flag.Getter); ok {
+			options[f.Name] = getter.Get()
+		} else {
+			options[f.Name] = value
+		}
+	})
+
+	return options
+}
+
+/*
+ * runCommand runs the command given after the flags:
+ *   config print  the effective options as a yaml config file, failing when they conflict
+ */
+
+func runCommand(args []string, opt opt) {
+	if len(args) != 2 || args[0] != "config" || args[1] != "print" {
+		logrus.Fatalf("unknown command: %s (expected: config print)", strings.Join(args, " "))
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	if err := encoder.Encode(effectiveOptions()); err != nil {
+		logrus.Fatalf("runCommand encode: %+v", err)
+	}
+	encoder.Close()
+
+	if err := opt.validate(); err != nil {
+		logrus.Fatalf("invalid options: %+v", err)
+	}
+}
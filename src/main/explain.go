@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/prom"
+)
+
+// explainFile prints how the loaded filters handle the message of a file, - for stdin
+func explainFile(path string, opt opt) {
+	var payload []byte
+	var err error
+	if path == "-" {
+		payload, err = io.ReadAll(os.Stdin)
+	} else {
+		payload, err = os.ReadFile(path)
+	}
+	if err != nil {
+		logrus.Fatalf("explainFile read %s: %+v", path, err)
+	}
+
+	prom.SetupPrometheus(false)
+	namespaces := loadNamespaces(opt.namespacesDir)
+	filterRoot := loadFilters(opt.filtersDir, opt.groupsDir, namespaces)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(flow.Explain(payload, flow.NewNamespaces(namespaces), filterRoot)); err != nil {
+		logrus.Fatalf("explainFile encode: %+v", err)
+	}
+}
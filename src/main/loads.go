@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	gojq_extentions "example.com/gojq_extentions/src"
+	"example.com/streaming-metrics/src/admin"
 	"example.com/streaming-metrics/src/flow"
 	"example.com/streaming-metrics/src/prom"
 
@@ -15,13 +16,13 @@ import (
 
 func withFunctionNamespaceFilterError() gojq.CompilerOption {
 	return gojq.WithFunction("filter_error", 1, 1, func(in any, args []any) any {
-		return fmt.Errorf("filter_error: not relevant msg for namespace: %s", args[0])
+		return &flow.FilterError{Scope: "namespace", Reason: args[0]}
 	})
 }
 
 func withFunctionGroupFilterError() gojq.CompilerOption {
 	return gojq.WithFunction("filter_error", 1, 1, func(in any, args []any) any {
-		return fmt.Errorf("filter_error: not relevant msg for group: %s", args[0])
+		return &flow.FilterError{Scope: "group", Reason: args[0]}
 	})
 }
 
@@ -40,27 +41,41 @@ func withFunctionCompileTest() gojq.CompilerOption {
 	return gojq.WithFunction("ctest", 1, 1, gojq_extentions.Compiled_test)
 }
 
-func loadJq(program_file string, options ...gojq.CompilerOption) *gojq.Code {
+// compileNamespaceFilter compiles a namespace filter submitted to the admin api
+func compileNamespaceFilter(source string) (*gojq.Code, error) {
+	program, err := gojq.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return gojq.Compile(program, withFunctionNamespaceFilterError(), withFunctionLog(), withFunctionCompileTest())
+}
+
+// loadJq compiles a jq file, returning its source along with the compiled program
+func loadJq(program_file string, options ...gojq.CompilerOption) (*gojq.Code, string) {
 	buf, err := os.ReadFile(program_file)
 
 	if err != nil {
 		logrus.Errorf("loadJq readfile %s: %+v", program_file, err)
-		return nil
+		admin.RecordLoadError(program_file, err)
+		return nil, ""
 	}
 
 	program, err := gojq.Parse(string(buf))
 	if err != nil {
 		logrus.Errorf("loadJq parse %s: %+v", program_file, err)
-		return nil
+		admin.RecordLoadError(program_file, err)
+		return nil, ""
 	}
 
 	compiled_program, err := gojq.Compile(program, options...)
 	if err != nil {
 		logrus.Errorf("loadJq compile %s: %+v", program_file, err)
-		return nil
+		admin.RecordLoadError(program_file, err)
+		return nil, ""
 	}
 
-	return compiled_program
+	return compiled_program, string(buf)
 }
 
 func loadNamespaces(namespacesDir string) map[string]*flow.Namespace {
@@ -82,6 +97,7 @@ func loadNamespaces(namespacesDir string) map[string]*flow.Namespace {
 		info, err := entry.Info()
 		if err != nil {
 			logrus.Errorf("failed to get info for %s: %v", namespacePath, err)
+			admin.RecordLoadError(namespacePath, err)
 			continue
 		}
 
@@ -89,12 +105,14 @@ func loadNamespaces(namespacesDir string) map[string]*flow.Namespace {
 			resolvedPath, err := filepath.EvalSymlinks(namespacePath)
 			if err != nil {
 				logrus.Errorf("failed to resolve symlink for %s: %v", namespacePath, err)
+				admin.RecordLoadError(namespacePath, err)
 				continue
 			}
 
 			resolvedInfo, err := os.Lstat(resolvedPath)
 			if err != nil {
 				logrus.Errorf("failed to get info for resolved path %s: %v", resolvedPath, err)
+				admin.RecordLoadError(namespacePath, err)
 				continue
 			}
 
@@ -113,6 +131,7 @@ func loadNamespaces(namespacesDir string) map[string]*flow.Namespace {
 		if namespace == nil {
 			logrus.Panicf("Unable to create namespace for file %s", namespacePath)
 		}
+		namespace.Path = namespacePath
 		namespaces[namespace.Name] = namespace
 	}
 
@@ -130,9 +149,12 @@ func loadFilters(filtersDir string, groupsDir string, namespaces map[string]*flo
 		}
 
 		filterJqPath := fmt.Sprintf("%s/%s.jq", filtersDir, namespace.Name)
-		if filter := loadJq(filterJqPath, withFunctionNamespaceFilterError(), withFunctionLog(), withFunctionCompileTest()); filter != nil {
+		if filter, source := loadJq(filterJqPath, withFunctionNamespaceFilterError(), withFunctionLog(), withFunctionCompileTest()); filter != nil {
 			group.AddChild(&flow.LeafNode{
-				Filter: filter,
+				Filter:    filter,
+				Namespace: namespace.Name,
+				Path:      filterJqPath,
+				Source:    source,
 			})
 		}
 	}
@@ -142,11 +164,11 @@ func loadFilters(filtersDir string, groupsDir string, namespaces map[string]*flo
 
 func loadGroupFilters(groupsDir string) *flow.FilterRoot {
 	group_filter_jq_path := fmt.Sprintf("%s/%s", groupsDir, "groups.jq")
-	group_filter := loadJq(group_filter_jq_path, withFunctionGroupFilterError(), withFunctionCompileTest())
+	group_filter, source := loadJq(group_filter_jq_path, withFunctionGroupFilterError(), withFunctionCompileTest())
 	if group_filter == nil {
 		logrus.Panicf("loadGroupFilters no group filter")
 		return nil
 	}
 
-	return flow.NewFilterTree(group_filter)
+	return flow.NewFilterTree(group_filter, group_filter_jq_path, source)
 }
@@ -3,15 +3,21 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
-	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/apache/pulsar-client-go/pulsar"
 	pulsar_log "github.com/apache/pulsar-client-go/pulsar/log"
+	"github.com/jnovack/flag"
 	"github.com/sirupsen/logrus"
 
+	"example.com/streaming-metrics/src/admin"
 	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/health"
+	"example.com/streaming-metrics/src/profiling"
 	"example.com/streaming-metrics/src/prom"
 )
 
@@ -28,7 +34,7 @@ func setupLogging(level string) {
 	}
 }
 
-func newClient(url string, trust_cert_file string, cert_file string, key_file string, allow_insecure_connection bool) pulsar.Client {
+func newClient(name string, url string, trust_cert_file string, cert_file string, key_file string, allow_insecure_connection bool) pulsar.Client {
 	var client pulsar.Client
 	var err error
 	var auth pulsar.Authentication
@@ -48,6 +54,8 @@ func newClient(url string, trust_cert_file string, cert_file string, key_file st
 		Authentication:             auth,
 		TLSTrustCertsFilePath:      trust_cert_file,
 		Logger:                     pulsar_log.NewLoggerWithLogrus(log),
+		MetricsRegisterer:          prom.Registerer(),
+		CustomMetricsLabels:        map[string]string{"client": name},
 	})
 
 	if err != nil {
@@ -56,59 +64,144 @@ func newClient(url string, trust_cert_file string, cert_file string, key_file st
 	return client
 }
 
-var isReady atomic.Value
+func startHttp(httpPort uint) {
+	logrus.Infof("exposing metrics at: localhost:%d/metrics", httpPort)
+	logrus.Infof("exposing scoped metrics at: localhost:%d/metrics/{service,group,namespace}/{name}", httpPort)
+	logrus.Infof("exposing liveness and readiness at: localhost:%d/{live,ready}", httpPort)
+	logrus.Infof("exposing loaded configuration at: localhost:%d/admin/{namespaces,groups,filters,errors}", httpPort)
+	logrus.Infof("exposing filter explain at: localhost:%d/admin/explain", httpPort)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", httpPort), nil); err != nil {
+		logrus.Panicf("error setting up http server: %+v", err)
+	}
+}
 
-func readinessHandler(w http.ResponseWriter, r *http.Request) {
-	var logMessage string
-	if isReady.Load() == true {
-		w.WriteHeader(http.StatusOK)
-		logMessage = "app is ready\n"
-	} else {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		logMessage = "app is not ready\n"
+// subscriptionType tells if the messages can be acked cumulatively, only
+// the exclusive and failover subscriptions keep the order of a partition
+func subscriptionType(name string) (pulsar.SubscriptionType, bool) {
+	switch name {
+	case "shared":
+		return pulsar.Shared, false
+	case "key_shared":
+		return pulsar.KeyShared, false
+	case "failover":
+		return pulsar.Failover, true
+	case "exclusive":
+		return pulsar.Exclusive, true
 	}
 
-	fmt.Fprint(w, logMessage)
+	logrus.Fatalf("unknown subscription type: %s", name)
+	return pulsar.Shared, false
 }
 
-func setupReadiness() {
-	http.HandleFunc("/ready", readinessHandler)
+// setupProfiling starts the continuous profiling and the profiling endpoints, which need admin tokens
+func setupProfiling(opt opt) {
+	profiling.SetRates(int(opt.pprofMutexFraction), int(opt.pprofBlockRate))
+
+	if opt.pprofOn {
+		logrus.Infoln("starting continuous profiling")
+		go profiling.Continuous(opt.pprofDir, time.Duration(opt.pprofInterval)*time.Second, time.Duration(opt.pprofDuration)*time.Second, int(opt.pprofKeep))
+	}
+
+	if opt.pprofPort > 0 {
+		tokens, err := admin.LoadTokens(opt.adminTokensFile)
+		if err != nil {
+			logrus.Fatalf("setupProfiling: %+v", err)
+		}
+		go profiling.Serve(opt.pprofPort, tokens)
+	}
 }
 
-func startHttp(httpPort uint) {
-	logrus.Infof("exposing metrics at: localhost:%d/metrics", httpPort)
-	logrus.Infof("exposing readiness at: localhost:%d/ready", httpPort)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", httpPort), nil); err != nil {
-		logrus.Panicf("error setting up http server: %+v", err)
+// loadAdminTokens reads the admin api users, none without tokens file
+func loadAdminTokens(opt opt) admin.Tokens {
+	if len(opt.adminTokensFile) == 0 {
+		return nil
 	}
+
+	tokens, err := admin.LoadTokens(opt.adminTokensFile)
+	if err != nil {
+		logrus.Fatalf("loadAdminTokens: %+v", err)
+	}
+
+	return tokens
 }
 
-func main() {
-	isReady.Store(false)
+// setupAdminWrite exposes the namespace changes when the admin api has users
+func setupAdminWrite(opt opt, tokens admin.Tokens, namespaces *flow.Namespaces, filterRoot *flow.FilterRoot, publisher bool, alerts bool) {
+	if len(opt.adminTokensFile) == 0 {
+		return
+	}
+
+	admin.SetupWrite(admin.WriteOptions{
+		Tokens:        tokens,
+		AuditFile:     opt.adminAuditFile,
+		NamespacesDir: opt.namespacesDir,
+		FiltersDir:    opt.filtersDir,
+		Compile:       compileNamespaceFilter,
+		OTLP:          len(opt.otlpEndpoint) > 0,
+		Publisher:     publisher,
+		Alerts:        alerts,
+		AlertInterval: time.Duration(opt.alertEvalInterval) * time.Second,
+	}, namespaces, filterRoot)
+	logrus.Infof("namespace changes enabled for %d admin tokens", len(tokens))
+}
+
+func waitForShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	logrus.Infof("received %v, shutting down", sig)
+}
 
+func main() {
 	opt := loadArgs()
 
 	setupLogging(opt.logLevel)
-	logrus.Infof("%+v", opt)
+
+	if args := flag.Args(); len(args) > 0 {
+		runCommand(args, opt)
+		return
+	}
+
+	if err := opt.validate(); err != nil {
+		logrus.Fatalf("invalid options: %+v", err)
+	}
+	logrus.WithFields(effectiveOptions()).Infoln("options")
+
+	if len(opt.explainFile) > 0 {
+		explainFile(opt.explainFile, opt)
+		return
+	}
 
 	prom.SetupPrometheus(opt.activateObserveProcessingTime)
-	setupReadiness()
+	prom.SetupSeriesExpiry(time.Duration(opt.seriesTTL) * time.Second)
+	prom.SetupSeriesLimits(int(opt.maxSeries))
+	prom.SetupErrorReports(time.Duration(opt.errorLogInterval) * time.Second)
+	health.Setup()
 	go startHttp(opt.httpPort)
 
 	// Clients
-	sourceClient := newClient(opt.pulsarUrl, opt.pulsarTrustCertsFile, opt.pulsarCertFile, opt.pulsarKeyFile, opt.pulsarAllowInsecureConnection)
+	sourceClient := newClient("source", opt.pulsarUrl, opt.pulsarTrustCertsFile, opt.pulsarCertFile, opt.pulsarKeyFile, opt.pulsarAllowInsecureConnection)
 
 	defer sourceClient.Close()
 
+	destClient := sourceClient
+	if len(opt.destUrl) > 0 {
+		destClient = newClient("dest", opt.destUrl, opt.destTrustCertsFile, opt.destCertFile, opt.destKeyFile, opt.destAllowInsecureConnection)
+		defer destClient.Close()
+	}
+
+	subscriptionType, cumulative := subscriptionType(opt.pulsarSubscriptionType)
+
 	consumeChan := make(chan pulsar.ConsumerMessage, 2000)
-	ackChan := make(chan pulsar.ConsumerMessage, 2000)
+	workChan := make(chan pulsar.ConsumerMessage, 2000)
 
 	consumer, err := sourceClient.Subscribe(
 		pulsar.ConsumerOptions{
 			Topics:                      strings.Split(opt.pulsarTopic, ";"),
 			SubscriptionName:            opt.pulsarSubscription,
 			Name:                        opt.pulsarConsumer,
-			Type:                        pulsar.Shared,
+			Type:                        subscriptionType,
 			SubscriptionInitialPosition: pulsar.SubscriptionPositionLatest,
 			MessageChannel:              consumeChan,
 			ReceiverQueueSize:           2000,
@@ -120,24 +213,57 @@ func main() {
 
 	defer consumer.Close()
 
+	acknowledger := flow.NewAcknowledger(consumer, cumulative)
+	go acknowledger.Run()
+	defer acknowledger.Close()
+
 	logrus.Infoln("loading namespaces")
 	namespaces := loadNamespaces(opt.namespacesDir)
 	logrus.Infoln("loading filters")
 	filterRoot := loadFilters(opt.filtersDir, opt.groupsDir, namespaces)
 
 	prom.MyBasePromMetrics.SetNumberNamespaces(len(namespaces))
+	catalog := flow.NewNamespaces(namespaces)
+
+	outputs := setupOutputs(opt, namespaces, destClient)
+	defer closeOutputs(outputs)
+	watchNamespaces(catalog, outputs)
+
+	admin.Setup(catalog, filterRoot)
+	tokens := loadAdminTokens(opt)
+	setupAdminWrite(opt, tokens, catalog, filterRoot, hasPublisher(opt, namespaces), hasAlertEngine(outputs))
+	if opt.tapMaxClients > 0 {
+		admin.SetupTap(tokens, int(opt.tapMaxClients), opt.tapMaxRate, time.Duration(opt.tapMaxDuration)*time.Second)
+		logrus.Infof("exposing event tap at: localhost:%d/admin/tap", opt.httpPort)
+	}
 
 	// Logic
 	logrus.Infoln("starting consumer threads")
+	go acknowledger.Receive(consumeChan, workChan)
 	for i := 0; i < int(opt.consumerThreads); i++ {
-		go flow.Consumer(consumeChan, ackChan, namespaces, filterRoot)
+		go flow.Consumer(workChan, acknowledger, catalog, filterRoot, outputs)
 	}
 
-	if opt.pprofOn {
-		logrus.Infoln("starting profiler thread")
-		go activateProfiling(opt.pprofDir, time.Duration(opt.pprofDuration)*time.Second)
-	}
+	checker := health.NewChecker(health.Options{
+		Consumers:     int(opt.consumerThreads),
+		StallTimeout:  time.Duration(opt.healthStallTimeout) * time.Second,
+		MaxIdle:       time.Duration(opt.healthMaxIdle) * time.Second,
+		MaxAckDelay:   time.Duration(opt.healthMaxAckDelay) * time.Second,
+		ProbeInterval: time.Duration(opt.healthProbeInterval) * time.Second,
+	}, consumer, acknowledger)
+	health.AddChannel(checker, "consume", consumeChan)
+	health.AddChannel(checker, "work", workChan)
+	checker.Start()
+
+	prom.RegisterChannel("consume", func() int { return len(consumeChan) }, cap(consumeChan))
+	prom.RegisterChannel("work", func() int { return len(workChan) }, cap(workChan))
+	prom.RegisterChannel("ack", func() int { return acknowledger.Stats().Requests }, acknowledger.Stats().RequestsCap)
+
+	setupProfiling(opt)
+
+	health.SetStarted(true)
 
-	isReady.Store(true)
-	flow.Acknowledger(consumer, ackChan)
+	waitForShutdown()
+	health.SetStarted(false)
+	prom.FlushErrorReports()
 }
@@ -1,65 +1,300 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/jnovack/flag"
+	"github.com/sirupsen/logrus"
 )
 
 type opt struct {
+	configFile string
+
 	pulsarUrl                     string
 	pulsarTopic                   string
 	pulsarSubscription            string
+	pulsarSubscriptionType        string
 	pulsarConsumer                string
 	pulsarTrustCertsFile          string
 	pulsarCertFile                string
 	pulsarKeyFile                 string
 	pulsarAllowInsecureConnection bool
 
+	destUrl                     string
+	destTopic                   string
+	destTrustCertsFile          string
+	destCertFile                string
+	destKeyFile                 string
+	destAllowInsecureConnection bool
+
 	consumerThreads uint
 
 	namespacesDir string
 	groupsDir     string
 	filtersDir    string
 
-	pprofOn       bool
-	pprofDir      string
-	pprofDuration uint
+	pprofOn            bool
+	pprofDir           string
+	pprofDuration      uint
+	pprofInterval      uint
+	pprofKeep          uint
+	pprofPort          uint
+	pprofMutexFraction uint
+	pprofBlockRate     uint
 
 	httpPort uint
 
+	remoteWriteUrl      string
+	remoteWriteMode     string
+	remoteWriteInterval uint
+	remoteWriteTimeout  uint
+
+	otlpEndpoint   string
+	otlpProtocol   string
+	otlpInsecure   bool
+	otlpInterval   uint
+	otlpAttributes string
+
+	statsdAddress    string
+	statsdTemplate   string
+	graphiteAddress  string
+	graphiteTemplate string
+	influxAddress    string
+	influxTemplate   string
+	lineMode         string
+	lineInterval     uint
+
+	dbDriver        string
+	dbDsn           string
+	dbTable         string
+	dbBatchSize     uint
+	dbFlushInterval uint
+
+	alertEvalInterval uint
+	alertWebhookUrl   string
+	alertTopic        string
+	alertmanagerUrl   string
+
+	reportSchedule     string
+	reportFormat       string
+	reportDir          string
+	reportTopic        string
+	reportErrorMetrics string
+	reportTotalMetric  string
+
+	adminTokensFile string
+	adminAuditFile  string
+
+	tapMaxClients  uint
+	tapMaxRate     float64
+	tapMaxDuration uint
+
+	healthStallTimeout  uint
+	healthMaxIdle       uint
+	healthMaxAckDelay   uint
+	healthProbeInterval uint
+
+	seriesTTL uint
+	maxSeries uint
+
+	explainFile string
+
 	activateObserveProcessingTime bool
 
-	logLevel string
+	logLevel         string
+	errorLogInterval uint
 }
 
 func loadArgs() opt {
 	var opt opt
 
+	flag.StringVar(&opt.configFile, "config_file", "", "YAML (.yaml, .yml) or TOML (.toml) file of the options not given as flags or environment variables")
+
 	flag.StringVar(&opt.pulsarUrl, "pulsar_url", "pulsar://localhost:6650", "Source pulsar address")
 	flag.StringVar(&opt.pulsarTopic, "pulsar_topic", "persistent://public/default/in", "Source topic names (seperated by ;)")
 	flag.StringVar(&opt.pulsarConsumer, "pulsar_consumer", "streaming_metrics_consumer", "Source consumer name")
 	flag.StringVar(&opt.pulsarSubscription, "pulsar_subscription", "streaming_metrics", "Source subscription name")
+	flag.StringVar(&opt.pulsarSubscriptionType, "pulsar_subscription_type", "shared", "Source subscription type: shared - key_shared - failover - exclusive (the last two ack cumulatively)")
 	flag.StringVar(&opt.pulsarTrustCertsFile, "pulsar_trust_certs_file", "", "Path for source pem file, for ca.cert")
 	flag.StringVar(&opt.pulsarCertFile, "pulsar_cert_file", "", "Path for source cert.pem file")
 	flag.StringVar(&opt.pulsarKeyFile, "pulsar_key_file", "", "Path for source key-pk8.pem file")
 	flag.BoolVar(&opt.pulsarAllowInsecureConnection, "pulsar_allow_insecure_connection", false, "Source allow insecure connection")
 
+	flag.StringVar(&opt.destUrl, "dest_url", "", "Destination pulsar address (empty uses the source client)")
+	flag.StringVar(&opt.destTopic, "dest_topic", "", "Default destination topic for the events (empty only publishes namespaces with a dest_topic)")
+	flag.StringVar(&opt.destTrustCertsFile, "dest_trust_certs_file", "", "Path for destination pem file, for ca.cert")
+	flag.StringVar(&opt.destCertFile, "dest_cert_file", "", "Path for destination cert.pem file")
+	flag.StringVar(&opt.destKeyFile, "dest_key_file", "", "Path for destination key-pk8.pem file")
+	flag.BoolVar(&opt.destAllowInsecureConnection, "dest_allow_insecure_connection", false, "Destination allow insecure connection")
+
 	flag.UintVar(&opt.consumerThreads, "consumer_threads", 6, "Number of threads to consume from pulsar")
 
 	flag.StringVar(&opt.namespacesDir, "namespaces_dir", "./namespaces", "Directory of all the namespace configurations")
 	flag.StringVar(&opt.groupsDir, "groups_dir", "./groups", "Directory of the groups definitions")
 	flag.StringVar(&opt.filtersDir, "filters_dir", "./filters", "Directory of all the jq filter files")
 
-	flag.BoolVar(&opt.pprofOn, "pprof_on", false, "Profiling on?")
-	flag.StringVar(&opt.pprofDir, "pprof_dir", "./pprof", "Directory for pprof file")
-	flag.UintVar(&opt.pprofDuration, "pprof_duration", 60*2, "Number of seconds to run pprof")
+	flag.BoolVar(&opt.pprofOn, "pprof_on", false, "Continuous profiling on? (cpu, heap and goroutine profiles written to pprof_dir)")
+	flag.StringVar(&opt.pprofDir, "pprof_dir", "./pprof", "Directory of the continuous profiling files")
+	flag.UintVar(&opt.pprofDuration, "pprof_duration", 60*2, "Number of seconds of each continuous cpu profile")
+	flag.UintVar(&opt.pprofInterval, "pprof_interval", 60*10, "Number of seconds between the starts of the continuous profiles")
+	flag.UintVar(&opt.pprofKeep, "pprof_keep", 12, "Number of continuous profiling files kept per profile")
+	flag.UintVar(&opt.pprofPort, "pprof_port", 0, "Port of the profiling endpoints, authenticated with the admin tokens (0 disables)")
+	flag.UintVar(&opt.pprofMutexFraction, "pprof_mutex_fraction", 0, "1/n of the mutex contentions recorded in the mutex profile (0 disables)")
+	flag.UintVar(&opt.pprofBlockRate, "pprof_block_rate", 0, "Nanoseconds of blocking per event recorded in the block profile (0 disables)")
 
 	flag.UintVar(&opt.httpPort, "http_port", 7700, "HTTP port")
 
+	flag.StringVar(&opt.remoteWriteUrl, "remote_write_url", "", "Prometheus remote write endpoint (empty disables)")
+	flag.StringVar(&opt.remoteWriteMode, "remote_write_mode", "snapshot", "Remote write the registry (snapshot) or the event samples at their event time (events)")
+	flag.UintVar(&opt.remoteWriteInterval, "remote_write_interval", 15, "Number of seconds between remote writes")
+	flag.UintVar(&opt.remoteWriteTimeout, "remote_write_timeout", 10, "Number of seconds before a remote write request times out")
+
+	flag.StringVar(&opt.otlpEndpoint, "otlp_endpoint", "", "OTLP collector host:port (empty disables)")
+	flag.StringVar(&opt.otlpProtocol, "otlp_protocol", "grpc", "OTLP protocol: grpc - http")
+	flag.BoolVar(&opt.otlpInsecure, "otlp_insecure", false, "OTLP without TLS")
+	flag.UintVar(&opt.otlpInterval, "otlp_interval", 15, "Number of seconds between OTLP exports")
+	flag.StringVar(&opt.otlpAttributes, "otlp_attributes", "datapoint", "Where the service label goes: datapoint (all labels as data point attributes) - resource (service.name resource attribute)")
+
+	flag.StringVar(&opt.statsdAddress, "statsd_address", "", "StatsD address: udp://host:port - tcp://host:port (empty disables)")
+	flag.StringVar(&opt.statsdTemplate, "statsd_template", "", "StatsD metric name template over .Service .Group .Namespace .Hostname .Metric")
+	flag.StringVar(&opt.graphiteAddress, "graphite_address", "", "Graphite plaintext address: udp://host:port - tcp://host:port (empty disables)")
+	flag.StringVar(&opt.graphiteTemplate, "graphite_template", "", "Graphite metric path template over .Service .Group .Namespace .Hostname .Metric")
+	flag.StringVar(&opt.influxAddress, "influx_address", "", "Influx line protocol address: udp://host:port - tcp://host:port - http(s)://host:port/write?db=... (empty disables)")
+	flag.StringVar(&opt.influxTemplate, "influx_template", "", "Influx measurement template over .Service .Group .Namespace .Hostname .Metric")
+	flag.StringVar(&opt.lineMode, "line_mode", "events", "StatsD, Graphite and Influx send every event (events) or the registry (snapshot)")
+	flag.UintVar(&opt.lineInterval, "line_interval", 10, "Number of seconds between StatsD, Graphite and Influx flushes")
+
+	flag.StringVar(&opt.dbDriver, "db_driver", "", "Database sink: sqlite - postgres (empty disables)")
+	flag.StringVar(&opt.dbDsn, "db_dsn", "", "Database sink data source name (sqlite file path or postgres url)")
+	flag.StringVar(&opt.dbTable, "db_table", "metric_events", "Database sink table, created if missing")
+	flag.UintVar(&opt.dbBatchSize, "db_batch_size", 1000, "Number of events per database transaction")
+	flag.UintVar(&opt.dbFlushInterval, "db_flush_interval", 1000, "Number of milliseconds between database commits")
+
+	flag.UintVar(&opt.alertEvalInterval, "alert_eval_interval", 15, "Number of seconds between evaluations of the alert rules")
+	flag.StringVar(&opt.alertWebhookUrl, "alert_webhook_url", "", "Webhook urls notified of the alerts (seperated by ;)")
+	flag.StringVar(&opt.alertTopic, "alert_topic", "", "Topic the alerts are published to, on the destination cluster")
+	flag.StringVar(&opt.alertmanagerUrl, "alertmanager_url", "", "Alertmanager base url the alerts are sent to")
+
+	flag.StringVar(&opt.reportSchedule, "report_schedule", "@daily", "Cron schedule of the reports: minute hour day month weekday, or @hourly - @daily - @weekly - @monthly")
+	flag.StringVar(&opt.reportFormat, "report_format", "json", "Report format: csv - json - markdown")
+	flag.StringVar(&opt.reportDir, "report_dir", "", "Directory the reports are written to")
+	flag.StringVar(&opt.reportTopic, "report_topic", "", "Topic the reports are published to, on the destination cluster")
+	flag.StringVar(&opt.reportErrorMetrics, "report_error_metrics", "tech_error", "Metrics counted as errors in the report error ratios (seperated by ;)")
+	flag.StringVar(&opt.reportTotalMetric, "report_total_metric", "total", "Metric counted as the total in the report error ratios")
+
+	flag.StringVar(&opt.adminTokensFile, "admin_tokens_file", "", "File of the admin api users and bearer tokens, a \"user token\" line each (empty disables the namespace changes)")
+	flag.StringVar(&opt.adminAuditFile, "admin_audit_file", "", "File the namespace changes are appended to as json lines (empty only logs them)")
+
+	flag.UintVar(&opt.tapMaxClients, "tap_max_clients", 0, "Max number of concurrent event taps, authenticated with the admin tokens (0 disables)")
+	flag.Float64Var(&opt.tapMaxRate, "tap_max_rate", 10, "Max number of messages per second sent to an event tap")
+	flag.UintVar(&opt.tapMaxDuration, "tap_max_duration", 600, "Number of seconds after which an event tap is closed")
+
+	flag.UintVar(&opt.healthStallTimeout, "health_stall_timeout", 60, "Number of seconds on a message after which a consumer is stalled, failing readiness")
+	flag.UintVar(&opt.healthMaxIdle, "health_max_idle", 0, "Number of seconds without receiving a message after which the app is not ready (0 disables)")
+	flag.UintVar(&opt.healthMaxAckDelay, "health_max_ack_delay", 60, "Number of seconds without ack while messages wait for one after which the app is not ready")
+	flag.UintVar(&opt.healthProbeInterval, "health_probe_interval", 10, "Number of seconds between the checks of the pulsar connection")
+
+	flag.UintVar(&opt.seriesTTL, "series_ttl", 0, "Number of seconds without updates after which a series is deleted (0 disables)")
+	flag.UintVar(&opt.maxSeries, "max_series", 0, "Max number of series per metric of a namespace before folding into the overflow series (0 is unlimited)")
+
+	flag.StringVar(&opt.explainFile, "explain_file", "", "Message file (- for stdin) explained against the loaded filters, printed as json instead of consuming")
+
 	flag.BoolVar(&opt.activateObserveProcessingTime, "activate_timing_collection", false, "Is the collection by prometheus of processing time on (may hinder perforance!)")
 
 	flag.StringVar(&opt.logLevel, "log_level", "info", "Logging level: panic - fatal - error - warn - info - debug - trace")
+	flag.UintVar(&opt.errorLogInterval, "error_log_interval", 60, "Number of seconds the identical processing errors of a namespace are counted before being logged once with an example (0 logs each error)")
 
 	flag.Parse()
 
+	if len(opt.configFile) > 0 {
+		if err := applyConfigFile(opt.configFile); err != nil {
+			logrus.Fatalf("loadArgs: %+v", err)
+		}
+	}
+
 	return opt
 }
+
+// validate rejects the options that conflict with each other
+func (opt opt) validate() error {
+	errs := make([]error, 0)
+	conflict := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if opt.consumerThreads == 0 {
+		conflict("consumer_threads must be at least 1")
+	}
+
+	if opt.pprofPort > 0 && len(opt.adminTokensFile) == 0 {
+		conflict("pprof_port needs admin_tokens_file")
+	}
+	if opt.pprofPort > 0 && opt.pprofPort == opt.httpPort {
+		conflict("pprof_port and http_port are both %d", opt.httpPort)
+	}
+	if opt.pprofOn && (opt.pprofInterval == 0 || opt.pprofDuration == 0) {
+		conflict("pprof_on needs pprof_interval and pprof_duration")
+	}
+
+	if len(opt.adminAuditFile) > 0 && len(opt.adminTokensFile) == 0 {
+		conflict("admin_audit_file needs admin_tokens_file, the namespaces cannot change without it")
+	}
+
+	for _, tls := range [][3]string{
+		{"pulsar", opt.pulsarCertFile, opt.pulsarKeyFile},
+		{"dest", opt.destCertFile, opt.destKeyFile},
+	} {
+		if (len(tls[1]) > 0) != (len(tls[2]) > 0) {
+			conflict("%s_cert_file and %s_key_file go together", tls[0], tls[0])
+		}
+	}
+	if len(opt.destUrl) == 0 && (len(opt.destTrustCertsFile) > 0 || len(opt.destCertFile) > 0 || len(opt.destKeyFile) > 0) {
+		conflict("dest tls files need dest_url, the source client is used without it")
+	}
+
+	if (len(opt.dbDriver) > 0) != (len(opt.dbDsn) > 0) {
+		conflict("db_driver and db_dsn go together")
+	}
+
+	for _, line := range [][3]string{
+		{"statsd", opt.statsdAddress, opt.statsdTemplate},
+		{"graphite", opt.graphiteAddress, opt.graphiteTemplate},
+		{"influx", opt.influxAddress, opt.influxTemplate},
+	} {
+		if len(line[1]) == 0 && len(line[2]) > 0 {
+			conflict("%s_template needs %s_address", line[0], line[0])
+		}
+		if line[0] != "influx" && (strings.HasPrefix(line[1], "http://") || strings.HasPrefix(line[1], "https://")) {
+			conflict("%s_address must be udp:// or tcp://, only influx_address can be http(s)://", line[0])
+		}
+	}
+
+	if opt.tapMaxClients > 0 && len(opt.adminTokensFile) == 0 {
+		conflict("tap_max_clients needs admin_tokens_file, the tap streams the raw messages")
+	}
+	if opt.tapMaxRate <= 0 {
+		conflict("tap_max_rate must be positive")
+	}
+
+	// the intervals of the enabled features tick, and a ticker needs a positive interval
+	for _, interval := range []struct {
+		name    string
+		enabled bool
+		value   uint
+	}{
+		{"health_probe_interval", true, opt.healthProbeInterval},
+		{"alert_eval_interval", true, opt.alertEvalInterval},
+		{"remote_write_interval", len(opt.remoteWriteUrl) > 0, opt.remoteWriteInterval},
+		{"otlp_interval", len(opt.otlpEndpoint) > 0, opt.otlpInterval},
+		{"line_interval", len(opt.statsdAddress) > 0 || len(opt.graphiteAddress) > 0 || len(opt.influxAddress) > 0, opt.lineInterval},
+		{"db_flush_interval", len(opt.dbDriver) > 0, opt.dbFlushInterval},
+	} {
+		if interval.enabled && interval.value == 0 {
+			conflict("%s must be at least 1", interval.name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
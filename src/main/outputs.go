@@ -0,0 +1,221 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/alert"
+	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/output"
+	"example.com/streaming-metrics/src/report"
+)
+
+func setupOutputs(opt opt, namespaces map[string]*flow.Namespace, destClient pulsar.Client) []flow.Output {
+	outputs := make([]flow.Output, 0)
+
+	if hasPublisher(opt, namespaces) {
+		logrus.Infof("publishing events to destination topics (default %q)", opt.destTopic)
+		outputs = append(outputs, output.NewPulsarPublisher(destClient, opt.destTopic))
+	}
+
+	if len(opt.remoteWriteUrl) > 0 {
+		writer, err := output.NewRemoteWriter(
+			opt.remoteWriteUrl,
+			opt.remoteWriteMode,
+			time.Duration(opt.remoteWriteInterval)*time.Second,
+			time.Duration(opt.remoteWriteTimeout)*time.Second,
+		)
+		if err != nil {
+			logrus.Panicf("failed to setup remote write output: %+v", err)
+		}
+
+		logrus.Infof("remote writing %s to %s", opt.remoteWriteMode, opt.remoteWriteUrl)
+		outputs = append(outputs, writer)
+	}
+
+	if len(opt.otlpEndpoint) > 0 {
+		exporter, err := output.NewOTLPExporter(
+			output.OTLPOptions{
+				Endpoint:   opt.otlpEndpoint,
+				Protocol:   opt.otlpProtocol,
+				Insecure:   opt.otlpInsecure,
+				Interval:   time.Duration(opt.otlpInterval) * time.Second,
+				Attributes: opt.otlpAttributes,
+			},
+			namespaces,
+		)
+		if err != nil {
+			logrus.Panicf("failed to setup otlp output: %+v", err)
+		}
+
+		logrus.Infof("exporting otlp over %s to %s", opt.otlpProtocol, opt.otlpEndpoint)
+		outputs = append(outputs, exporter)
+	}
+
+	if len(opt.dbDriver) > 0 {
+		sink, err := output.NewDatabaseSink(
+			opt.dbDriver,
+			opt.dbDsn,
+			opt.dbTable,
+			int(opt.dbBatchSize),
+			time.Duration(opt.dbFlushInterval)*time.Millisecond,
+		)
+		if err != nil {
+			logrus.Panicf("failed to setup database sink: %+v", err)
+		}
+
+		logrus.Infof("writing events to %s table %s, acking after commit", opt.dbDriver, opt.dbTable)
+		outputs = append(outputs, sink)
+	}
+
+	lineAddresses := map[string]string{
+		"statsd":   opt.statsdAddress,
+		"graphite": opt.graphiteAddress,
+		"influx":   opt.influxAddress,
+	}
+	lineTemplates := map[string]string{
+		"statsd":   opt.statsdTemplate,
+		"graphite": opt.graphiteTemplate,
+		"influx":   opt.influxTemplate,
+	}
+	for format, address := range lineAddresses {
+		if len(address) == 0 {
+			continue
+		}
+
+		writer, err := output.NewLineWriter(output.LineOptions{
+			Format:   format,
+			Address:  address,
+			Template: lineTemplates[format],
+			Mode:     opt.lineMode,
+			Interval: time.Duration(opt.lineInterval) * time.Second,
+		})
+		if err != nil {
+			logrus.Panicf("failed to setup %s output: %+v", format, err)
+		}
+
+		logrus.Infof("sending %s %s to %s", format, opt.lineMode, address)
+		outputs = append(outputs, writer)
+	}
+
+	if len(opt.reportDir) > 0 || len(opt.reportTopic) > 0 {
+		reporter, err := report.NewReporter(
+			report.Options{
+				Schedule:     opt.reportSchedule,
+				Format:       opt.reportFormat,
+				Dir:          opt.reportDir,
+				Topic:        opt.reportTopic,
+				ErrorMetrics: strings.Split(opt.reportErrorMetrics, ";"),
+				TotalMetric:  opt.reportTotalMetric,
+			},
+			destClient,
+		)
+		if err != nil {
+			logrus.Panicf("failed to setup reports: %+v", err)
+		}
+
+		logrus.Infof("reporting as %s on schedule %q", opt.reportFormat, opt.reportSchedule)
+		outputs = append(outputs, reporter)
+	}
+
+	if engine := setupAlerts(opt, namespaces, destClient); engine != nil {
+		outputs = append(outputs, output.NewAlertFeed(engine))
+	}
+
+	return outputs
+}
+
+// setupAlerts starts the alert engine when a namespace has alert rules, absence thresholds, anomaly detectors or SLOs
+func setupAlerts(opt opt, namespaces map[string]*flow.Namespace, destClient pulsar.Client) *alert.Engine {
+	notifiers := make([]alert.Notifier, 0)
+
+	for _, url := range strings.Split(opt.alertWebhookUrl, ";") {
+		if len(url) > 0 {
+			notifiers = append(notifiers, alert.NewWebhookNotifier(url))
+		}
+	}
+
+	if len(opt.alertTopic) > 0 {
+		notifier, err := alert.NewTopicNotifier(destClient, opt.alertTopic)
+		if err != nil {
+			logrus.Panicf("failed to setup alert topic: %+v", err)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	if len(opt.alertmanagerUrl) > 0 {
+		notifiers = append(notifiers, alert.NewAlertmanagerNotifier(opt.alertmanagerUrl))
+	}
+
+	engine := alert.NewEngine(time.Duration(opt.alertEvalInterval)*time.Second, notifiers)
+	for _, namespace := range namespaces {
+		for _, anomaly := range namespace.Anomalies {
+			if err := anomaly.CheckInterval(time.Duration(opt.alertEvalInterval) * time.Second); err != nil {
+				logrus.Panicf("namespace %s: %+v", namespace.Name, err)
+			}
+		}
+
+		engine.AddNamespace(namespace.Name, namespace.Service, namespace.Group, alert.NamespaceConfig{
+			Rules:     namespace.Alerts,
+			Absence:   namespace.Absence,
+			Anomalies: namespace.Anomalies,
+			SLOs:      namespace.SLOs,
+		})
+	}
+
+	if !engine.HasRules() {
+		engine.Close()
+		return nil
+	}
+
+	if len(notifiers) == 0 {
+		logrus.Warnln("alert rules without notifier, their state is only exposed as the alert_state metric")
+	}
+
+	engine.Start()
+	return engine
+}
+
+// hasPublisher tells if the events are published to destination topics, decided at startup
+func hasPublisher(opt opt, namespaces map[string]*flow.Namespace) bool {
+	return len(opt.destTopic) > 0 || hasDestTopic(namespaces)
+}
+
+func hasDestTopic(namespaces map[string]*flow.Namespace) bool {
+	for _, namespace := range namespaces {
+		if len(namespace.DestTopic) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watchNamespaces notifies the outputs built from the namespaces of the changes made by the admin api
+func watchNamespaces(catalog *flow.Namespaces, outputs []flow.Output) {
+	for _, output := range outputs {
+		if watcher, ok := output.(flow.Watcher); ok {
+			catalog.Watch(watcher)
+		}
+	}
+}
+
+// hasAlertEngine tells if the alert engine runs, it only starts with the alert rules of the startup namespaces
+func hasAlertEngine(outputs []flow.Output) bool {
+	for _, out := range outputs {
+		if _, ok := out.(*output.AlertFeed); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func closeOutputs(outputs []flow.Output) {
+	for _, output := range outputs {
+		output.Close()
+	}
+}
@@ -0,0 +1,41 @@
+package output
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+
+	"example.com/streaming-metrics/src/alert"
+	"example.com/streaming-metrics/src/flow"
+)
+
+// AlertFeed hands the events to the alert engine
+type AlertFeed struct {
+	engine *alert.Engine
+}
+
+func NewAlertFeed(engine *alert.Engine) *AlertFeed {
+	return &AlertFeed{engine: engine}
+}
+
+func (feed *AlertFeed) Push(namespace *flow.Namespace, hostname string, event flow.Event, msg pulsar.ConsumerMessage) {
+	feed.engine.Observe(namespace.Name, hostname, event.Metrics())
+}
+
+// NamespaceChanged replaces the rules of the namespace with its new ones
+func (feed *AlertFeed) NamespaceChanged(previous *flow.Namespace, namespace *flow.Namespace) {
+	if previous != nil {
+		feed.engine.RemoveNamespace(previous.Name)
+	}
+
+	if namespace != nil {
+		feed.engine.AddNamespace(namespace.Name, namespace.Service, namespace.Group, alert.NamespaceConfig{
+			Rules:     namespace.Alerts,
+			Absence:   namespace.Absence,
+			Anomalies: namespace.Anomalies,
+			SLOs:      namespace.SLOs,
+		})
+	}
+}
+
+func (feed *AlertFeed) Close() {
+	feed.engine.Close()
+}
@@ -0,0 +1,309 @@
+package output
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+
+	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/prom"
+)
+
+const (
+	databaseQueueSize  = 4
+	databaseMinBackoff = 500 * time.Millisecond
+	databaseMaxBackoff = time.Minute
+)
+
+// sql drivers of the supported databases
+var databaseDrivers = map[string]string{
+	"sqlite":   "sqlite",
+	"postgres": "pgx",
+}
+
+// schema valid for both sqlite and postgres
+const databaseSchema = `CREATE TABLE IF NOT EXISTS %s (
+	service           TEXT NOT NULL,
+	group_name        TEXT NOT NULL,
+	namespace         TEXT NOT NULL,
+	hostname          TEXT NOT NULL,
+	event_time        TEXT NOT NULL,
+	metrics           TEXT NOT NULL,
+	source_message_id TEXT NOT NULL
+)`
+
+const databaseInsert = `INSERT INTO %s (service, group_name, namespace, hostname, event_time, metrics, source_message_id) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+var databaseMetrics = struct {
+	eventsWritten    prometheus.Counter
+	batchesCommitted prometheus.Counter
+	batchFailures    prometheus.Counter
+	commitTime       prometheus.Summary
+}{
+	eventsWritten: prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "db_events_written",
+			Help: "The number of events committed to the database",
+		},
+	),
+	batchesCommitted: prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "db_batches_committed",
+			Help: "The number of batches committed to the database",
+		},
+	),
+	batchFailures: prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "db_batch_failures",
+			Help: "The number of failed attempts to commit a batch, the batch is retried",
+		},
+	),
+	commitTime: prometheus.NewSummary(
+		prometheus.SummaryOpts{
+			Name:       "db_commit_time",
+			Help:       "The time to write and commit a batch (ms)",
+			Objectives: map[float64]float64{0.50: 0.1, 0.90: 0.01, 0.99: 0.005},
+		},
+	),
+}
+
+type databaseRow struct {
+	service   string
+	group     string
+	namespace string
+	hostname  string
+	eventTime string
+	metrics   string
+	messageID string
+}
+
+// databaseBatch is written in a single transaction, its messages are
+// released once it is committed
+type databaseBatch struct {
+	rows []databaseRow
+	done []func(error)
+}
+
+/*
+ * DatabaseSink batches the events of all namespaces and commits each batch in
+ * one transaction, failed batches are retried until they are committed
+ */
+
+type DatabaseSink struct {
+	db        *sql.DB
+	insert    string
+	batchSize int
+
+	mu     sync.Mutex
+	batch  *databaseBatch
+	closed bool
+
+	queue chan *databaseBatch
+	stop  chan struct{}
+	done  sync.WaitGroup
+}
+
+func NewDatabaseSink(driver string, dsn string, table string, batchSize int, interval time.Duration) (*DatabaseSink, error) {
+	driverName, ok := databaseDrivers[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown database driver: %s", driver)
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(databaseSchema, table)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create table %s: %w", table, err)
+	}
+
+	prom.MustRegister(
+		databaseMetrics.eventsWritten,
+		databaseMetrics.batchesCommitted,
+		databaseMetrics.batchFailures,
+		databaseMetrics.commitTime,
+	)
+
+	return startDatabaseSink(db, table, batchSize, interval), nil
+}
+
+func startDatabaseSink(db *sql.DB, table string, batchSize int, interval time.Duration) *DatabaseSink {
+	sink := &DatabaseSink{
+		db:        db,
+		insert:    fmt.Sprintf(databaseInsert, table),
+		batchSize: batchSize,
+		batch:     &databaseBatch{},
+		queue:     make(chan *databaseBatch, databaseQueueSize),
+		stop:      make(chan struct{}),
+	}
+
+	sink.done.Add(2)
+	go sink.flushLoop(interval)
+	go sink.writeLoop()
+
+	return sink
+}
+
+func (sink *DatabaseSink) Push(namespace *flow.Namespace, hostname string, event flow.Event, msg pulsar.ConsumerMessage) {
+	metrics, err := json.Marshal(event.Metrics())
+	if err != nil {
+		prom.ReportError("database", namespace.Name, "database sink marshal metrics: %+v", err)
+		return
+	}
+
+	row := databaseRow{
+		service:   namespace.Service,
+		group:     namespace.Group,
+		namespace: namespace.Name,
+		hostname:  hostname,
+		eventTime: event.RawTime(),
+		metrics:   string(metrics),
+		messageID: flow.KeyOf(msg).String(),
+	}
+
+	sink.mu.Lock()
+	sink.batch.rows = append(sink.batch.rows, row)
+	sink.mu.Unlock()
+}
+
+// Release adds the message to the current batch, it is acked after the batch commit.
+// The events of a message are always pushed before it is released, so they are in
+// the same batch or in an earlier one.
+func (sink *DatabaseSink) Release(msg pulsar.ConsumerMessage, done func(error)) {
+	sink.mu.Lock()
+	sink.batch.done = append(sink.batch.done, done)
+	full := len(sink.batch.rows) >= sink.batchSize
+	sink.mu.Unlock()
+
+	if full {
+		sink.flush()
+	}
+}
+
+// flush queues the current batch, blocking the consumers while the queue is full
+func (sink *DatabaseSink) flush() {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	if sink.closed {
+		return
+	}
+
+	batch := sink.batch
+	sink.batch = &databaseBatch{}
+
+	if len(batch.rows) == 0 && len(batch.done) == 0 {
+		return
+	}
+
+	sink.queue <- batch
+}
+
+func (sink *DatabaseSink) flushLoop(interval time.Duration) {
+	defer sink.done.Done()
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			sink.flush()
+		case <-sink.stop:
+			sink.flush()
+
+			sink.mu.Lock()
+			sink.closed = true
+			close(sink.queue)
+			sink.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (sink *DatabaseSink) writeLoop() {
+	defer sink.done.Done()
+
+	for batch := range sink.queue {
+		err := sink.writeWithRetry(batch)
+		for _, done := range batch.done {
+			done(err)
+		}
+	}
+}
+
+// writeWithRetry retries until the batch is committed, or gives up on shutdown
+// so its messages are nacked to be redelivered
+func (sink *DatabaseSink) writeWithRetry(batch *databaseBatch) error {
+	backoff := databaseMinBackoff
+	for {
+		err := sink.write(batch)
+		if err == nil {
+			return nil
+		}
+
+		logrus.Errorf("database sink write of %d events failed, retrying in %v: %+v", len(batch.rows), backoff, err)
+		databaseMetrics.batchFailures.Inc()
+
+		select {
+		case <-time.After(backoff):
+		case <-sink.stop:
+			logrus.Warnf("database sink shutting down, %d messages nacked", len(batch.done))
+			return fmt.Errorf("database sink shutting down: %w", err)
+		}
+		backoff = min(backoff*2, databaseMaxBackoff)
+	}
+}
+
+func (sink *DatabaseSink) write(batch *databaseBatch) error {
+	if len(batch.rows) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+
+	tx, err := sink.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(sink.insert)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range batch.rows {
+		if _, err := stmt.Exec(row.service, row.group, row.namespace, row.hostname, row.eventTime, row.metrics, row.messageID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	databaseMetrics.commitTime.Observe(float64(time.Since(start) / time.Millisecond))
+	databaseMetrics.batchesCommitted.Inc()
+	databaseMetrics.eventsWritten.Add(float64(len(batch.rows)))
+	return nil
+}
+
+// Close commits what is left
+func (sink *DatabaseSink) Close() {
+	close(sink.stop)
+	sink.done.Wait()
+	sink.db.Close()
+}
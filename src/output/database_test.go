@@ -0,0 +1,165 @@
+package output
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"example.com/streaming-metrics/src/flow"
+)
+
+type sinkMessage struct {
+	pulsar.Message
+	entry int64
+}
+
+func (msg sinkMessage) Topic() string {
+	return "events"
+}
+
+func (msg sinkMessage) ID() pulsar.MessageID {
+	return pulsar.NewMessageID(1, msg.entry, 0, 0)
+}
+
+func newTestDatabase(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(databaseSchema, "events")); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	return db
+}
+
+func countRows(t *testing.T, db *sql.DB) int {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM events").Scan(&count); err != nil {
+		t.Errorf("count: %v", err)
+	}
+	return count
+}
+
+// release pushes an event of the message then releases it, the result of the
+// release is sent to the returned channel
+func release(sink *DatabaseSink, entry int64) chan error {
+	namespace := &flow.Namespace{Name: "ns", Group: "group", Service: "service"}
+	msg := pulsar.ConsumerMessage{Message: sinkMessage{entry: entry}}
+
+	released := make(chan error, 1)
+	sink.Push(namespace, "host", flow.Event{}, msg)
+	sink.Release(msg, func(err error) {
+		released <- err
+	})
+	return released
+}
+
+// waitFailures waits until the writes failed count times since before
+func waitFailures(t *testing.T, before float64, count float64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(databaseMetrics.batchFailures) < before+count {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v failed writes", count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDatabaseCommitBeforeAck(t *testing.T) {
+	db := newTestDatabase(t)
+	sink := startDatabaseSink(db, "events", 1, time.Hour)
+	defer sink.Close()
+
+	// the rows are visible to the other connections when the message is acked
+	committed := make(chan int, 1)
+	namespace := &flow.Namespace{Name: "ns"}
+	msg := pulsar.ConsumerMessage{Message: sinkMessage{entry: 1}}
+	sink.Push(namespace, "host", flow.Event{}, msg)
+	sink.Release(msg, func(err error) {
+		if err != nil {
+			t.Errorf("expected the batch committed, got %v", err)
+		}
+		committed <- countRows(t, db)
+	})
+
+	select {
+	case count := <-committed:
+		if count != 1 {
+			t.Errorf("expected the row committed before the ack, got %d rows", count)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the batch was not committed")
+	}
+}
+
+func TestDatabaseRetry(t *testing.T) {
+	db := newTestDatabase(t)
+	sink := startDatabaseSink(db, "events", 1, time.Hour)
+	defer sink.Close()
+
+	if _, err := db.Exec("DROP TABLE events"); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+
+	before := testutil.ToFloat64(databaseMetrics.batchFailures)
+	released := release(sink, 1)
+	waitFailures(t, before, 1)
+
+	select {
+	case err := <-released:
+		t.Fatalf("the failed batch was released: %v", err)
+	default:
+	}
+
+	// the batch is committed by a retry once the table is back
+	if _, err := db.Exec(fmt.Sprintf(databaseSchema, "events")); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	select {
+	case err := <-released:
+		if err != nil {
+			t.Errorf("expected the batch committed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the batch was not retried")
+	}
+
+	if count := countRows(t, db); count != 1 {
+		t.Errorf("expected 1 row, got %d", count)
+	}
+}
+
+func TestDatabaseCloseNacks(t *testing.T) {
+	db := newTestDatabase(t)
+	sink := startDatabaseSink(db, "events", 1, time.Hour)
+
+	if _, err := db.Exec("DROP TABLE events"); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+
+	// the first batch is retried, the second waits in the queue
+	before := testutil.ToFloat64(databaseMetrics.batchFailures)
+	retried := release(sink, 1)
+	queued := release(sink, 2)
+	waitFailures(t, before, 1)
+
+	sink.Close()
+
+	for i, released := range []chan error{retried, queued} {
+		select {
+		case err := <-released:
+			if err == nil {
+				t.Errorf("batch %d: expected a nack on close", i)
+			}
+		default:
+			t.Errorf("batch %d: not released on close", i)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/prom"
+)
+
+const (
+	lineMaxDatagram = 1400
+	lineMaxPending  = 1 << 20
+	lineTimeout     = 10 * time.Second
+)
+
+var defaultLineTemplates = map[string]string{
+	"statsd":   "{{.Service}}.{{.Group}}.{{.Namespace}}.{{.Hostname}}.{{.Metric}}",
+	"graphite": "{{.Service}}.{{.Group}}.{{.Namespace}}.{{.Hostname}}.{{.Metric}}",
+	"influx":   "{{.Metric}}",
+}
+
+var (
+	unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+	repeatedDots    = regexp.MustCompile(`\.{2,}`)
+)
+
+// nameData is what the naming templates are executed with
+type nameData struct {
+	Service   string
+	Group     string
+	Namespace string
+	Hostname  string
+	Metric    string
+}
+
+type LineOptions struct {
+	Format   string // statsd, graphite or influx
+	Address  string // udp://host:port, tcp://host:port or http(s)://host:port/path
+	Template string
+	Mode     string // events or snapshot
+	Interval time.Duration
+}
+
+/*
+ * LineWriter sends the events (or periodic snapshots of the registry) as
+ * StatsD packets, Graphite plaintext or Influx line protocol
+ */
+
+type LineWriter struct {
+	format   string
+	events   bool
+	template *template.Template
+	address  *url.URL
+
+	mu      sync.Mutex
+	pending bytes.Buffer
+
+	conn   net.Conn
+	client *http.Client
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func NewLineWriter(options LineOptions) (*LineWriter, error) {
+	defaultTemplate, ok := defaultLineTemplates[options.Format]
+	if !ok {
+		return nil, fmt.Errorf("unknown line format: %s", options.Format)
+	}
+
+	if options.Mode != "events" && options.Mode != "snapshot" {
+		return nil, fmt.Errorf("unknown line mode: %s", options.Mode)
+	}
+
+	if len(options.Template) == 0 {
+		options.Template = defaultTemplate
+	}
+
+	nameTemplate, err := template.New(options.Format).Parse(options.Template)
+	if err != nil {
+		return nil, fmt.Errorf("line template: %w", err)
+	}
+
+	address, err := url.Parse(options.Address)
+	if err != nil {
+		return nil, fmt.Errorf("line address: %w", err)
+	}
+
+	switch address.Scheme {
+	case "udp", "tcp":
+	case "http", "https":
+		// only influx has an http write api
+		if options.Format != "influx" {
+			return nil, fmt.Errorf("%s line transport is only supported by influx, not %s", address.Scheme, options.Format)
+		}
+	default:
+		return nil, fmt.Errorf("unknown line transport: %s", address.Scheme)
+	}
+
+	writer := &LineWriter{
+		format:   options.Format,
+		events:   options.Mode == "events",
+		template: nameTemplate,
+		address:  address,
+		client:   &http.Client{Timeout: lineTimeout},
+		stop:     make(chan struct{}),
+	}
+
+	writer.done.Add(1)
+	go writer.flushLoop(options.Interval)
+
+	return writer, nil
+}
+
+func (writer *LineWriter) name(data nameData) string {
+	namespace := data.Namespace
+	if writer.format != "influx" {
+		data.Service = unsafeNameChars.ReplaceAllString(data.Service, "_")
+		data.Group = unsafeNameChars.ReplaceAllString(data.Group, "_")
+		data.Namespace = unsafeNameChars.ReplaceAllString(data.Namespace, "_")
+		data.Hostname = unsafeNameChars.ReplaceAllString(data.Hostname, "_")
+		data.Metric = unsafeNameChars.ReplaceAllString(data.Metric, "_")
+	}
+
+	var buf strings.Builder
+	if err := writer.template.Execute(&buf, data); err != nil {
+		prom.ReportError("line_template", namespace, "line template execute: %+v", err)
+		return data.Metric
+	}
+
+	if writer.format == "influx" {
+		return buf.String()
+	}
+
+	// missing fields leave empty path segments
+	return strings.Trim(repeatedDots.ReplaceAllString(buf.String(), "."), ".")
+}
+
+func (writer *LineWriter) Push(namespace *flow.Namespace, hostname string, event flow.Event, msg pulsar.ConsumerMessage) {
+	if !writer.events {
+		return
+	}
+
+	timestamp := event.Time()
+
+	var lines bytes.Buffer
+	for name, value := range event.Metrics() {
+		metric, exists := namespace.Metrics[name]
+		if !exists {
+			continue
+		}
+
+		data := nameData{
+			Service:   namespace.Service,
+			Group:     namespace.Group,
+			Namespace: namespace.Name,
+			Hostname:  hostname,
+			Metric:    name,
+		}
+		writer.appendEventLine(&lines, data, metric, value, timestamp)
+	}
+
+	writer.write(lines.Bytes())
+}
+
+func (writer *LineWriter) appendEventLine(lines *bytes.Buffer, data nameData, metric *prom.Metric, value any, timestamp time.Time) {
+	if writer.format == "statsd" && metric.Type == "distinct" {
+		fmt.Fprintf(lines, "%s:%s|s\n", writer.name(data), statsdSetEscaper.Replace(fmt.Sprint(value)))
+		return
+	}
+
+	metricValue, ok := writer.eventValue(data, metric, value)
+	if !ok {
+		return
+	}
+
+	switch writer.format {
+	case "statsd":
+		writer.appendStatsd(lines, writer.name(data), metric, metricValue)
+	case "graphite":
+		fmt.Fprintf(lines, "%s %s %d\n", writer.name(data), formatValue(metricValue), timestamp.Unix())
+	case "influx":
+		appendInflux(lines, writer.name(data), namespaceTags(data), metricValue, timestamp)
+	}
+}
+
+// eventValue is the value sent for an event: the event value, or the value the
+// gauge operations result in, statsd having deltas for inc, dec and add
+func (writer *LineWriter) eventValue(data nameData, metric *prom.Metric, value any) (float64, bool) {
+	if err := metric.Check(value); err != nil {
+		return 0, false
+	}
+
+	if metric.Type != "gauge" {
+		return toFloat64(value)
+	}
+
+	switch metric.Operation {
+	case "", "set":
+		return toFloat64(value)
+	case "inc", "dec":
+		if writer.format == "statsd" {
+			return 0, true
+		}
+	case "add":
+		if writer.format == "statsd" {
+			return toFloat64(value)
+		}
+	}
+
+	return metric.GaugeValue(prometheus.Labels(namespaceTags(data)))
+}
+
+func (writer *LineWriter) appendStatsd(lines *bytes.Buffer, name string, metric *prom.Metric, value float64) {
+	switch metric.Type {
+	case "counter":
+		fmt.Fprintf(lines, "%s:%s|c\n", name, formatValue(value))
+	case "histogram", "summary":
+		fmt.Fprintf(lines, "%s:%s|ms\n", name, formatValue(value))
+	case "gauge":
+		switch metric.Operation {
+		case "inc":
+			fmt.Fprintf(lines, "%s:+1|g\n", name)
+		case "dec":
+			fmt.Fprintf(lines, "%s:-1|g\n", name)
+		case "add":
+			sign := ""
+			if value >= 0 {
+				sign = "+"
+			}
+			fmt.Fprintf(lines, "%s:%s%s|g\n", name, sign, formatValue(value))
+		default:
+			appendStatsdGauge(lines, name, value)
+		}
+	}
+}
+
+// appendStatsdGauge sets the gauge, a negative value needs a reset to 0 first to not be taken as a delta
+func appendStatsdGauge(lines *bytes.Buffer, name string, value float64) {
+	if value < 0 {
+		fmt.Fprintf(lines, "%s:0|g\n", name)
+	}
+	fmt.Fprintf(lines, "%s:%s|g\n", name, formatValue(value))
+}
+
+func namespaceTags(data nameData) map[string]string {
+	return map[string]string{
+		"service":   data.Service,
+		"group":     data.Group,
+		"namespace": data.Namespace,
+		"hostname":  data.Hostname,
+	}
+}
+
+var (
+	influxEscaper            = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	statsdSetEscaper         = strings.NewReplacer(":", "_", "|", "_", "\n", "_")
+)
+
+func appendInflux(lines *bytes.Buffer, measurement string, tags map[string]string, value float64, timestamp time.Time) {
+	lines.WriteString(influxMeasurementEscaper.Replace(measurement))
+	for _, name := range sortedLabelNames(tags) {
+		if len(tags[name]) == 0 {
+			continue
+		}
+		fmt.Fprintf(lines, ",%s=%s", influxEscaper.Replace(name), influxEscaper.Replace(tags[name]))
+	}
+	fmt.Fprintf(lines, " value=%s %d\n", formatValue(value), timestamp.UnixNano())
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// snapshot writes every sample of the registry, counters are sent as gauges
+// since they are cumulative
+func (writer *LineWriter) snapshot() {
+	now := time.Now()
+
+	var lines bytes.Buffer
+	for _, s := range gatherSamples(prom.Gatherer(), now) {
+		data := nameData{
+			Service:   s.labels["service"],
+			Group:     s.labels["group"],
+			Namespace: s.labels["namespace"],
+			Hostname:  s.labels["hostname"],
+			Metric:    s.name,
+		}
+
+		// the bucket and quantile labels are only kept as tags by influx
+		for _, label := range []string{"le", "quantile"} {
+			if value, exists := s.labels[label]; exists {
+				data.Metric += "_" + label + "_" + value
+			}
+		}
+
+		switch writer.format {
+		case "statsd":
+			appendStatsdGauge(&lines, writer.name(data), s.value)
+		case "graphite":
+			fmt.Fprintf(&lines, "%s %s %d\n", writer.name(data), formatValue(s.value), now.Unix())
+		case "influx":
+			data.Metric = s.name
+			appendInflux(&lines, writer.name(data), s.labels, s.value, now)
+		}
+	}
+
+	writer.write(lines.Bytes())
+}
+
+func (writer *LineWriter) write(lines []byte) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	if writer.pending.Len()+len(lines) > lineMaxPending {
+		logrus.Warnf("line output buffer full, dropping %d bytes", len(lines))
+		return
+	}
+	writer.pending.Write(lines)
+}
+
+func (writer *LineWriter) flushLoop(interval time.Duration) {
+	defer writer.done.Done()
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if !writer.events {
+				writer.snapshot()
+			}
+			writer.flush()
+		case <-writer.stop:
+			writer.flush()
+			return
+		}
+	}
+}
+
+func (writer *LineWriter) flush() {
+	writer.mu.Lock()
+	lines := bytes.Clone(writer.pending.Bytes())
+	writer.pending.Reset()
+	writer.mu.Unlock()
+
+	if len(lines) == 0 {
+		return
+	}
+
+	var err error
+	switch writer.address.Scheme {
+	case "udp":
+		err = writer.sendUDP(lines)
+	case "tcp":
+		err = writer.sendTCP(lines)
+	default:
+		err = writer.sendHTTP(lines)
+	}
+
+	if err != nil {
+		logrus.Errorf("line output %s: %+v", writer.address.Redacted(), err)
+	}
+}
+
+// sendUDP splits the lines in datagrams that fit the usual MTU
+func (writer *LineWriter) sendUDP(lines []byte) error {
+	if writer.conn == nil {
+		conn, err := net.Dial("udp", writer.address.Host)
+		if err != nil {
+			return err
+		}
+		writer.conn = conn
+	}
+
+	for len(lines) > 0 {
+		end := len(lines)
+		if end > lineMaxDatagram {
+			end = bytes.LastIndexByte(lines[:lineMaxDatagram], '\n') + 1
+			if end == 0 {
+				end = bytes.IndexByte(lines, '\n') + 1
+			}
+		}
+
+		if _, err := writer.conn.Write(lines[:end]); err != nil {
+			return err
+		}
+		lines = lines[end:]
+	}
+
+	return nil
+}
+
+// sendTCP reconnects once when the connection was closed
+func (writer *LineWriter) sendTCP(lines []byte) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		if writer.conn == nil {
+			conn, err := net.DialTimeout("tcp", writer.address.Host, lineTimeout)
+			if err != nil {
+				return err
+			}
+			writer.conn = conn
+		}
+
+		writer.conn.SetWriteDeadline(time.Now().Add(lineTimeout))
+		_, err := writer.conn.Write(lines)
+		if err == nil {
+			return nil
+		}
+
+		writer.conn.Close()
+		writer.conn = nil
+		if attempt == 1 {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (writer *LineWriter) sendHTTP(lines []byte) error {
+	res, err := writer.client.Post(writer.address.String(), "text/plain; charset=utf-8", bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", res.Status)
+	}
+
+	return nil
+}
+
+func (writer *LineWriter) Close() {
+	close(writer.stop)
+	writer.done.Wait()
+
+	if writer.conn != nil {
+		writer.conn.Close()
+	}
+}
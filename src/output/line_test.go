@@ -0,0 +1,143 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+func TestLineTransports(t *testing.T) {
+	tests := []struct {
+		format  string
+		address string
+		valid   bool
+	}{
+		{"statsd", "udp://localhost:8125", true},
+		{"graphite", "tcp://localhost:2003", true},
+		{"influx", "udp://localhost:8089", true},
+		{"influx", "http://localhost:8086/write?db=metrics", true},
+		{"influx", "https://localhost:8086/write?db=metrics", true},
+		{"statsd", "http://localhost:8125", false},
+		{"graphite", "https://localhost:2003", false},
+		{"graphite", "unix:///var/run/graphite.sock", false},
+	}
+
+	for _, test := range tests {
+		writer, err := NewLineWriter(LineOptions{
+			Format:   test.format,
+			Address:  test.address,
+			Mode:     "events",
+			Interval: time.Minute,
+		})
+		if (err == nil) != test.valid {
+			t.Errorf("%s %s: expected valid %v, got %v", test.format, test.address, test.valid, err)
+		}
+
+		if writer != nil {
+			writer.Close()
+		}
+	}
+}
+
+func newFormatWriter(format string) *LineWriter {
+	return &LineWriter{
+		format:   format,
+		events:   true,
+		template: template.Must(template.New(format).Parse(defaultLineTemplates[format])),
+	}
+}
+
+func TestLineFormats(t *testing.T) {
+	timestamp := time.Unix(1700000000, 500)
+	data := nameData{Service: "web shop", Group: "a=b", Namespace: "orders", Hostname: "web-1.example.com"}
+
+	tests := []struct {
+		format string
+		metric *prom.Metric
+		value  any
+		line   string
+	}{
+		{"statsd", &prom.Metric{Name: "requests", Type: "counter"}, 2, "web_shop.a_b.orders.web-1_example_com.requests:2|c\n"},
+		{"statsd", &prom.Metric{Name: "latency", Type: "histogram"}, 0.25, "web_shop.a_b.orders.web-1_example_com.latency:0.25|ms\n"},
+		{"statsd", &prom.Metric{Name: "users", Type: "distinct"}, "a:b|c", "web_shop.a_b.orders.web-1_example_com.users:a_b_c|s\n"},
+		{"statsd", &prom.Metric{Name: "queue", Type: "gauge"}, -3, "web_shop.a_b.orders.web-1_example_com.queue:0|g\nweb_shop.a_b.orders.web-1_example_com.queue:-3|g\n"},
+		{"statsd", &prom.Metric{Name: "queue", Type: "gauge", Operation: "add"}, 2.5, "web_shop.a_b.orders.web-1_example_com.queue:+2.5|g\n"},
+		{"statsd", &prom.Metric{Name: "queue", Type: "gauge", Operation: "dec"}, "any", "web_shop.a_b.orders.web-1_example_com.queue:-1|g\n"},
+		{"statsd", &prom.Metric{Name: "requests", Type: "counter"}, 1.5, ""},
+		{"graphite", &prom.Metric{Name: "requests.total", Type: "counter"}, 2, "web_shop.a_b.orders.web-1_example_com.requests_total 2 1700000000\n"},
+		{"graphite", &prom.Metric{Name: "latency", Type: "summary"}, 1.5, "web_shop.a_b.orders.web-1_example_com.latency 1.5 1700000000\n"},
+		{"influx", &prom.Metric{Name: "requests, total", Type: "counter"}, 2, "requests\\,\\ total,group=a\\=b,hostname=web-1.example.com,namespace=orders,service=web\\ shop value=2 1700000000000000500\n"},
+		{"influx", &prom.Metric{Name: "latency", Type: "histogram"}, 2, ""},
+	}
+
+	for _, test := range tests {
+		var lines bytes.Buffer
+		data.Metric = test.metric.Name
+		newFormatWriter(test.format).appendEventLine(&lines, data, test.metric, test.value, timestamp)
+
+		if lines.String() != test.line {
+			t.Errorf("%s %s %v: expected %q, got %q", test.format, test.metric.Type, test.value, test.line, lines.String())
+		}
+	}
+}
+
+// the gauge operations send the value the gauge results in, after the update of each event
+func TestLineGaugeOperations(t *testing.T) {
+	timestamp := time.Unix(1700000000, 0)
+	data := nameData{Service: "service", Group: "group", Namespace: "orders", Hostname: "host"}
+	labels := prometheus.Labels{"service": "service", "group": "group", "namespace": "orders", "hostname": "host"}
+
+	tests := []struct {
+		format    string
+		operation string
+		values    []any
+		line      string
+	}{
+		{"statsd", "set_max", []any{5, 3}, "service.group.orders.host.%s:5|g\n"},
+		{"statsd", "set_min", []any{5, 3, 4}, "service.group.orders.host.%s:3|g\n"},
+		{"graphite", "inc", []any{"a", "b"}, "service.group.orders.host.%s 2 1700000000\n"},
+		{"graphite", "add", []any{2, 0.5}, "service.group.orders.host.%s 2.5 1700000000\n"},
+		{"graphite", "set_max", []any{5, 3}, "service.group.orders.host.%s 5 1700000000\n"},
+		{"influx", "dec", []any{1, 1, 1}, "%s,group=group,hostname=host,namespace=orders,service=service value=-3 1700000000000000000\n"},
+		{"influx", "set_min", []any{5, -1, 3}, "%s,group=group,hostname=host,namespace=orders,service=service value=-1 1700000000000000000\n"},
+	}
+
+	for i, test := range tests {
+		metric := &prom.Metric{Name: fmt.Sprintf("line_test_gauge_%d", i), Type: "gauge", Operation: test.operation}
+		metric.AddPromMetric(prom.NewSeriesLimit(0))
+		// registered once for the repeated runs
+		metric.PromMetric.(*prometheus.GaugeVec).Reset()
+		writer := newFormatWriter(test.format)
+		data.Metric = metric.Name
+
+		var lines bytes.Buffer
+		for _, value := range test.values {
+			lines.Reset()
+			metric.Update(value, maps.Clone(labels))
+			writer.appendEventLine(&lines, data, metric, value, timestamp)
+		}
+
+		if expected := fmt.Sprintf(test.line, metric.Name); lines.String() != expected {
+			t.Errorf("%s %s %v: expected %q, got %q", test.format, test.operation, test.values, expected, lines.String())
+		}
+	}
+
+	// the time the gauge is set to, not the event value
+	metric := &prom.Metric{Name: "line_test_gauge_time", Type: "gauge", Operation: "set_to_current_time"}
+	metric.AddPromMetric(prom.NewSeriesLimit(0))
+	data.Metric = metric.Name
+	before := time.Now()
+	metric.Update(1, maps.Clone(labels))
+
+	value, ok := newFormatWriter("graphite").eventValue(data, metric, 1)
+	if !ok || value < float64(before.Unix()) || value > float64(time.Now().Unix()+1) {
+		t.Errorf("expected the current time, got %v", value)
+	}
+}
@@ -0,0 +1,376 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	otelmetric "go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/prom"
+)
+
+const (
+	otlpScope          = "streaming-metrics"
+	otlpDefaultMaxSize = 160
+	otlpShutdownTime   = 30 * time.Second
+)
+
+type OTLPOptions struct {
+	Endpoint   string
+	Protocol   string // grpc or http
+	Insecure   bool
+	Interval   time.Duration
+	Attributes string // datapoint or resource
+}
+
+/*
+ * otlpMeter holds the instruments of one meter provider, there is one per
+ * service when the service is a resource attribute
+ */
+
+type otlpMeter struct {
+	service  string
+	provider *sdkmetric.MeterProvider
+	meter    otelmetric.Meter
+
+	counters   map[string]otelmetric.Float64Counter
+	histograms map[string]otelmetric.Float64Histogram
+	observed   map[string]bool
+}
+
+/*
+ * OTLPExporter maps the namespace metrics to OpenTelemetry instruments,
+ * counters and histograms (and summaries) are recorded on every event while
+ * gauges, distinct and state set metrics are observed from the prometheus vectors.
+ * The instruments of the namespaces created or replaced by the admin api are
+ * added to the meters, with the views of the startup namespaces.
+ */
+
+type OTLPExporter struct {
+	options            OTLPOptions
+	resourceAttributes bool
+
+	mu     sync.RWMutex
+	meters map[string]*otlpMeter
+}
+
+func NewOTLPExporter(options OTLPOptions, namespaces map[string]*flow.Namespace) (*OTLPExporter, error) {
+	if options.Attributes != "datapoint" && options.Attributes != "resource" {
+		return nil, fmt.Errorf("unknown otlp attributes: %s", options.Attributes)
+	}
+
+	exporter := &OTLPExporter{
+		options:            options,
+		resourceAttributes: options.Attributes == "resource",
+		meters:             make(map[string]*otlpMeter),
+	}
+
+	services := map[string]bool{"": true}
+	if exporter.resourceAttributes {
+		services = make(map[string]bool)
+		for _, namespace := range namespaces {
+			services[namespace.Service] = true
+		}
+	}
+
+	for service := range services {
+		meter, err := newOTLPMeter(options, service, namespaces)
+		if err != nil {
+			exporter.Close()
+			return nil, err
+		}
+
+		exporter.meters[service] = meter
+	}
+
+	return exporter, nil
+}
+
+func newOTLPMetricExporter(options OTLPOptions) (sdkmetric.Exporter, error) {
+	ctx := context.Background()
+
+	switch options.Protocol {
+	case "grpc":
+		grpcOptions := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(options.Endpoint)}
+		if options.Insecure {
+			grpcOptions = append(grpcOptions, otlpmetricgrpc.WithInsecure())
+		}
+		return otlpmetricgrpc.New(ctx, grpcOptions...)
+	case "http":
+		httpOptions := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(options.Endpoint)}
+		if options.Insecure {
+			httpOptions = append(httpOptions, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, httpOptions...)
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol: %s", options.Protocol)
+	}
+}
+
+// nativeHistogramViews use exponential histograms for the histograms with a native bucket factor
+func nativeHistogramViews(namespaces map[string]*flow.Namespace) []sdkmetric.View {
+	views := make([]sdkmetric.View, 0)
+	seen := make(map[string]bool)
+
+	for _, namespace := range namespaces {
+		for name, metric := range namespace.Metrics {
+			if metric.Type != "histogram" || metric.NativeBucketFactor <= 1 || seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			maxSize := int32(metric.NativeMaxBucketNumber)
+			if maxSize == 0 {
+				maxSize = otlpDefaultMaxSize
+			}
+
+			views = append(views, sdkmetric.NewView(
+				sdkmetric.Instrument{Name: name},
+				sdkmetric.Stream{Aggregation: sdkmetric.AggregationBase2ExponentialHistogram{MaxSize: maxSize, MaxScale: 20}},
+			))
+		}
+	}
+
+	return views
+}
+
+func newOTLPMeter(options OTLPOptions, service string, namespaces map[string]*flow.Namespace) (*otlpMeter, error) {
+	metricExporter, err := newOTLPMetricExporter(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReaderMeter(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(options.Interval)), service, namespaces)
+}
+
+func newReaderMeter(reader sdkmetric.Reader, service string, namespaces map[string]*flow.Namespace) (*otlpMeter, error) {
+	providerOptions := []sdkmetric.Option{
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithView(nativeHistogramViews(namespaces)...),
+	}
+	if len(service) > 0 {
+		providerOptions = append(providerOptions, sdkmetric.WithResource(resource.NewSchemaless(semconv.ServiceName(service))))
+	}
+
+	meter := &otlpMeter{
+		service:    service,
+		provider:   sdkmetric.NewMeterProvider(providerOptions...),
+		counters:   make(map[string]otelmetric.Float64Counter),
+		histograms: make(map[string]otelmetric.Float64Histogram),
+		observed:   make(map[string]bool),
+	}
+	meter.meter = meter.provider.Meter(otlpScope)
+
+	for _, namespace := range namespaces {
+		if err := meter.addNamespace(namespace); err != nil {
+			meter.provider.Shutdown(context.Background())
+			return nil, err
+		}
+	}
+
+	return meter, nil
+}
+
+// addNamespace creates the instruments of the namespace metrics not created yet
+func (meter *otlpMeter) addNamespace(namespace *flow.Namespace) error {
+	if len(meter.service) > 0 && namespace.Service != meter.service {
+		return nil
+	}
+
+	for name, metric := range namespace.Metrics {
+		if err := meter.addInstrument(name, metric); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (meter *otlpMeter) addInstrument(name string, metric *prom.Metric) error {
+	var err error
+
+	switch metric.Type {
+	case "counter":
+		if _, exists := meter.counters[name]; exists {
+			return nil
+		}
+		meter.counters[name], err = meter.meter.Float64Counter(name, otelmetric.WithDescription(metric.Help))
+
+	case "histogram", "summary":
+		if _, exists := meter.histograms[name]; exists {
+			return nil
+		}
+		histogramOptions := []otelmetric.Float64HistogramOption{otelmetric.WithDescription(metric.Help)}
+		if len(metric.Buckets) > 0 {
+			histogramOptions = append(histogramOptions, otelmetric.WithExplicitBucketBoundaries(metric.Buckets...))
+		}
+		meter.histograms[name], err = meter.meter.Float64Histogram(name, histogramOptions...)
+
+	case "gauge", "distinct", "state_set":
+		if meter.observed[name] {
+			return nil
+		}
+		meter.observed[name] = true
+
+		collector := metric.PromMetric
+		_, err = meter.meter.Float64ObservableGauge(
+			name,
+			otelmetric.WithDescription(metric.Help),
+			otelmetric.WithFloat64Callback(func(ctx context.Context, observer otelmetric.Float64Observer) error {
+				meter.observe(collector, observer)
+				return nil
+			}),
+		)
+
+	default:
+		logrus.Warnf("otlp: unsupported metric type %s for %s", metric.Type, name)
+	}
+
+	return err
+}
+
+// observe records the current value of every series of a prometheus gauge vector
+func (meter *otlpMeter) observe(collector prometheus.Collector, observer otelmetric.Float64Observer) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(metrics)
+		close(metrics)
+	}()
+
+	for metric := range metrics {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			logrus.Errorf("otlp observe write: %+v", err)
+			continue
+		}
+
+		labels := make(map[string]string, len(m.Label))
+		for _, label := range m.Label {
+			labels[label.GetName()] = label.GetValue()
+		}
+
+		if len(meter.service) > 0 && labels["service"] != meter.service {
+			continue
+		}
+
+		observer.Observe(m.GetGauge().GetValue(), otelmetric.WithAttributeSet(meter.attributes(labels)))
+	}
+}
+
+// attributes leaves the service out when it is a resource attribute
+func (meter *otlpMeter) attributes(labels map[string]string) attribute.Set {
+	attributes := make([]attribute.KeyValue, 0, len(labels))
+	for name, value := range labels {
+		if name == "service" && len(meter.service) > 0 {
+			continue
+		}
+		attributes = append(attributes, attribute.String(name, value))
+	}
+
+	return attribute.NewSet(attributes...)
+}
+
+func (exporter *OTLPExporter) Push(namespace *flow.Namespace, hostname string, event flow.Event, msg pulsar.ConsumerMessage) {
+	service := ""
+	if exporter.resourceAttributes {
+		service = namespace.Service
+	}
+
+	exporter.mu.RLock()
+	defer exporter.mu.RUnlock()
+
+	meter, exists := exporter.meters[service]
+	if !exists {
+		prom.ReportError("otlp_no_meter", namespace.Name, "otlp: no meter for service %s", service)
+		return
+	}
+
+	meter.record(namespace, hostname, event.Metrics())
+}
+
+// record adds the counter values and records the histogram values of an event
+func (meter *otlpMeter) record(namespace *flow.Namespace, hostname string, metrics map[string]any) {
+	ctx := context.Background()
+	attributes := otelmetric.WithAttributeSet(meter.attributes(namespaceLabels(namespace, hostname)))
+
+	for name, value := range metrics {
+		metric, exists := namespace.Metrics[name]
+		if !exists {
+			continue
+		}
+
+		// the values rejected by the prometheus metrics, which report them
+		if err := metric.Check(value); err != nil {
+			continue
+		}
+
+		metricValue, ok := toFloat64(value)
+		if !ok {
+			continue
+		}
+
+		if counter, exists := meter.counters[name]; exists {
+			counter.Add(ctx, metricValue, attributes)
+		} else if histogram, exists := meter.histograms[name]; exists {
+			histogram.Record(ctx, metricValue, attributes)
+		}
+	}
+}
+
+// NamespaceChanged creates the instruments of a created or replaced namespace,
+// and the meter of its service when it is a new one
+func (exporter *OTLPExporter) NamespaceChanged(previous *flow.Namespace, namespace *flow.Namespace) {
+	if namespace == nil {
+		return
+	}
+
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+
+	service := ""
+	if exporter.resourceAttributes {
+		service = namespace.Service
+	}
+
+	meter, exists := exporter.meters[service]
+	if !exists {
+		var err error
+		meter, err = newOTLPMeter(exporter.options, service, map[string]*flow.Namespace{namespace.Name: namespace})
+		if err != nil {
+			logrus.Errorf("otlp namespace %s: %+v", namespace.Name, err)
+			return
+		}
+
+		exporter.meters[service] = meter
+		return
+	}
+
+	if err := meter.addNamespace(namespace); err != nil {
+		logrus.Errorf("otlp namespace %s: %+v", namespace.Name, err)
+	}
+}
+
+// Close flushes and shuts down every meter provider
+func (exporter *OTLPExporter) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), otlpShutdownTime)
+	defer cancel()
+
+	for service, meter := range exporter.meters {
+		if err := meter.provider.Shutdown(ctx); err != nil {
+			logrus.Errorf("otlp shutdown %s: %+v", service, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package output
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/prom"
+)
+
+func TestOTLPRecord(t *testing.T) {
+	temperature := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "otlp_test_temperature", Help: "test"}, []string{"service", "group", "namespace", "hostname"})
+	namespace := &flow.Namespace{
+		Name:    "orders",
+		Group:   "group",
+		Service: "service",
+		Metrics: map[string]*prom.Metric{
+			"requests":    {Name: "requests", Type: "counter"},
+			"latency":     {Name: "latency", Type: "histogram", Buckets: []float64{1, 5}},
+			"temperature": {Name: "temperature", Type: "gauge", PromMetric: temperature},
+		},
+	}
+
+	reader := sdkmetric.NewManualReader()
+	meter, err := newReaderMeter(reader, "", map[string]*flow.Namespace{"orders": namespace})
+	if err != nil {
+		t.Fatalf("meter: %v", err)
+	}
+	defer meter.provider.Shutdown(context.Background())
+
+	// a float counter and an int histogram value are rejected, as by the prometheus metrics
+	meter.record(namespace, "host", map[string]any{"requests": 2, "latency": 0.5})
+	meter.record(namespace, "host", map[string]any{"requests": 1.5, "latency": 2})
+	meter.record(namespace, "host", map[string]any{"requests": 3, "latency": 1.5, "unknown": 1})
+	temperature.With(prometheus.Labels{"service": "service", "group": "group", "namespace": "orders", "hostname": "host"}).Set(21)
+
+	var collected metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &collected); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+
+	metrics := make(map[string]metricdata.Metrics)
+	for _, scope := range collected.ScopeMetrics {
+		for _, metric := range scope.Metrics {
+			metrics[metric.Name] = metric
+		}
+	}
+	if len(metrics) != 3 {
+		t.Fatalf("expected 3 instruments, got %v", metrics)
+	}
+
+	expectedAttributes := attribute.NewSet(
+		attribute.String("service", "service"),
+		attribute.String("group", "group"),
+		attribute.String("namespace", "orders"),
+		attribute.String("hostname", "host"),
+	)
+
+	counter, ok := metrics["requests"].Data.(metricdata.Sum[float64])
+	if !ok || len(counter.DataPoints) != 1 {
+		t.Fatalf("expected a counter data point, got %+v", metrics["requests"].Data)
+	}
+	if point := counter.DataPoints[0]; point.Value != 5 || !point.Attributes.Equals(&expectedAttributes) {
+		t.Errorf("expected the counter at 5 with the namespace attributes, got %v %v", point.Value, point.Attributes.ToSlice())
+	}
+
+	histogram, ok := metrics["latency"].Data.(metricdata.Histogram[float64])
+	if !ok || len(histogram.DataPoints) != 1 {
+		t.Fatalf("expected a histogram data point, got %+v", metrics["latency"].Data)
+	}
+	point := histogram.DataPoints[0]
+	if point.Count != 2 || point.Sum != 2 {
+		t.Errorf("expected 2 values summing to 2, got %d values summing to %v", point.Count, point.Sum)
+	}
+	if len(point.Bounds) != 2 || point.BucketCounts[0] != 1 || point.BucketCounts[1] != 1 {
+		t.Errorf("expected a value in each of the configured buckets, got %v %v", point.Bounds, point.BucketCounts)
+	}
+
+	gauge, ok := metrics["temperature"].Data.(metricdata.Gauge[float64])
+	if !ok || len(gauge.DataPoints) != 1 {
+		t.Fatalf("expected an observed gauge data point, got %+v", metrics["temperature"].Data)
+	}
+	if point := gauge.DataPoints[0]; point.Value != 21 || !point.Attributes.Equals(&expectedAttributes) {
+		t.Errorf("expected the gauge at 21 with the namespace attributes, got %v %v", point.Value, point.Attributes.ToSlice())
+	}
+}
@@ -0,0 +1,275 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/prom"
+)
+
+const (
+	pulsarFlushTimeout = 30 * time.Second
+
+	// wait before creating again a producer that failed, doubled on each failure
+	producerBackoff    = time.Second
+	producerMaxBackoff = time.Minute
+)
+
+var pulsarMetrics = struct {
+	published     *prometheus.CounterVec
+	publishErrors *prometheus.CounterVec
+}{
+	published: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dest_published_events",
+			Help: "The number of events published to the destination topics",
+		}, []string{"topic"},
+	),
+	publishErrors: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dest_publish_errors",
+			Help: "The number of events that failed to be published to the destination topics",
+		}, []string{"topic"},
+	),
+}
+
+// PulsarEvent is the json published for every event
+type PulsarEvent struct {
+	Namespace       string            `json:"namespace"`
+	Time            string            `json:"time"`
+	Metrics         map[string]any    `json:"metrics"`
+	Labels          map[string]string `json:"labels"`
+	SourceMessageID string            `json:"source_message_id"`
+}
+
+// pendingSends counts the sends of the events of a source message not yet
+// confirmed, done is set once the message is released
+type pendingSends struct {
+	count int
+	err   error
+	done  func(error)
+}
+
+// producerEntry is the producer of a topic, ready is closed once its creation succeeded or failed
+type producerEntry struct {
+	ready    chan struct{}
+	producer pulsar.Producer
+	err      error
+
+	failures int
+	retryAt  time.Time
+}
+
+// retry tells if the creation failed and its backoff is over
+func (entry *producerEntry) retry(now time.Time) bool {
+	select {
+	case <-entry.ready:
+		return entry.err != nil && !now.Before(entry.retryAt)
+	default:
+		return false
+	}
+}
+
+func backoff(failures int) time.Duration {
+	return min(producerMaxBackoff, producerBackoff<<min(failures-1, 16))
+}
+
+/*
+ * PulsarPublisher publishes the events to the namespace destination topic,
+ * or to the default topic when the namespace has none. A source message is
+ * released once the sends of all its events are confirmed.
+ */
+
+type PulsarPublisher struct {
+	client       pulsar.Client
+	defaultTopic string
+
+	mu        sync.Mutex
+	producers map[string]*producerEntry
+
+	pendingMu sync.Mutex
+	pending   map[flow.MessageKey]*pendingSends
+}
+
+func NewPulsarPublisher(client pulsar.Client, defaultTopic string) *PulsarPublisher {
+	prom.MustRegister(pulsarMetrics.published, pulsarMetrics.publishErrors)
+
+	return &PulsarPublisher{
+		client:       client,
+		defaultTopic: defaultTopic,
+		producers:    make(map[string]*producerEntry),
+		pending:      make(map[flow.MessageKey]*pendingSends),
+	}
+}
+
+// producer of the topic, created once outside the lock while the pushes to the topic wait for it.
+// After a failure the pushes get its error until the backoff is over.
+func (publisher *PulsarPublisher) producer(topic string) (pulsar.Producer, error) {
+	now := time.Now()
+
+	publisher.mu.Lock()
+	entry, exists := publisher.producers[topic]
+	if exists && !entry.retry(now) {
+		publisher.mu.Unlock()
+		<-entry.ready
+		return entry.producer, entry.err
+	}
+
+	failures := 0
+	if exists {
+		failures = entry.failures
+	}
+	entry = &producerEntry{ready: make(chan struct{}), failures: failures}
+	publisher.producers[topic] = entry
+	publisher.mu.Unlock()
+
+	entry.producer, entry.err = publisher.client.CreateProducer(pulsar.ProducerOptions{
+		Topic: topic,
+	})
+	if entry.err != nil {
+		entry.failures++
+		entry.retryAt = time.Now().Add(backoff(entry.failures))
+		logrus.Warnf("pulsar publisher: producer %s not created, retrying in %v", topic, backoff(entry.failures))
+	} else {
+		logrus.Infof("created producer for destination topic %s", topic)
+	}
+	close(entry.ready)
+
+	return entry.producer, entry.err
+}
+
+func (publisher *PulsarPublisher) Push(namespace *flow.Namespace, hostname string, event flow.Event, msg pulsar.ConsumerMessage) {
+	topic := namespace.DestTopic
+	if len(topic) == 0 {
+		topic = publisher.defaultTopic
+	}
+	if len(topic) == 0 {
+		return
+	}
+
+	source := flow.KeyOf(msg)
+
+	payload, err := json.Marshal(PulsarEvent{
+		Namespace:       event.Namespace(),
+		Time:            event.RawTime(),
+		Metrics:         event.Metrics(),
+		Labels:          namespaceLabels(namespace, hostname),
+		SourceMessageID: source.String(),
+	})
+	if err != nil {
+		prom.ReportError("publish", namespace.Name, "pulsar publisher marshal: %+v", err)
+		pulsarMetrics.publishErrors.With(prometheus.Labels{"topic": topic}).Inc()
+		return
+	}
+
+	publisher.begin(source)
+
+	producer, err := publisher.producer(topic)
+	if err != nil {
+		prom.ReportError("publish", namespace.Name, "pulsar publisher create producer %s: %+v", topic, err)
+		pulsarMetrics.publishErrors.With(prometheus.Labels{"topic": topic}).Inc()
+		publisher.end(source, err)
+		return
+	}
+
+	producer.SendAsync(
+		context.Background(),
+		&pulsar.ProducerMessage{
+			Payload: payload,
+			Key:     namespace.Name,
+		},
+		func(id pulsar.MessageID, _ *pulsar.ProducerMessage, err error) {
+			if err != nil {
+				prom.ReportError("publish", namespace.Name, "pulsar publisher send %s: %+v", topic, err)
+				pulsarMetrics.publishErrors.With(prometheus.Labels{"topic": topic}).Inc()
+				publisher.end(source, err)
+				return
+			}
+
+			pulsarMetrics.published.With(prometheus.Labels{"topic": topic}).Inc()
+			publisher.end(source, nil)
+		},
+	)
+}
+
+func (publisher *PulsarPublisher) begin(source flow.MessageKey) {
+	publisher.pendingMu.Lock()
+	defer publisher.pendingMu.Unlock()
+
+	sends, exists := publisher.pending[source]
+	if !exists {
+		sends = &pendingSends{}
+		publisher.pending[source] = sends
+	}
+	sends.count++
+}
+
+// end confirms a send, the last one of a released message calls done
+func (publisher *PulsarPublisher) end(source flow.MessageKey, err error) {
+	publisher.pendingMu.Lock()
+	sends := publisher.pending[source]
+	sends.count--
+	if err != nil && sends.err == nil {
+		sends.err = err
+	}
+
+	finished := sends.count == 0 && sends.done != nil
+	if finished {
+		delete(publisher.pending, source)
+	}
+	publisher.pendingMu.Unlock()
+
+	if finished {
+		sends.done(sends.err)
+	}
+}
+
+// Release calls done once every event of msg is confirmed by the broker,
+// the events of a message are always pushed before it is released
+func (publisher *PulsarPublisher) Release(msg pulsar.ConsumerMessage, done func(error)) {
+	source := flow.KeyOf(msg)
+
+	publisher.pendingMu.Lock()
+	sends, exists := publisher.pending[source]
+	if !exists || sends.count == 0 {
+		delete(publisher.pending, source)
+		publisher.pendingMu.Unlock()
+
+		if exists {
+			done(sends.err)
+		} else {
+			done(nil)
+		}
+		return
+	}
+	sends.done = done
+	publisher.pendingMu.Unlock()
+}
+
+// Close flushes the pending messages of every producer
+func (publisher *PulsarPublisher) Close() {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pulsarFlushTimeout)
+	defer cancel()
+
+	for topic, entry := range publisher.producers {
+		<-entry.ready
+		if entry.producer == nil {
+			continue
+		}
+
+		if err := entry.producer.FlushWithCtx(ctx); err != nil {
+			logrus.Errorf("pulsar publisher flush %s: %+v", topic, err)
+		}
+		entry.producer.Close()
+	}
+}
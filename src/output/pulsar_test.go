@@ -0,0 +1,104 @@
+package output
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// creatingClient counts the producers created, failing while fail is set and
+// holding the creations of the blocked topics until release is closed
+type creatingClient struct {
+	pulsar.Client
+
+	created atomic.Int32
+	fail    atomic.Bool
+	blocked map[string]bool
+	release chan struct{}
+}
+
+func (client *creatingClient) CreateProducer(options pulsar.ProducerOptions) (pulsar.Producer, error) {
+	client.created.Add(1)
+	if client.blocked[options.Topic] {
+		<-client.release
+	}
+
+	if client.fail.Load() {
+		return nil, errors.New("broker unavailable")
+	}
+	return &topicProducer{topic: options.Topic}, nil
+}
+
+type topicProducer struct {
+	pulsar.Producer
+	topic string
+}
+
+func TestProducerSingleFlight(t *testing.T) {
+	client := &creatingClient{blocked: map[string]bool{"events": true}, release: make(chan struct{})}
+	publisher := &PulsarPublisher{client: client, producers: make(map[string]*producerEntry)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := publisher.producer("events"); err != nil {
+				t.Errorf("producer: %v", err)
+			}
+		}()
+	}
+
+	// another topic does not wait for the creation in progress
+	if _, err := publisher.producer("alerts"); err != nil {
+		t.Errorf("producer: %v", err)
+	}
+
+	close(client.release)
+	wg.Wait()
+	if created := client.created.Load(); created != 2 {
+		t.Errorf("expected a producer per topic, created %d", created)
+	}
+}
+
+func TestProducerBackoff(t *testing.T) {
+	client := &creatingClient{}
+	client.fail.Store(true)
+	publisher := &PulsarPublisher{client: client, producers: make(map[string]*producerEntry)}
+
+	if _, err := publisher.producer("events"); err == nil {
+		t.Fatalf("expected the creation error")
+	}
+	if _, err := publisher.producer("events"); err == nil || client.created.Load() != 1 {
+		t.Errorf("expected the error without creating again during the backoff, created %d", client.created.Load())
+	}
+
+	// the backoff is over
+	client.fail.Store(false)
+	publisher.producers["events"].retryAt = time.Now().Add(-time.Second)
+	producer, err := publisher.producer("events")
+	if err != nil || producer.(*topicProducer).topic != "events" {
+		t.Errorf("expected the producer after the backoff, got %v", err)
+	}
+
+	tests := []struct {
+		failures int
+		backoff  time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{7, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, test := range tests {
+		if got := backoff(test.failures); got != test.backoff {
+			t.Errorf("backoff(%d): expected %v, got %v", test.failures, test.backoff, got)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"example.com/streaming-metrics/src/flow"
+	"example.com/streaming-metrics/src/prom"
+)
+
+const (
+	remoteWriteQueueSize  = 100
+	remoteWriteMaxBatch   = 5000
+	remoteWriteMinBackoff = 100 * time.Millisecond
+	remoteWriteMaxBackoff = 30 * time.Second
+	remoteWriteMaxRetries = 10
+)
+
+var remoteWriteMetrics = struct {
+	queueLength    prometheus.Gauge
+	samplesSent    prometheus.Counter
+	samplesFailed  prometheus.Counter
+	samplesDropped prometheus.Counter
+	retries        prometheus.Counter
+}{
+	queueLength: prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "remote_write_queue_length",
+			Help: "The number of batches waiting to be sent to the remote write endpoint",
+		},
+	),
+	samplesSent: prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "remote_write_samples_sent",
+			Help: "The number of samples sent to the remote write endpoint",
+		},
+	),
+	samplesFailed: prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "remote_write_samples_failed",
+			Help: "The number of samples not accepted by the remote write endpoint after all retries",
+		},
+	),
+	samplesDropped: prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "remote_write_samples_dropped",
+			Help: "The number of samples dropped because the remote write queue was full",
+		},
+	),
+	retries: prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "remote_write_retries",
+			Help: "The number of retried requests to the remote write endpoint",
+		},
+	),
+}
+
+/*
+ * RemoteWriter pushes the registry (snapshot mode) or the event samples
+ * (events mode) to a Prometheus remote write endpoint
+ */
+
+type RemoteWriter struct {
+	url      string
+	events   bool
+	interval time.Duration
+	client   *http.Client
+
+	mu      sync.Mutex
+	pending []sample
+	closed  bool
+
+	queue chan []sample
+	stop  chan struct{}
+	done  sync.WaitGroup
+}
+
+func NewRemoteWriter(url string, mode string, interval time.Duration, timeout time.Duration) (*RemoteWriter, error) {
+	if mode != "snapshot" && mode != "events" {
+		return nil, fmt.Errorf("unknown remote write mode: %s", mode)
+	}
+
+	prom.MustRegister(
+		remoteWriteMetrics.queueLength,
+		remoteWriteMetrics.samplesSent,
+		remoteWriteMetrics.samplesFailed,
+		remoteWriteMetrics.samplesDropped,
+		remoteWriteMetrics.retries,
+	)
+
+	return startRemoteWriter(url, mode == "events", interval, timeout), nil
+}
+
+// startRemoteWriter starts the flushes and the sends, the metrics are registered by NewRemoteWriter
+func startRemoteWriter(url string, events bool, interval time.Duration, timeout time.Duration) *RemoteWriter {
+	writer := &RemoteWriter{
+		url:      url,
+		events:   events,
+		interval: interval,
+		client:   &http.Client{Timeout: timeout},
+		queue:    make(chan []sample, remoteWriteQueueSize),
+		stop:     make(chan struct{}),
+	}
+
+	writer.done.Add(2)
+	go writer.flushLoop()
+	go writer.sendLoop()
+
+	return writer
+}
+
+func (writer *RemoteWriter) Push(namespace *flow.Namespace, hostname string, event flow.Event, msg pulsar.ConsumerMessage) {
+	if !writer.events {
+		return
+	}
+
+	samples := eventSamples(namespaceLabels(namespace, hostname), event.Metrics(), event.Time())
+
+	writer.mu.Lock()
+	if writer.closed {
+		writer.mu.Unlock()
+		return
+	}
+	writer.pending = append(writer.pending, samples...)
+	full := len(writer.pending) >= remoteWriteMaxBatch
+	writer.mu.Unlock()
+
+	if full {
+		writer.flush()
+	}
+}
+
+// Close sends what is left and waits for the queue to drain
+func (writer *RemoteWriter) Close() {
+	close(writer.stop)
+	writer.done.Wait()
+}
+
+func (writer *RemoteWriter) flush() {
+	var samples []sample
+	if !writer.events {
+		samples = gatherSamples(prom.Gatherer(), time.Now())
+	}
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	if writer.closed {
+		return
+	}
+
+	if writer.events {
+		samples = writer.pending
+		writer.pending = nil
+	}
+
+	for len(samples) > 0 {
+		batch := samples[:min(len(samples), remoteWriteMaxBatch)]
+		samples = samples[len(batch):]
+
+		select {
+		case writer.queue <- batch:
+			remoteWriteMetrics.queueLength.Inc()
+		default:
+			logrus.Warnf("remote write queue full, dropping %d samples", len(batch))
+			remoteWriteMetrics.samplesDropped.Add(float64(len(batch)))
+		}
+	}
+}
+
+func (writer *RemoteWriter) flushLoop() {
+	defer writer.done.Done()
+
+	tick := time.NewTicker(writer.interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			writer.flush()
+		case <-writer.stop:
+			writer.flush()
+
+			writer.mu.Lock()
+			writer.closed = true
+			close(writer.queue)
+			writer.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (writer *RemoteWriter) sendLoop() {
+	defer writer.done.Done()
+
+	for batch := range writer.queue {
+		remoteWriteMetrics.queueLength.Dec()
+		writer.send(batch)
+	}
+}
+
+// send retries with exponential backoff on network errors, 5xx and 429. Once
+// stopping, a batch gets a single attempt without waiting: the one waiting to be
+// retried is sent right away, then the failed batches are dropped
+func (writer *RemoteWriter) send(batch []sample) {
+	body := snappy.Encode(nil, encodeWriteRequest(batch))
+
+	backoff := remoteWriteMinBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := writer.post(body)
+		if err == nil {
+			remoteWriteMetrics.samplesSent.Add(float64(len(batch)))
+			return
+		}
+
+		if !retry || attempt >= remoteWriteMaxRetries || writer.stopping() {
+			logrus.Errorf("remote write failed, dropping %d samples: %+v", len(batch), err)
+			remoteWriteMetrics.samplesFailed.Add(float64(len(batch)))
+			return
+		}
+
+		logrus.Warnf("remote write failed, retrying in %v: %+v", backoff, err)
+		remoteWriteMetrics.retries.Inc()
+		select {
+		case <-time.After(backoff):
+		case <-writer.stop:
+		}
+		backoff = min(backoff*2, remoteWriteMaxBackoff)
+	}
+}
+
+func (writer *RemoteWriter) stopping() bool {
+	select {
+	case <-writer.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (writer *RemoteWriter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, writer.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	res, err := writer.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode/100 == 2 {
+		return false, nil
+	}
+
+	retry := res.StatusCode/100 == 5 || res.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("remote write status %s", res.Status)
+}
+
+/*
+ * prometheus.WriteRequest protobuf encoding
+ *
+ * WriteRequest { repeated TimeSeries timeseries = 1; }
+ * TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+ * Label        { string name = 1; string value = 2; }
+ * Sample       { double value = 1; int64 timestamp = 2; }
+ */
+
+func encodeWriteRequest(samples []sample) []byte {
+	var buf []byte
+	for _, s := range samples {
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, encodeTimeSeries(s))
+	}
+
+	return buf
+}
+
+// encodeTimeSeries writes the labels sorted by name, with __name__ first as required
+func encodeTimeSeries(s sample) []byte {
+	var buf []byte
+	buf = appendLabel(buf, "__name__", s.name)
+	for _, name := range sortedLabelNames(s.labels) {
+		buf = appendLabel(buf, name, s.labels[name])
+	}
+
+	var sampleBuf []byte
+	sampleBuf = protowire.AppendTag(sampleBuf, 1, protowire.Fixed64Type)
+	sampleBuf = protowire.AppendFixed64(sampleBuf, math.Float64bits(s.value))
+	sampleBuf = protowire.AppendTag(sampleBuf, 2, protowire.VarintType)
+	sampleBuf = protowire.AppendVarint(sampleBuf, uint64(s.timestamp.UnixMilli()))
+
+	buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+	buf = protowire.AppendBytes(buf, sampleBuf)
+
+	return buf
+}
+
+func appendLabel(buf []byte, name string, value string) []byte {
+	var labelBuf []byte
+	labelBuf = protowire.AppendTag(labelBuf, 1, protowire.BytesType)
+	labelBuf = protowire.AppendString(labelBuf, name)
+	labelBuf = protowire.AppendTag(labelBuf, 2, protowire.BytesType)
+	labelBuf = protowire.AppendString(labelBuf, value)
+
+	buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+	return protowire.AppendBytes(buf, labelBuf)
+}
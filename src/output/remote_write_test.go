@@ -0,0 +1,314 @@
+package output
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+type receivedSeries struct {
+	labels    map[string]string
+	labelList []string
+	value     float64
+	timestamp int64
+}
+
+// receiver is a remote write endpoint answering the statuses in order, then 204
+type receiver struct {
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	series   []receivedSeries
+}
+
+func (receiver *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	receiver.requests++
+	if len(receiver.statuses) > 0 {
+		status := receiver.statuses[0]
+		receiver.statuses = receiver.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+
+	if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		receiver.t.Errorf("unexpected headers: %v", r.Header)
+	}
+
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		receiver.t.Fatalf("read body: %v", err)
+	}
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		receiver.t.Fatalf("snappy decode: %v", err)
+	}
+
+	receiver.series = append(receiver.series, decodeWriteRequest(receiver.t, body)...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (receiver *receiver) received() (int, []receivedSeries) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	return receiver.requests, receiver.series
+}
+
+// fields of a protobuf message, by number
+func decodeFields(t *testing.T, buf []byte) map[protowire.Number][][]byte {
+	fields := make(map[protowire.Number][][]byte)
+	for len(buf) > 0 {
+		number, typ, n := protowire.ConsumeTag(buf)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		buf = buf[n:]
+
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(buf)
+			value, n = v, m
+		case protowire.Fixed64Type:
+			_, n = protowire.ConsumeFixed64(buf)
+			value = buf[:n]
+		case protowire.VarintType:
+			_, n = protowire.ConsumeVarint(buf)
+			value = buf[:n]
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+		if n < 0 {
+			t.Fatalf("bad field %d: %v", number, protowire.ParseError(n))
+		}
+		buf = buf[n:]
+		fields[number] = append(fields[number], value)
+	}
+
+	return fields
+}
+
+func decodeWriteRequest(t *testing.T, body []byte) []receivedSeries {
+	series := make([]receivedSeries, 0)
+	for _, timeSeries := range decodeFields(t, body)[1] {
+		fields := decodeFields(t, timeSeries)
+		s := receivedSeries{labels: make(map[string]string)}
+
+		for _, label := range fields[1] {
+			labelFields := decodeFields(t, label)
+			name, value := string(labelFields[1][0]), string(labelFields[2][0])
+			s.labels[name] = value
+			s.labelList = append(s.labelList, name)
+		}
+
+		if len(fields[2]) != 1 {
+			t.Fatalf("expected one sample, got %d", len(fields[2]))
+		}
+		sampleFields := decodeFields(t, fields[2][0])
+		bits, _ := protowire.ConsumeFixed64(sampleFields[1][0])
+		s.value = math.Float64frombits(bits)
+		timestamp, _ := protowire.ConsumeVarint(sampleFields[2][0])
+		s.timestamp = int64(timestamp)
+
+		series = append(series, s)
+	}
+
+	return series
+}
+
+func newTestWriter(url string) *RemoteWriter {
+	return &RemoteWriter{url: url, client: &http.Client{Timeout: time.Second}}
+}
+
+func TestRemoteWriteSeries(t *testing.T) {
+	receiver := &receiver{t: t}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	timestamp := time.UnixMilli(1700000000123)
+	newTestWriter(server.URL).send([]sample{
+		{
+			name:      "request_total",
+			labels:    map[string]string{"service": "svc", "namespace": "ns", "hostname": "host1"},
+			value:     3,
+			timestamp: timestamp,
+		},
+		{
+			name:      "latency",
+			labels:    map[string]string{"service": "svc", "namespace": "ns", "hostname": "host2"},
+			value:     0.25,
+			timestamp: timestamp,
+		},
+	})
+
+	requests, series := receiver.received()
+	if requests != 1 || len(series) != 2 {
+		t.Fatalf("expected 1 request of 2 series, got %d requests of %d series", requests, len(series))
+	}
+
+	tests := []struct {
+		name     string
+		hostname string
+		value    float64
+	}{
+		{"request_total", "host1", 3},
+		{"latency", "host2", 0.25},
+	}
+	for i, test := range tests {
+		s := series[i]
+		if s.labels["__name__"] != test.name || s.labels["hostname"] != test.hostname || s.labels["namespace"] != "ns" {
+			t.Errorf("series %d: unexpected labels %v", i, s.labels)
+		}
+		if s.value != test.value || s.timestamp != timestamp.UnixMilli() {
+			t.Errorf("series %d: expected %v at %d, got %v at %d", i, test.value, timestamp.UnixMilli(), s.value, s.timestamp)
+		}
+
+		expected := []string{"__name__", "hostname", "namespace", "service"}
+		if len(s.labelList) != len(expected) {
+			t.Fatalf("series %d: expected labels %v, got %v", i, expected, s.labelList)
+		}
+		for j := range expected {
+			if s.labelList[j] != expected[j] {
+				t.Errorf("series %d: expected labels %v in order, got %v", i, expected, s.labelList)
+			}
+		}
+	}
+}
+
+func TestRemoteWriteRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		received bool
+	}{
+		{"success", nil, 1, true},
+		{"5xx retried", []int{http.StatusInternalServerError, http.StatusServiceUnavailable}, 3, true},
+		{"429 retried", []int{http.StatusTooManyRequests}, 2, true},
+		{"4xx dropped", []int{http.StatusBadRequest}, 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := &receiver{t: t, statuses: test.statuses}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			start := time.Now()
+			newTestWriter(server.URL).send([]sample{{name: "up", labels: map[string]string{}, value: 1, timestamp: time.Now()}})
+
+			requests, series := receiver.received()
+			if requests != test.requests {
+				t.Errorf("expected %d requests, got %d", test.requests, requests)
+			}
+			if (len(series) == 1) != test.received {
+				t.Errorf("expected received %v, got %d series", test.received, len(series))
+			}
+
+			// the backoff doubles from its minimum between the attempts
+			minWait := time.Duration(0)
+			for i, backoff := 0, remoteWriteMinBackoff; i < len(test.statuses) && test.received; i, backoff = i+1, backoff*2 {
+				minWait += backoff
+			}
+			if elapsed := time.Since(start); elapsed < minWait {
+				t.Errorf("expected a backoff of at least %v, took %v", minWait, elapsed)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteSnapshot(t *testing.T) {
+	receiver := &receiver{t: t}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "remote_write_test_gauge", Help: "test"}, []string{"namespace"})
+	prom.MustRegister(gauge)
+	defer prom.Registerer().Unregister(gauge)
+	gauge.WithLabelValues("ns").Set(42)
+
+	writer := startRemoteWriter(server.URL, false, time.Hour, time.Second)
+	writer.Close()
+
+	_, series := receiver.received()
+	for _, s := range series {
+		if s.labels["__name__"] == "remote_write_test_gauge" {
+			if s.labels["namespace"] != "ns" || s.value != 42 {
+				t.Errorf("unexpected series %v = %v", s.labels, s.value)
+			}
+			return
+		}
+	}
+	t.Errorf("remote_write_test_gauge not received in %d series", len(series))
+}
+
+func TestRemoteWriteStop(t *testing.T) {
+	statuses := make([]int, remoteWriteMaxRetries+1)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	receiver := &receiver{t: t, statuses: statuses}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	writer := newTestWriter(server.URL)
+	writer.stop = make(chan struct{})
+
+	sent := make(chan struct{})
+	go func() {
+		writer.send([]sample{{name: "up", labels: map[string]string{}, value: 1, timestamp: time.Now()}})
+		close(sent)
+	}()
+
+	for requests, _ := receiver.received(); requests < 3; requests, _ = receiver.received() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(writer.stop)
+
+	// the backoff is 400ms before the fourth attempt, and the retries would last for minutes
+	select {
+	case <-sent:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatalf("the retries did not stop")
+	}
+	if requests, _ := receiver.received(); requests > 4 {
+		t.Errorf("expected at most a last attempt once stopping, got %d requests", requests)
+	}
+}
+
+// the event values are not named like the cumulative series of the registry
+func TestEventSamples(t *testing.T) {
+	timestamp := time.UnixMilli(1700000000123)
+	labels := map[string]string{"namespace": "ns", "hostname": "host"}
+
+	samples := eventSamples(labels, map[string]any{"request_total": 1, "latency": 0.5, "status": "ok"}, timestamp)
+	sort.Slice(samples, func(i, j int) bool { return samples[i].name < samples[j].name })
+
+	expected := []sample{
+		{name: "latency_event", labels: labels, value: 0.5, timestamp: timestamp},
+		{name: "request_total_event", labels: labels, value: 1, timestamp: timestamp},
+	}
+	if len(samples) != len(expected) {
+		t.Fatalf("expected %d samples, got %v", len(expected), samples)
+	}
+	for i := range expected {
+		if samples[i].name != expected[i].name || samples[i].value != expected[i].value || !samples[i].timestamp.Equal(timestamp) {
+			t.Errorf("expected %v, got %v", expected[i], samples[i])
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package output
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/flow"
+)
+
+type sample struct {
+	name      string
+	labels    map[string]string
+	value     float64
+	timestamp time.Time
+}
+
+func toFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func namespaceLabels(namespace *flow.Namespace, hostname string) map[string]string {
+	return map[string]string{
+		"service":   namespace.Service,
+		"group":     namespace.Group,
+		"namespace": namespace.Name,
+		"hostname":  hostname,
+	}
+}
+
+// suffix of the event samples, which are the values of single events and not the
+// cumulative series of the registry exposed under the metric names
+const eventSampleSuffix = "_event"
+
+// eventSamples turns the numeric metrics of an event into samples at the event time
+func eventSamples(labels map[string]string, metrics map[string]any, timestamp time.Time) []sample {
+	samples := make([]sample, 0, len(metrics))
+	for name, value := range metrics {
+		metricValue, ok := toFloat64(value)
+		if !ok {
+			logrus.Tracef("eventSamples skipping non numeric metric %s: %v", name, value)
+			continue
+		}
+
+		samples = append(samples, sample{
+			name:      name + eventSampleSuffix,
+			labels:    labels,
+			value:     metricValue,
+			timestamp: timestamp,
+		})
+	}
+
+	return samples
+}
+
+// gatherSamples flattens the registry into samples, histograms and summaries
+// follow the exposition format (_bucket, _sum, _count)
+func gatherSamples(gatherer prometheus.Gatherer, now time.Time) []sample {
+	families, err := gatherer.Gather()
+	if err != nil {
+		logrus.Errorf("gatherSamples gather: %+v", err)
+	}
+
+	samples := make([]sample, 0, len(families))
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.Metric {
+			labels := make(map[string]string, len(metric.Label))
+			for _, label := range metric.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			add := func(suffix string, value float64, extra ...string) {
+				sampleLabels := labels
+				if len(extra) > 0 {
+					sampleLabels = make(map[string]string, len(labels)+1)
+					for k, v := range labels {
+						sampleLabels[k] = v
+					}
+					sampleLabels[extra[0]] = extra[1]
+				}
+
+				samples = append(samples, sample{
+					name:      name + suffix,
+					labels:    sampleLabels,
+					value:     value,
+					timestamp: now,
+				})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", metric.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				for _, bucket := range histogram.GetBucket() {
+					add("_bucket", float64(bucket.GetCumulativeCount()), "le", formatFloat(bucket.GetUpperBound()))
+				}
+				add("_bucket", float64(histogram.GetSampleCount()), "le", "+Inf")
+				add("_sum", histogram.GetSampleSum())
+				add("_count", float64(histogram.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add("", quantile.GetValue(), "quantile", formatFloat(quantile.GetQuantile()))
+				}
+				add("_sum", summary.GetSampleSum())
+				add("_count", float64(summary.GetSampleCount()))
+			}
+		}
+	}
+
+	return samples
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+
+	return fmt.Sprint(f)
+}
+
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
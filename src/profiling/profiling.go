@@ -0,0 +1,237 @@
+package profiling
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/admin"
+)
+
+const (
+	defaultSeconds = 30
+	maxSeconds     = 300
+
+	fileTimeFormat = "20060102T150405"
+)
+
+// profiles written by the continuous profiling, besides the cpu profile
+var snapshotProfiles = []string{"heap", "goroutine"}
+
+// one cpu profile or trace at a time, the runtime only supports one
+var exclusive sync.Mutex
+
+var errBusy = errors.New("a cpu profile or a trace is already running")
+
+/*
+ * Serve exposes the pprof endpoints on their own port, for the users of the
+ * admin tokens:
+ *   /debug/pprof/                 the available profiles
+ *   /debug/pprof/profile?seconds  cpu profile
+ *   /debug/pprof/trace?seconds    execution trace
+ *   /debug/pprof/{name}?debug&gc  heap, goroutine, mutex, block, allocs, threadcreate
+ */
+
+func Serve(port uint, tokens admin.Tokens) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/{$}", tokens.Authenticate(indexHandler))
+	mux.HandleFunc("GET /debug/pprof/profile", tokens.Authenticate(cpuHandler))
+	mux.HandleFunc("GET /debug/pprof/trace", tokens.Authenticate(traceHandler))
+	mux.HandleFunc("GET /debug/pprof/{name}", tokens.Authenticate(profileHandler))
+
+	logrus.Infof("exposing profiling at: localhost:%d/debug/pprof/", port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		logrus.Panicf("error setting up profiling server: %+v", err)
+	}
+}
+
+// SetRates enables the mutex and block profiles, which stay empty at 0
+func SetRates(mutexFraction int, blockRate int) {
+	runtime.SetMutexProfileFraction(mutexFraction)
+	runtime.SetBlockProfileRate(blockRate)
+}
+
+func seconds(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("seconds")
+	if len(value) == 0 {
+		return defaultSeconds * time.Second, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 || n > maxSeconds {
+		return 0, fmt.Errorf("seconds must be in [1, %d]", maxSeconds)
+	}
+
+	return time.Duration(n) * time.Second, nil
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, err.Error())
+}
+
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "profile\ntrace")
+	for _, profile := range pprof.Profiles() {
+		fmt.Fprintf(w, "%s (%d)\n", profile.Name(), profile.Count())
+	}
+}
+
+func attachment(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+}
+
+// record runs start for the duration, or until the client leaves
+func record(w http.ResponseWriter, r *http.Request, name string, start func() error, stop func()) {
+	duration, err := seconds(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !exclusive.TryLock() {
+		httpError(w, http.StatusConflict, errBusy)
+		return
+	}
+	defer exclusive.Unlock()
+
+	attachment(w, name)
+	if err := start(); err != nil {
+		w.Header().Del("Content-Disposition")
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	logrus.Infof("profiling %s for %v by %s", name, duration, admin.User(r))
+	select {
+	case <-time.After(duration):
+	case <-r.Context().Done():
+	}
+	stop()
+}
+
+func cpuHandler(w http.ResponseWriter, r *http.Request) {
+	record(w, r, "profile", func() error { return pprof.StartCPUProfile(w) }, pprof.StopCPUProfile)
+}
+
+func traceHandler(w http.ResponseWriter, r *http.Request) {
+	record(w, r, "trace", func() error { return trace.Start(w) }, trace.Stop)
+}
+
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	profile := pprof.Lookup(r.PathValue("name"))
+	if profile == nil {
+		httpError(w, http.StatusNotFound, fmt.Errorf("unknown profile: %s", r.PathValue("name")))
+		return
+	}
+
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if r.URL.Query().Get("gc") == "1" {
+		runtime.GC()
+	}
+
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		attachment(w, profile.Name())
+	}
+
+	if err := profile.WriteTo(w, debug); err != nil {
+		logrus.Errorf("profileHandler %s: %+v", profile.Name(), err)
+	}
+}
+
+/*
+ * Continuous records a cpu profile of duration then snapshots the heap and the
+ * goroutines every interval, keeping the last keep files of each in dir
+ */
+
+func Continuous(dir string, interval time.Duration, duration time.Duration, keep int) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logrus.Errorf("Continuous mkdir %s: %+v", dir, err)
+		return
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		stamp := time.Now().UTC().Format(fileTimeFormat)
+		if err := recordCPU(filepath.Join(dir, "cpu-"+stamp+".pprof"), duration); err != nil {
+			logrus.Warnf("Continuous cpu profile: %+v", err)
+		}
+
+		for _, name := range snapshotProfiles {
+			if err := writeProfile(filepath.Join(dir, name+"-"+stamp+".pprof"), name); err != nil {
+				logrus.Errorf("Continuous %s profile: %+v", name, err)
+			}
+		}
+
+		for _, name := range append([]string{"cpu"}, snapshotProfiles...) {
+			rotate(dir, name, keep)
+		}
+
+		<-tick.C
+	}
+}
+
+func recordCPU(path string, duration time.Duration) error {
+	if !exclusive.TryLock() {
+		return errBusy
+	}
+	defer exclusive.Unlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := pprof.StartCPUProfile(f); err != nil {
+		os.Remove(path)
+		return err
+	}
+	time.Sleep(duration)
+	pprof.StopCPUProfile()
+
+	return nil
+}
+
+func writeProfile(path string, name string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return pprof.Lookup(name).WriteTo(f, 0)
+}
+
+// rotate removes the oldest files of a profile, their names sort by time
+func rotate(dir string, name string, keep int) {
+	files, err := filepath.Glob(filepath.Join(dir, name+"-*.pprof"))
+	if err != nil || len(files) <= keep {
+		return
+	}
+
+	sort.Strings(files)
+	for _, file := range files[:len(files)-keep] {
+		if err := os.Remove(file); err != nil {
+			logrus.Errorf("rotate %s: %+v", file, err)
+		}
+	}
+}
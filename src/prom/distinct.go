@@ -0,0 +1,171 @@
+package prom
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/hyperloglog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultDistinctWindow = time.Minute
+
+// a distinct count spans the hostnames of its namespace
+var distinctLabels = []string{"service", "group", "namespace"}
+
+/*
+ * DistinctVec estimates with a HyperLogLog sketch the number of distinct values
+ * seen per namespace, whatever the hostname, and exposes the estimate of the
+ * last completed window
+ */
+
+type distinctSeries struct {
+	labels      prometheus.Labels
+	sketch      *hyperloglog.Sketch
+	windowStart time.Time
+
+	// estimate of the last completed window
+	completed bool
+	last      uint64
+}
+
+type DistinctVec struct {
+	desc   *prometheus.Desc
+	window time.Duration
+
+	mu     sync.Mutex
+	series map[string]*distinctSeries
+}
+
+func NewDistinctVec(name string, help string, window time.Duration) *DistinctVec {
+	if window <= 0 {
+		window = defaultDistinctWindow
+	}
+
+	return &DistinctVec{
+		desc:   prometheus.NewDesc(name, help, distinctLabels, nil),
+		window: window,
+		series: make(map[string]*distinctSeries),
+	}
+}
+
+func (vec *DistinctVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- vec.desc
+}
+
+func (vec *DistinctVec) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+
+	for _, s := range vec.series {
+		vec.roll(s, now)
+		if !s.completed {
+			continue
+		}
+
+		values := make([]string, len(distinctLabels))
+		for i, label := range distinctLabels {
+			values[i] = s.labels[label]
+		}
+
+		ch <- prometheus.MustNewConstMetric(vec.desc, prometheus.GaugeValue, float64(s.last), values...)
+	}
+}
+
+// roll keeps the estimate of the window once it is over, 0 when a whole window passed without values
+func (vec *DistinctVec) roll(s *distinctSeries, now time.Time) {
+	end := s.windowStart.Add(vec.window)
+	if now.Before(end) {
+		return
+	}
+
+	s.last = 0
+	if now.Before(end.Add(vec.window)) {
+		s.last = s.sketch.Estimate()
+	}
+	s.completed = true
+
+	s.sketch = hyperloglog.New()
+	s.windowStart = now.Truncate(vec.window)
+}
+
+func distinctKey(labels prometheus.Labels) string {
+	values := make([]string, len(distinctLabels))
+	for i, label := range distinctLabels {
+		values[i] = labels[label]
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// distinctSeriesLabels are the labels of the series of a distinct count, without the hostname
+func distinctSeriesLabels(labels prometheus.Labels) prometheus.Labels {
+	series := make(prometheus.Labels, len(distinctLabels))
+	for _, label := range distinctLabels {
+		series[label] = labels[label]
+	}
+
+	return series
+}
+
+func (vec *DistinctVec) Insert(labels prometheus.Labels, value any) {
+	key := distinctKey(labels)
+	now := time.Now()
+
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+
+	s, exists := vec.series[key]
+	if !exists {
+		s = &distinctSeries{
+			labels:      distinctSeriesLabels(labels),
+			sketch:      hyperloglog.New(),
+			windowStart: now.Truncate(vec.window),
+		}
+		vec.series[key] = s
+	}
+	vec.roll(s, now)
+
+	s.sketch.Insert([]byte(fmt.Sprint(value)))
+}
+
+func (vec *DistinctVec) Delete(labels prometheus.Labels) bool {
+	key := distinctKey(labels)
+
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+
+	_, exists := vec.series[key]
+	delete(vec.series, key)
+	return exists
+}
+
+// DeletePartialMatch deletes the series whose labels contain the given ones
+func (vec *DistinctVec) DeletePartialMatch(labels prometheus.Labels) int {
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+
+	deleted := 0
+	for key, s := range vec.series {
+		if hasLabels(s.labels, labels) {
+			delete(vec.series, key)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+func hasLabels(labels prometheus.Labels, partial prometheus.Labels) bool {
+	for label, value := range partial {
+		if labels[label] != value {
+			return false
+		}
+	}
+
+	return true
+}
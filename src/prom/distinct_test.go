@@ -0,0 +1,57 @@
+package prom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDistinctWindows(t *testing.T) {
+	vec := NewDistinctVec("test_distinct_windows", "", time.Minute)
+	labels := func(hostname string) prometheus.Labels {
+		return prometheus.Labels{"service": "service", "group": "group", "namespace": "namespace", "hostname": hostname}
+	}
+
+	// the hostnames share the count of their namespace
+	vec.Insert(labels("a"), "user1")
+	vec.Insert(labels("b"), "user2")
+	vec.Insert(labels("b"), "user1")
+	if len(vec.series) != 1 {
+		t.Fatalf("expected one series for the namespace, got %d", len(vec.series))
+	}
+
+	if count := testutil.CollectAndCount(vec); count != 0 {
+		t.Errorf("expected no sample before the window is over, got %d", count)
+	}
+
+	var s *distinctSeries
+	for _, series := range vec.series {
+		s = series
+	}
+	start := s.windowStart
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		insert []string
+		last   uint64
+	}{
+		{"first window over", start.Add(time.Minute), []string{"user3"}, 2},
+		{"second window over", start.Add(2 * time.Minute), nil, 1},
+		{"empty window", start.Add(3 * time.Minute), nil, 0},
+		{"window passed without values", start.Add(5 * time.Minute), []string{"user4"}, 0},
+		{"values back", start.Add(6 * time.Minute), nil, 1},
+	}
+
+	for _, test := range tests {
+		vec.roll(s, test.now)
+		if !s.completed || s.last != test.last {
+			t.Errorf("%s: expected %d distinct values, got %d", test.name, test.last, s.last)
+		}
+		for _, value := range test.insert {
+			s.sketch.Insert([]byte(value))
+		}
+	}
+}
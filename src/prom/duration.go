@@ -0,0 +1,78 @@
+package prom
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * Duration is a time.Duration read from a duration string ("90s", "5m", "720h")
+ * or a number of seconds, and written as a duration string in yaml and json
+ */
+
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func parseDuration(value string) (Duration, error) {
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return Duration(time.Duration(seconds) * time.Second), nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad duration %q, expected e.g. 90s, 5m or 720h", value)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("negative duration %q", value)
+	}
+
+	return Duration(duration), nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a duration", node.Line)
+	}
+
+	duration, err := parseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+
+	*d = duration
+	return nil
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalJSON(buf []byte) error {
+	var value any
+	if err := json.Unmarshal(buf, &value); err != nil {
+		return err
+	}
+
+	duration, err := parseDuration(fmt.Sprint(value))
+	if err != nil {
+		return err
+	}
+
+	*d = duration
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
@@ -0,0 +1,63 @@
+package prom
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		yaml     string
+		json     string
+		expected time.Duration
+		valid    bool
+	}{
+		{"5m", `"5m"`, 5 * time.Minute, true},
+		{"720h", `"720h"`, 720 * time.Hour, true},
+		{"1m30s", `"1m30s"`, 90 * time.Second, true},
+		{"300", `300`, 300 * time.Second, true},
+		{"0", `0`, 0, true},
+		{"-5m", `"-5m"`, 0, false},
+		{"5 minutes", `"5 minutes"`, 0, false},
+		{"[1]", `[1]`, 0, false},
+	}
+
+	for _, test := range tests {
+		var fromYAML struct {
+			D Duration `yaml:"d"`
+		}
+		err := yaml.Unmarshal([]byte("d: "+test.yaml), &fromYAML)
+		if (err == nil) != test.valid || (test.valid && fromYAML.D.Duration() != test.expected) {
+			t.Errorf("yaml %s: expected %v (valid %v), got %v (%v)", test.yaml, test.expected, test.valid, fromYAML.D, err)
+		}
+
+		var fromJSON Duration
+		err = json.Unmarshal([]byte(test.json), &fromJSON)
+		if (err == nil) != test.valid || (test.valid && fromJSON.Duration() != test.expected) {
+			t.Errorf("json %s: expected %v (valid %v), got %v (%v)", test.json, test.expected, test.valid, fromJSON, err)
+		}
+	}
+}
+
+func TestDurationMarshal(t *testing.T) {
+	metric := Metric{Name: "m", Type: "distinct", Window: Duration(5 * time.Minute), TTL: Duration(time.Hour)}
+
+	buf, err := json.Marshal(&metric)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(buf, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["window"] != "5m0s" || fields["ttl"] != "1h0m0s" {
+		t.Errorf("expected duration strings, got %s", buf)
+	}
+	if _, exists := fields["native_min_reset_duration"]; exists {
+		t.Errorf("expected the zero duration omitted, got %s", buf)
+	}
+}
@@ -0,0 +1,104 @@
+package prom
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type errorKey struct {
+	class     string
+	namespace string
+}
+
+type errorCount struct {
+	count   int
+	example string
+}
+
+var (
+	errorsMu      sync.Mutex
+	errorInterval time.Duration // 0 logs every error
+	pendingErrors = make(map[errorKey]*errorCount)
+)
+
+/*
+ * ReportError counts an error of the message processing under its class, a
+ * short stable name like no_hostname, and the namespace it happened in (empty
+ * when unknown). Once SetupErrorReports is called, the errors of a class and
+ * namespace are logged once per interval with their count and a first example,
+ * a broken filter logging once instead of for every message
+ */
+
+func ReportError(class string, namespace string, format string, args ...any) {
+	MyBasePromMetrics.IncProcessingErrors(class, namespace)
+
+	errorsMu.Lock()
+	defer errorsMu.Unlock()
+
+	if errorInterval == 0 {
+		logrus.WithFields(logrus.Fields{"class": class, "namespace": namespace}).Errorf(format, args...)
+		return
+	}
+
+	key := errorKey{class: class, namespace: namespace}
+	pending, exists := pendingErrors[key]
+	if !exists {
+		// only the example is formatted, the next ones are counted
+		pending = &errorCount{example: fmt.Sprintf(format, args...)}
+		pendingErrors[key] = pending
+	}
+	pending.count++
+}
+
+// SetupErrorReports starts logging the reported errors every interval, 0 logs each of them
+func SetupErrorReports(interval time.Duration) {
+	errorsMu.Lock()
+	errorInterval = interval
+	errorsMu.Unlock()
+
+	if interval > 0 {
+		go errorReportsLoop(interval)
+	}
+}
+
+func errorReportsLoop(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for range tick.C {
+		FlushErrorReports()
+	}
+}
+
+// FlushErrorReports logs the errors counted since the last flush
+func FlushErrorReports() {
+	errorsMu.Lock()
+	pending := pendingErrors
+	pendingErrors = make(map[errorKey]*errorCount)
+	interval := errorInterval
+	errorsMu.Unlock()
+
+	keys := make([]errorKey, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].namespace != keys[j].namespace {
+			return keys[i].namespace < keys[j].namespace
+		}
+		return keys[i].class < keys[j].class
+	})
+
+	for _, key := range keys {
+		errors := pending[key]
+		logrus.WithFields(logrus.Fields{
+			"class":     key.class,
+			"namespace": key.namespace,
+			"count":     errors.count,
+		}).Errorf("%s (%d times in the last %v)", errors.example, errors.count, interval)
+	}
+}
@@ -0,0 +1,171 @@
+package prom
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const expiryInterval = 30 * time.Second
+
+// ttl used by the metrics that do not configure their own, 0 disables expiry
+var globalSeriesTTL time.Duration
+
+type deleter interface {
+	Delete(labels prometheus.Labels) bool
+}
+
+// seriesOwner frees the place of a deleted series in its limits
+type seriesOwner interface {
+	forgetSeries(labels prometheus.Labels)
+}
+
+type series struct {
+	owner      seriesOwner
+	labels     prometheus.Labels
+	ttl        time.Duration
+	lastUpdate time.Time
+}
+
+/*
+ * seriesTracker keeps the last update time of every label set of a metric vector
+ */
+
+type seriesTracker struct {
+	name string
+	vec  deleter
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newSeriesTracker(name string, vec deleter) *seriesTracker {
+	return &seriesTracker{
+		name:   name,
+		vec:    vec,
+		series: make(map[string]*series),
+	}
+}
+
+func seriesKey(labels prometheus.Labels) string {
+	values := make([]string, len(metricLabels))
+	for i, label := range metricLabels {
+		values[i] = labels[label]
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+func (tracker *seriesTracker) touch(owner seriesOwner, labels prometheus.Labels, ttl time.Duration) {
+	key := seriesKey(labels)
+	now := time.Now()
+
+	tracker.mu.Lock()
+	s, exists := tracker.series[key]
+	if !exists {
+		s = &series{owner: owner, labels: labels}
+		tracker.series[key] = s
+	}
+	s.ttl = ttl
+	s.lastUpdate = now
+	tracker.mu.Unlock()
+}
+
+// forget drops the series of an owner whose series were deleted with it
+func (tracker *seriesTracker) forget(owner seriesOwner) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	for key, s := range tracker.series {
+		if s.owner == owner {
+			delete(tracker.series, key)
+		}
+	}
+}
+
+func (tracker *seriesTracker) expire(now time.Time) int {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	expired := 0
+	for key, s := range tracker.series {
+		if now.Sub(s.lastUpdate) <= s.ttl {
+			continue
+		}
+
+		tracker.vec.Delete(s.labels)
+		s.owner.forgetSeries(s.labels)
+		delete(tracker.series, key)
+		expired++
+	}
+
+	return expired
+}
+
+func (metric *Metric) seriesTTL() time.Duration {
+	if metric.TTL > 0 {
+		return metric.TTL.Duration()
+	}
+
+	return globalSeriesTTL
+}
+
+func (metric *Metric) touchSeries(labels prometheus.Labels) {
+	ttl := metric.seriesTTL()
+	if ttl <= 0 {
+		return
+	}
+
+	metric.series.touch(metric, labels, ttl)
+}
+
+func (promMetrics *PromMetrics) trackerFor(name string, vec deleter) *seriesTracker {
+	promMetrics.seriesMu.Lock()
+	defer promMetrics.seriesMu.Unlock()
+
+	tracker, exists := promMetrics.series[name]
+	if !exists {
+		tracker = newSeriesTracker(name, vec)
+		promMetrics.series[name] = tracker
+	}
+
+	return tracker
+}
+
+func (promMetrics *PromMetrics) expireSeries(now time.Time) {
+	promMetrics.seriesMu.Lock()
+	trackers := make([]*seriesTracker, 0, len(promMetrics.series))
+	for _, tracker := range promMetrics.series {
+		trackers = append(trackers, tracker)
+	}
+	promMetrics.seriesMu.Unlock()
+
+	for _, tracker := range trackers {
+		expired := tracker.expire(now)
+		if expired == 0 {
+			continue
+		}
+
+		logrus.Debugf("expired %d series of %v metric", expired, tracker.name)
+		MyBasePromMetrics.AddExpiredSeries(tracker.name, expired)
+	}
+}
+
+// SetupSeriesExpiry starts deleting the series that were not updated within their ttl.
+// ttl is used by the metrics without their own ttl, 0 only expires those that have one.
+func SetupSeriesExpiry(ttl time.Duration) {
+	globalSeriesTTL = ttl
+	go expireSeriesLoop()
+}
+
+func expireSeriesLoop() {
+	tick := time.NewTicker(expiryInterval)
+	defer tick.Stop()
+
+	for now := range tick.C {
+		MyPromMetrics.expireSeries(now)
+	}
+}
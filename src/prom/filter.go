@@ -0,0 +1,199 @@
+package prom
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+// the scoped scrapes within this duration share one gathering of the registry
+const scrapeCacheTTL = time.Second
+
+// labels that can scope a /metrics/{label}/{value} endpoint
+var scopeLabels = map[string]bool{
+	"service":   true,
+	"group":     true,
+	"namespace": true,
+}
+
+type scopeKey struct {
+	label string
+	value string
+}
+
+/*
+ * scrapeIndex gathers the registry at most once per scrapeCacheTTL for all the
+ * scoped scrapes, and indexes the series by service, group and namespace. The
+ * gathered families are shared, the scrapes filter them into new ones.
+ */
+
+type scrapeIndex struct {
+	gatherer prometheus.Gatherer
+
+	mu       sync.Mutex
+	gathered time.Time
+	families []*dto.MetricFamily
+	scopes   map[scopeKey][]*dto.MetricFamily
+	err      error
+}
+
+var scopedScrapes = &scrapeIndex{gatherer: reg}
+
+// get gathers the registry when the last gathering is too old, one scrape at a time
+func (index *scrapeIndex) get(now time.Time) ([]*dto.MetricFamily, map[scopeKey][]*dto.MetricFamily, error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if now.Sub(index.gathered) >= scrapeCacheTTL {
+		index.families, index.err = index.gatherer.Gather()
+		index.scopes = indexScopes(index.families)
+		index.gathered = now
+	}
+
+	return index.families, index.scopes, index.err
+}
+
+func indexScopes(families []*dto.MetricFamily) map[scopeKey][]*dto.MetricFamily {
+	scopes := make(map[scopeKey][]*dto.MetricFamily)
+
+	for _, family := range families {
+		scoped := make(map[scopeKey]*dto.MetricFamily)
+		for _, metric := range family.Metric {
+			for _, label := range metric.Label {
+				if !scopeLabels[label.GetName()] {
+					continue
+				}
+
+				key := scopeKey{label: label.GetName(), value: label.GetValue()}
+				scopedFamily, exists := scoped[key]
+				if !exists {
+					scopedFamily = withMetrics(family, nil)
+					scoped[key] = scopedFamily
+					scopes[key] = append(scopes[key], scopedFamily)
+				}
+				scopedFamily.Metric = append(scopedFamily.Metric, metric)
+			}
+		}
+	}
+
+	return scopes
+}
+
+func withMetrics(family *dto.MetricFamily, metrics []*dto.Metric) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name:   family.Name,
+		Help:   family.Help,
+		Type:   family.Type,
+		Unit:   family.Unit,
+		Metric: metrics,
+	}
+}
+
+// filter only keeps the metrics whose labels match all the matchers, a matcher
+// matches when the label has one of its values
+func filter(families []*dto.MetricFamily, matchers map[string][]string) []*dto.MetricFamily {
+	filtered := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		metrics := make([]*dto.Metric, 0, len(family.Metric))
+		for _, metric := range family.Metric {
+			if matches(metric, matchers) {
+				metrics = append(metrics, metric)
+			}
+		}
+
+		if len(metrics) == 0 {
+			continue
+		}
+
+		filtered = append(filtered, withMetrics(family, metrics))
+	}
+
+	return filtered
+}
+
+func matches(metric *dto.Metric, matchers map[string][]string) bool {
+	for name, values := range matchers {
+		matched := false
+		for _, label := range metric.Label {
+			if label.GetName() != name {
+				continue
+			}
+
+			for _, value := range values {
+				if label.GetValue() == value {
+					matched = true
+					break
+				}
+			}
+			break
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchersFromQuery(query url.Values) map[string][]string {
+	matchers := make(map[string][]string, len(query))
+	for name, values := range query {
+		matchers[name] = values
+	}
+
+	return matchers
+}
+
+func (index *scrapeIndex) serve(w http.ResponseWriter, r *http.Request, matchers map[string][]string) {
+	families, scopes, err := index.get(time.Now())
+	if err != nil {
+		logrus.Errorf("scrapeIndex.serve: %+v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the series of a single service, group or namespace are already indexed
+	for label, values := range matchers {
+		if scopeLabels[label] && len(values) == 1 {
+			families = scopes[scopeKey{label: label, value: values[0]}]
+			break
+		}
+	}
+
+	format := expfmt.Negotiate(r.Header)
+	w.Header().Set("Content-Type", string(format))
+
+	encoder := expfmt.NewEncoder(w, format)
+	for _, family := range filter(families, matchers) {
+		if err := encoder.Encode(family); err != nil {
+			logrus.Errorf("scrapeIndex.serve: %+v", err)
+			return
+		}
+	}
+}
+
+// filterHandler serves the metrics matching the query params (label=value, repeated labels are or'ed)
+func filterHandler(w http.ResponseWriter, r *http.Request) {
+	scopedScrapes.serve(w, r, matchersFromQuery(r.URL.Query()))
+}
+
+// scopeHandler serves the metrics of a service, group or namespace, narrowed by the query params
+func scopeHandler(w http.ResponseWriter, r *http.Request) {
+	label := r.PathValue("label")
+	if !scopeLabels[label] {
+		http.Error(w, "unknown scope: "+label, http.StatusNotFound)
+		return
+	}
+
+	matchers := matchersFromQuery(r.URL.Query())
+	matchers[label] = []string{r.PathValue("value")}
+
+	scopedScrapes.serve(w, r, matchers)
+}
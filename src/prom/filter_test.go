@@ -0,0 +1,96 @@
+package prom
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScopedScrape(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	events := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "events"}, metricLabels)
+	uptime := prometheus.NewGauge(prometheus.GaugeOpts{Name: "uptime"})
+	registry.MustRegister(events, uptime)
+
+	events.WithLabelValues("billing", "web", "orders", "a").Add(1)
+	events.WithLabelValues("billing", "web", "orders", "b").Add(2)
+	events.WithLabelValues("billing", "web", "payments", "a").Add(3)
+	events.WithLabelValues("search", "api", "queries", "a").Add(4)
+
+	index := &scrapeIndex{gatherer: registry}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics/filter", func(w http.ResponseWriter, r *http.Request) {
+		index.serve(w, r, matchersFromQuery(r.URL.Query()))
+	})
+	mux.HandleFunc("/metrics/{label}/{value}", func(w http.ResponseWriter, r *http.Request) {
+		matchers := matchersFromQuery(r.URL.Query())
+		matchers[r.PathValue("label")] = []string{r.PathValue("value")}
+		index.serve(w, r, matchers)
+	})
+
+	tests := []struct {
+		path   string
+		values []string // of the events series
+	}{
+		{"/metrics/service/billing", []string{"1", "2", "3"}},
+		{"/metrics/namespace/orders", []string{"1", "2"}},
+		{"/metrics/namespace/orders?hostname=b", []string{"2"}},
+		{"/metrics/group/api", []string{"4"}},
+		{"/metrics/namespace/unknown", nil},
+		{"/metrics/filter?hostname=a", []string{"1", "3", "4"}},
+		{"/metrics/filter?namespace=orders&namespace=queries", []string{"1", "2", "4"}},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+		values := make([]string, 0)
+		for _, line := range strings.Split(recorder.Body.String(), "\n") {
+			if strings.HasPrefix(line, "uptime") {
+				t.Errorf("%s: unexpected series without the labels: %s", test.path, line)
+			}
+			if strings.HasPrefix(line, "events{") {
+				values = append(values, line[strings.LastIndex(line, " ")+1:])
+			}
+		}
+
+		sort.Strings(values)
+		if strings.Join(values, ",") != strings.Join(test.values, ",") {
+			t.Errorf("%s: expected the series %v, got %v", test.path, test.values, values)
+		}
+	}
+}
+
+func TestScrapeIndexCache(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cached"}, []string{"namespace"})
+	registry.MustRegister(gauge)
+	gauge.WithLabelValues("orders").Set(1)
+
+	index := &scrapeIndex{gatherer: registry}
+	start := time.Now()
+
+	value := func(now time.Time) float64 {
+		_, scopes, err := index.get(now)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		return scopes[scopeKey{label: "namespace", value: "orders"}][0].Metric[0].GetGauge().GetValue()
+	}
+
+	value(start)
+	gauge.WithLabelValues("orders").Set(2)
+
+	if got := value(start.Add(scrapeCacheTTL / 2)); got != 1 {
+		t.Errorf("within the cache ttl: expected 1, got %v", got)
+	}
+	if got := value(start.Add(scrapeCacheTTL)); got != 2 {
+		t.Errorf("after the cache ttl: expected 2, got %v", got)
+	}
+}
@@ -0,0 +1,132 @@
+package prom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func (metric *Metric) gaugeUpdate() func(interface{}, prometheus.Labels) {
+	switch metric.Operation {
+	case "", "set":
+		return metric.updateGauge
+	case "inc":
+		return metric.updateGaugeInc
+	case "dec":
+		return metric.updateGaugeDec
+	case "add":
+		return metric.updateGaugeAdd
+	case "set_max":
+		return metric.updateGaugeSetMax
+	case "set_min":
+		return metric.updateGaugeSetMin
+	case "set_to_current_time":
+		return metric.updateGaugeSetToCurrentTime
+	default:
+		logrus.Panicf("unsupported gauge operation: %s", metric.Operation)
+		return nil
+	}
+}
+
+func (metric *Metric) gauge(extraLabels prometheus.Labels) (prometheus.Gauge, prometheus.Labels) {
+	extraLabels = metric.limitLabels(extraLabels)
+	return metric.PromMetric.(*prometheus.GaugeVec).With(extraLabels), extraLabels
+}
+
+func (metric *Metric) updateGaugeInc(value interface{}, extraLabels prometheus.Labels) {
+	gauge, extraLabels := metric.gauge(extraLabels)
+	gauge.Inc()
+	metric.touchSeries(extraLabels)
+}
+
+func (metric *Metric) updateGaugeDec(value interface{}, extraLabels prometheus.Labels) {
+	gauge, extraLabels := metric.gauge(extraLabels)
+	gauge.Dec()
+	metric.touchSeries(extraLabels)
+}
+
+func (metric *Metric) updateGaugeAdd(value interface{}, extraLabels prometheus.Labels) {
+	metricValue, ok := toFloat64(value)
+	if !ok {
+		ReportError("metric_type", extraLabels["namespace"], "metric %v must be a number for gauge add metric", value)
+		return
+	}
+
+	gauge, extraLabels := metric.gauge(extraLabels)
+	gauge.Add(metricValue)
+	metric.touchSeries(extraLabels)
+}
+
+func (metric *Metric) updateGaugeSetMax(value interface{}, extraLabels prometheus.Labels) {
+	metric.updateGaugeExtreme(value, extraLabels, func(current float64, new float64) bool { return new > current })
+}
+
+func (metric *Metric) updateGaugeSetMin(value interface{}, extraLabels prometheus.Labels) {
+	metric.updateGaugeExtreme(value, extraLabels, func(current float64, new float64) bool { return new < current })
+}
+
+// updateGaugeExtreme sets the gauge when the series is new or replace(current, new) holds
+func (metric *Metric) updateGaugeExtreme(value interface{}, extraLabels prometheus.Labels, replace func(float64, float64) bool) {
+	metricValue, ok := toFloat64(value)
+	if !ok {
+		ReportError("metric_type", extraLabels["namespace"], "metric %v must be a number for gauge %s metric", value, metric.Operation)
+		return
+	}
+
+	gauge, extraLabels := metric.gauge(extraLabels)
+	key := seriesKey(extraLabels)
+
+	metric.extremesMu.Lock()
+	current, exists := metric.extremes[key]
+	if !exists || replace(current, metricValue) {
+		metric.extremes[key] = metricValue
+		gauge.Set(metricValue)
+	}
+	metric.extremesMu.Unlock()
+
+	metric.touchSeries(extraLabels)
+}
+
+func (metric *Metric) forgetExtreme(labels prometheus.Labels) {
+	metric.extremesMu.Lock()
+	delete(metric.extremes, seriesKey(labels))
+	metric.extremesMu.Unlock()
+}
+
+// GaugeValue is the value of the gauge series updated with the labels, read after the update by
+// the outputs that send the result of the operations rather than the event value
+func (metric *Metric) GaugeValue(labels prometheus.Labels) (float64, bool) {
+	vec, ok := metric.PromMetric.(*prometheus.GaugeVec)
+	if !ok {
+		return 0, false
+	}
+
+	gauge, err := vec.GetMetricWith(metric.foldedLabels(labels))
+	if err != nil {
+		return 0, false
+	}
+
+	var m dto.Metric
+	if err := gauge.Write(&m); err != nil {
+		return 0, false
+	}
+
+	return m.GetGauge().GetValue(), true
+}
+
+func (metric *Metric) updateGaugeSetToCurrentTime(value interface{}, extraLabels prometheus.Labels) {
+	gauge, extraLabels := metric.gauge(extraLabels)
+	gauge.SetToCurrentTime()
+	metric.touchSeries(extraLabels)
+}
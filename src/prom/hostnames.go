@@ -0,0 +1,70 @@
+package prom
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+ * hostnameSeries limits the hostnames of a base gauge labeled by namespace and
+ * hostname like the namespace metrics: above the global max series of a
+ * namespace the updates are folded into the overflow hostname
+ */
+
+type hostnameSeries struct {
+	name string
+
+	mu        sync.Mutex
+	hostnames map[string]map[string]struct{} // by namespace
+}
+
+func newHostnameSeries(name string) *hostnameSeries {
+	return &hostnameSeries{
+		name:      name,
+		hostnames: make(map[string]map[string]struct{}),
+	}
+}
+
+func (h *hostnameSeries) limitLabels(labels prometheus.Labels) prometheus.Labels {
+	if globalMaxSeries <= 0 {
+		return labels
+	}
+
+	namespace, hostname := labels["namespace"], labels["hostname"]
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hostnames, exists := h.hostnames[namespace]
+	if !exists {
+		hostnames = make(map[string]struct{})
+		h.hostnames[namespace] = hostnames
+	}
+
+	if _, exists := hostnames[hostname]; exists {
+		return labels
+	}
+
+	if len(hostnames) >= globalMaxSeries {
+		MyBasePromMetrics.IncOverflowUpdates(namespace, h.name)
+		labels["hostname"] = OverflowHostname
+		return labels
+	}
+
+	hostnames[hostname] = struct{}{}
+	return labels
+}
+
+// forgetSeries frees the place of an expired hostname
+func (h *hostnameSeries) forgetSeries(labels prometheus.Labels) {
+	namespace := labels["namespace"]
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.hostnames[namespace], labels["hostname"])
+	if len(h.hostnames[namespace]) == 0 {
+		delete(h.hostnames, namespace)
+	}
+}
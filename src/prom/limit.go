@@ -0,0 +1,138 @@
+package prom
+
+import (
+	"maps"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hostname of the series where updates above the cardinality limits are folded
+const OverflowHostname = "__overflow__"
+
+// max series used by the metrics that do not configure their own, 0 is unlimited
+var globalMaxSeries int
+
+/*
+ * SeriesLimit caps the number of series shared by all the metrics of a namespace
+ */
+
+type SeriesLimit struct {
+	max int
+
+	mu    sync.Mutex
+	count int
+}
+
+func NewSeriesLimit(max int) *SeriesLimit {
+	return &SeriesLimit{
+		max: max,
+	}
+}
+
+func (limit *SeriesLimit) acquire() bool {
+	if limit == nil || limit.max <= 0 {
+		return true
+	}
+
+	limit.mu.Lock()
+	defer limit.mu.Unlock()
+
+	if limit.count >= limit.max {
+		return false
+	}
+
+	limit.count++
+	return true
+}
+
+func (limit *SeriesLimit) release() {
+	if limit == nil || limit.max <= 0 {
+		return
+	}
+
+	limit.mu.Lock()
+	limit.count--
+	limit.mu.Unlock()
+}
+
+func (metric *Metric) maxSeries() int {
+	if metric.MaxSeries > 0 {
+		return metric.MaxSeries
+	}
+
+	return globalMaxSeries
+}
+
+func (metric *Metric) isLimited() bool {
+	return metric.maxSeries() > 0 || (metric.namespaceLimit != nil && metric.namespaceLimit.max > 0)
+}
+
+// limitLabels folds the labels of a new series into the overflow series once
+// the metric or namespace limit is reached
+func (metric *Metric) limitLabels(labels prometheus.Labels) prometheus.Labels {
+	if !metric.isLimited() {
+		return labels
+	}
+
+	hostname := labels["hostname"]
+
+	metric.hostnamesMu.Lock()
+	defer metric.hostnamesMu.Unlock()
+
+	if _, exists := metric.hostnames[hostname]; exists {
+		return labels
+	}
+
+	max := metric.maxSeries()
+	if (max > 0 && len(metric.hostnames) >= max) || !metric.namespaceLimit.acquire() {
+		MyBasePromMetrics.IncOverflowUpdates(labels["namespace"], metric.Name)
+		labels["hostname"] = OverflowHostname
+		return labels
+	}
+
+	metric.hostnames[hostname] = struct{}{}
+	return labels
+}
+
+// foldedLabels are the labels limitLabels gave to the series, without taking a place in the limits
+func (metric *Metric) foldedLabels(labels prometheus.Labels) prometheus.Labels {
+	if !metric.isLimited() {
+		return labels
+	}
+
+	metric.hostnamesMu.Lock()
+	_, exists := metric.hostnames[labels["hostname"]]
+	metric.hostnamesMu.Unlock()
+
+	if exists {
+		return labels
+	}
+
+	folded := maps.Clone(labels)
+	folded["hostname"] = OverflowHostname
+	return folded
+}
+
+// forgetSeries frees the place of an expired series in the limits
+func (metric *Metric) forgetSeries(labels prometheus.Labels) {
+	metric.forgetExtreme(labels)
+	metric.forgetState(labels)
+
+	hostname := labels["hostname"]
+
+	metric.hostnamesMu.Lock()
+	defer metric.hostnamesMu.Unlock()
+
+	if _, exists := metric.hostnames[hostname]; !exists {
+		return
+	}
+
+	delete(metric.hostnames, hostname)
+	metric.namespaceLimit.release()
+}
+
+// SetupSeriesLimits sets the max series of the metrics that do not configure their own, 0 is unlimited.
+func SetupSeriesLimits(max int) {
+	globalMaxSeries = max
+}
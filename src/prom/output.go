@@ -1,6 +1,10 @@
 package prom
 
 import (
+	"fmt"
+	"slices"
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
@@ -10,6 +14,16 @@ type PromMetrics struct {
 	GaugeMetrics     map[string]*prometheus.GaugeVec
 	HistogramMetrics map[string]*prometheus.HistogramVec
 	SummaryMetrics   map[string]*prometheus.SummaryVec
+	DistinctMetrics  map[string]*DistinctVec
+	StateSetMetrics  map[string]*prometheus.GaugeVec
+
+	// guards the metric maps, namespaces can be added at runtime
+	metricsMu sync.Mutex
+	// the configuration each vector was created with, the others reuse its options
+	options map[string]*Metric
+
+	seriesMu sync.Mutex
+	series   map[string]*seriesTracker
 }
 
 var MyPromMetrics = &PromMetrics{
@@ -17,20 +31,169 @@ var MyPromMetrics = &PromMetrics{
 	GaugeMetrics:     make(map[string]*prometheus.GaugeVec),
 	HistogramMetrics: make(map[string]*prometheus.HistogramVec),
 	SummaryMetrics:   make(map[string]*prometheus.SummaryVec),
+	DistinctMetrics:  make(map[string]*DistinctVec),
+	StateSetMetrics:  make(map[string]*prometheus.GaugeVec),
+	options:          make(map[string]*Metric),
+	series:           make(map[string]*seriesTracker),
 }
 
+var metricLabels = []string{"service", "group", "namespace", "hostname"}
+
 type Metric struct {
-	Name    string
-	Help    string    `yaml:"help"`
-	Type    string    `yaml:"type"`
-	Buckets []float64 `yaml:"buckets"`
+	Name    string    `json:"name"`
+	Help    string    `json:"help" yaml:"help"`
+	Type    string    `json:"type" yaml:"type"`
+	Buckets []float64 `json:"buckets,omitempty" yaml:"buckets"`
+
+	// Native (sparse) histogram options, only used by histogram metrics.
+	// A bucket factor greater than 1 enables the native histogram, without
+	// buckets only the native histogram is exposed.
+	NativeBucketFactor     float64  `json:"native_bucket_factor,omitempty" yaml:"native_bucket_factor"`
+	NativeMaxBucketNumber  uint32   `json:"native_max_bucket_number,omitempty" yaml:"native_max_bucket_number"`
+	NativeMinResetDuration Duration `json:"native_min_reset_duration,omitempty" yaml:"native_min_reset_duration"`
+
+	// Gauge operation: set (default), inc, dec, add, set_max, set_min or set_to_current_time
+	Operation string `json:"operation,omitempty" yaml:"operation"`
+
+	// Window of the distinct count metrics
+	Window Duration `json:"window,omitempty" yaml:"window"`
+
+	// Known states of the state set metrics, without them only the current state is exposed
+	States []string `json:"states,omitempty" yaml:"states"`
+
+	// Series not updated for longer than TTL are deleted, defaults to the global ttl
+	TTL Duration `json:"ttl,omitempty" yaml:"ttl"`
+
+	// New series above MaxSeries are folded into the overflow series, defaults to the global max
+	MaxSeries int `json:"max_series,omitempty" yaml:"max_series"`
+
+	PromMetric prometheus.Collector                 `json:"-"`
+	Update     func(interface{}, prometheus.Labels) `json:"-"`
+
+	series         *seriesTracker
+	namespaceLimit *SeriesLimit
+	hostnamesMu    sync.Mutex
+	hostnames      map[string]struct{}
+	extremesMu     sync.Mutex
+	extremes       map[string]float64
+	statesMu       sync.Mutex
+	states         map[string]string
+}
 
-	PromMetric prometheus.Collector
-	Update     func(interface{}, prometheus.Labels)
+// partialMatchDeleter is implemented by the metric vectors
+type partialMatchDeleter interface {
+	DeletePartialMatch(labels prometheus.Labels) int
 }
 
-func (metric *Metric) AddPromMetric() {
-	extraLabels := []string{"service", "group", "namespace", "hostname"}
+// partialDeleter deletes all the series of the vector matching the labels
+type partialDeleter struct {
+	vec *prometheus.GaugeVec
+}
+
+func (d partialDeleter) Delete(labels prometheus.Labels) bool {
+	return d.vec.DeletePartialMatch(labels) > 0
+}
+
+// Validate checks the type and operation of the metric, and that its name is not registered with another type
+func (metric *Metric) Validate() error {
+	switch metric.Type {
+	case "counter", "histogram", "summary", "distinct", "state_set":
+	case "gauge":
+		switch metric.Operation {
+		case "", "set", "inc", "dec", "add", "set_max", "set_min", "set_to_current_time":
+		default:
+			return fmt.Errorf("metric %s: unsupported gauge operation: %s", metric.Name, metric.Operation)
+		}
+	default:
+		return fmt.Errorf("metric %s: unsupported metric type: %s", metric.Name, metric.Type)
+	}
+
+	if registered := MyPromMetrics.registeredType(metric.Name); len(registered) > 0 && registered != metric.Type {
+		return fmt.Errorf("metric %s: already registered as a %s metric", metric.Name, registered)
+	}
+
+	return nil
+}
+
+// CheckRegistered tells if the vector of the metric name was created with other options, which the metric would reuse
+func (metric *Metric) CheckRegistered() error {
+	MyPromMetrics.metricsMu.Lock()
+	defer MyPromMetrics.metricsMu.Unlock()
+
+	return metric.conflict(MyPromMetrics.options[metric.Name])
+}
+
+func (metric *Metric) conflict(registered *Metric) error {
+	if registered == nil || registered.Type != metric.Type {
+		return nil
+	}
+
+	switch {
+	case !slices.Equal(metric.Buckets, registered.Buckets):
+		return fmt.Errorf("metric %s: already registered with buckets %v", metric.Name, registered.Buckets)
+	case metric.NativeBucketFactor != registered.NativeBucketFactor ||
+		metric.NativeMaxBucketNumber != registered.NativeMaxBucketNumber ||
+		metric.NativeMinResetDuration != registered.NativeMinResetDuration:
+		return fmt.Errorf("metric %s: already registered with native histogram factor %v, max bucket number %d and min reset duration %v",
+			metric.Name, registered.NativeBucketFactor, registered.NativeMaxBucketNumber, registered.NativeMinResetDuration)
+	case metric.Window != registered.Window:
+		return fmt.Errorf("metric %s: already registered with window %v", metric.Name, registered.Window)
+	case !slices.Equal(metric.States, registered.States):
+		return fmt.Errorf("metric %s: already registered with states %v", metric.Name, registered.States)
+	}
+
+	return nil
+}
+
+// DeleteSeries deletes the series of the metric in a namespace being deleted or replaced,
+// the vector stays registered for the other namespaces
+func (metric *Metric) DeleteSeries(namespace string) {
+	if vec, ok := metric.PromMetric.(partialMatchDeleter); ok {
+		vec.DeletePartialMatch(prometheus.Labels{"namespace": namespace})
+	}
+
+	if metric.series != nil {
+		metric.series.forget(metric)
+	}
+}
+
+func (promMetrics *PromMetrics) registeredType(name string) string {
+	promMetrics.metricsMu.Lock()
+	defer promMetrics.metricsMu.Unlock()
+
+	types := map[string]bool{
+		"counter":   promMetrics.CounterMetrics[name] != nil,
+		"gauge":     promMetrics.GaugeMetrics[name] != nil,
+		"histogram": promMetrics.HistogramMetrics[name] != nil,
+		"summary":   promMetrics.SummaryMetrics[name] != nil,
+		"distinct":  promMetrics.DistinctMetrics[name] != nil,
+		"state_set": promMetrics.StateSetMetrics[name] != nil,
+	}
+	for metricType, exists := range types {
+		if exists {
+			return metricType
+		}
+	}
+
+	return ""
+}
+
+func (metric *Metric) AddPromMetric(namespaceLimit *SeriesLimit) {
+	MyPromMetrics.metricsMu.Lock()
+	defer MyPromMetrics.metricsMu.Unlock()
+
+	metric.namespaceLimit = namespaceLimit
+	metric.hostnames = make(map[string]struct{})
+	metric.extremes = make(map[string]float64)
+	metric.states = make(map[string]string)
+
+	if registered, exists := MyPromMetrics.options[metric.Name]; !exists {
+		MyPromMetrics.options[metric.Name] = metric
+	} else if err := metric.conflict(registered); err != nil {
+		logrus.Warnf("AddPromMetric: %+v, its options are used", err)
+	}
+
+	extraLabels := metricLabels
 	switch metric.Type {
 	case "counter":
 		counter, exists := MyPromMetrics.CounterMetrics[metric.Name]
@@ -48,6 +211,7 @@ func (metric *Metric) AddPromMetric() {
 		}
 
 		metric.PromMetric = counter
+		metric.series = MyPromMetrics.trackerFor(metric.Name, counter)
 		metric.Update = metric.updateCounter
 
 	case "gauge":
@@ -66,7 +230,8 @@ func (metric *Metric) AddPromMetric() {
 		}
 
 		metric.PromMetric = gauge
-		metric.Update = metric.updateGauge
+		metric.series = MyPromMetrics.trackerFor(metric.Name, gauge)
+		metric.Update = metric.gaugeUpdate()
 
 	case "histogram":
 		histogram, exists := MyPromMetrics.HistogramMetrics[metric.Name]
@@ -76,6 +241,10 @@ func (metric *Metric) AddPromMetric() {
 					Name:    metric.Name,
 					Help:    metric.Help,
 					Buckets: metric.Buckets,
+
+					NativeHistogramBucketFactor:     metric.NativeBucketFactor,
+					NativeHistogramMaxBucketNumber:  metric.NativeMaxBucketNumber,
+					NativeHistogramMinResetDuration: metric.NativeMinResetDuration.Duration(),
 				},
 				extraLabels,
 			)
@@ -85,6 +254,7 @@ func (metric *Metric) AddPromMetric() {
 		}
 
 		metric.PromMetric = histogram
+		metric.series = MyPromMetrics.trackerFor(metric.Name, histogram)
 		metric.Update = metric.updateHistogram
 
 	case "summary":
@@ -103,8 +273,41 @@ func (metric *Metric) AddPromMetric() {
 		}
 
 		metric.PromMetric = summary
+		metric.series = MyPromMetrics.trackerFor(metric.Name, summary)
 		metric.Update = metric.updateSummary
 
+	case "distinct":
+		distinct, exists := MyPromMetrics.DistinctMetrics[metric.Name]
+		if !exists {
+			distinct = NewDistinctVec(metric.Name, metric.Help, metric.Window.Duration())
+			reg.MustRegister(distinct)
+			MyPromMetrics.DistinctMetrics[metric.Name] = distinct
+			logrus.Infof("registered %v distinct metric", metric.Name)
+		}
+
+		metric.PromMetric = distinct
+		metric.series = MyPromMetrics.trackerFor(metric.Name, distinct)
+		metric.Update = metric.updateDistinct
+
+	case "state_set":
+		stateSet, exists := MyPromMetrics.StateSetMetrics[metric.Name]
+		if !exists {
+			stateSet = prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: metric.Name,
+					Help: metric.Help,
+				},
+				append(append([]string{}, extraLabels...), metric.Name),
+			)
+			reg.MustRegister(stateSet)
+			MyPromMetrics.StateSetMetrics[metric.Name] = stateSet
+			logrus.Infof("registered %v state_set metric", metric.Name)
+		}
+
+		metric.PromMetric = stateSet
+		metric.series = MyPromMetrics.trackerFor(metric.Name, partialDeleter{vec: stateSet})
+		metric.Update = metric.updateStateSet
+
 	default:
 		logrus.Panicf("unsupported metric type: %s", metric.Type)
 	}
@@ -113,39 +316,145 @@ func (metric *Metric) AddPromMetric() {
 func (metric *Metric) updateCounter(value interface{}, extraLabels prometheus.Labels) {
 	metricValue, ok := value.(int)
 	if !ok {
-		logrus.Errorf("metric %v must be type int for counter metric", value)
+		ReportError("metric_type", extraLabels["namespace"], "metric %v must be type int for counter metric", value)
 		return
 	}
 
+	extraLabels = metric.limitLabels(extraLabels)
 	metric.PromMetric.(*prometheus.CounterVec).With(extraLabels).Add(float64(metricValue))
+	metric.touchSeries(extraLabels)
 }
 
 func (metric *Metric) updateGauge(value interface{}, extraLabels prometheus.Labels) {
-	metricValue, ok := value.(float64)
+	metricValue, ok := toFloat64(value)
 	if !ok {
-		logrus.Errorf("metric %v must be type float64 for gauge metric", metricValue)
+		ReportError("metric_type", extraLabels["namespace"], "metric %v must be a number for gauge metric", value)
 		return
 	}
 
+	extraLabels = metric.limitLabels(extraLabels)
 	metric.PromMetric.(*prometheus.GaugeVec).With(extraLabels).Set(metricValue)
+	metric.touchSeries(extraLabels)
 }
 
 func (metric *Metric) updateHistogram(value interface{}, extraLabels prometheus.Labels) {
 	metricValue, ok := value.(float64)
 	if !ok {
-		logrus.Errorf("metric %v must be type float64 for histogram metric", metricValue)
+		ReportError("metric_type", extraLabels["namespace"], "metric %v must be type float64 for histogram metric", value)
 		return
 	}
 
+	extraLabels = metric.limitLabels(extraLabels)
 	metric.PromMetric.(*prometheus.HistogramVec).With(extraLabels).Observe(metricValue)
+	metric.touchSeries(extraLabels)
 }
 
 func (metric *Metric) updateSummary(value interface{}, extraLabels prometheus.Labels) {
 	metricValue, ok := value.(float64)
 	if !ok {
-		logrus.Errorf("metric %v must be type float64 for summary metric", metricValue)
+		ReportError("metric_type", extraLabels["namespace"], "metric %v must be type float64 for summary metric", value)
 		return
 	}
 
+	extraLabels = metric.limitLabels(extraLabels)
 	metric.PromMetric.(*prometheus.SummaryVec).With(extraLabels).Observe(metricValue)
+	metric.touchSeries(extraLabels)
+}
+
+func (metric *Metric) updateDistinct(value interface{}, extraLabels prometheus.Labels) {
+	switch value.(type) {
+	case string, int, float64:
+	default:
+		ReportError("metric_type", extraLabels["namespace"], "metric %v must be type string or number for distinct metric", value)
+		return
+	}
+
+	// one series per namespace, the hostnames do not count in the limits
+	labels := distinctSeriesLabels(extraLabels)
+	metric.PromMetric.(*DistinctVec).Insert(labels, value)
+	metric.touchSeries(labels)
+}
+
+func (metric *Metric) updateStateSet(value interface{}, extraLabels prometheus.Labels) {
+	state, ok := value.(string)
+	if !ok {
+		ReportError("metric_type", extraLabels["namespace"], "metric %v must be type string for state_set metric", value)
+		return
+	}
+
+	extraLabels = metric.limitLabels(extraLabels)
+	stateSet := metric.PromMetric.(*prometheus.GaugeVec)
+	key := seriesKey(extraLabels)
+
+	// concurrent updates of the series could otherwise leave two states set
+	metric.statesMu.Lock()
+	previous, exists := metric.states[key]
+	switch {
+	case len(metric.States) > 0:
+		for _, s := range metric.States {
+			if s != state {
+				stateSet.With(stateLabels(extraLabels, metric.Name, s)).Set(0)
+			}
+		}
+	case exists && previous != state:
+		stateSet.Delete(stateLabels(extraLabels, metric.Name, previous))
+	}
+	stateSet.With(stateLabels(extraLabels, metric.Name, state)).Set(1)
+	metric.states[key] = state
+	metric.statesMu.Unlock()
+
+	metric.touchSeries(extraLabels)
+}
+
+func (metric *Metric) forgetState(labels prometheus.Labels) {
+	metric.statesMu.Lock()
+	delete(metric.states, seriesKey(labels))
+	metric.statesMu.Unlock()
+}
+
+func stateLabels(extraLabels prometheus.Labels, name string, state string) prometheus.Labels {
+	labels := make(prometheus.Labels, len(extraLabels)+1)
+	for k, v := range extraLabels {
+		labels[k] = v
+	}
+	labels[name] = state
+
+	return labels
+}
+
+// Check tells why Update would skip the value, without updating the metric
+func (metric *Metric) Check(value interface{}) error {
+	switch metric.Type {
+	case "counter":
+		if _, ok := value.(int); !ok {
+			return fmt.Errorf("metric %v must be type int for counter metric", value)
+		}
+	case "gauge":
+		switch metric.Operation {
+		case "", "set":
+			if _, ok := toFloat64(value); !ok {
+				return fmt.Errorf("metric %v must be a number for gauge metric", value)
+			}
+		case "add", "set_max", "set_min":
+			if _, ok := toFloat64(value); !ok {
+				return fmt.Errorf("metric %v must be a number for gauge %s metric", value, metric.Operation)
+			}
+		}
+	case "histogram", "summary":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("metric %v must be type float64 for %s metric", value, metric.Type)
+		}
+	case "distinct":
+		switch value.(type) {
+		case string, int, float64:
+		default:
+			return fmt.Errorf("metric %v must be type string or number for distinct metric", value)
+		}
+	case "state_set":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("metric %v must be type string for state_set metric", value)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,181 @@
+package prom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCheckRegistered(t *testing.T) {
+	registered := &Metric{Name: "test_registered_seconds", Type: "histogram", Buckets: []float64{1, 5}}
+	registered.AddPromMetric(nil)
+
+	tests := []struct {
+		name   string
+		metric *Metric
+		valid  bool
+	}{
+		{"same options", &Metric{Type: "histogram", Buckets: []float64{1, 5}}, true},
+		{"other buckets", &Metric{Type: "histogram", Buckets: []float64{1, 10}}, false},
+		{"native histogram", &Metric{Type: "histogram", Buckets: []float64{1, 5}, NativeBucketFactor: 1.1}, false},
+	}
+
+	for _, test := range tests {
+		test.metric.Name = registered.Name
+		if err := test.metric.CheckRegistered(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+
+	unknown := &Metric{Name: "test_unknown_seconds", Type: "histogram", Buckets: []float64{1, 10}}
+	if err := unknown.CheckRegistered(); err != nil {
+		t.Errorf("unknown metric: expected valid, got %v", err)
+	}
+}
+
+func TestDeleteSeries(t *testing.T) {
+	labels := func(namespace string, hostname string) prometheus.Labels {
+		return prometheus.Labels{"service": "service", "group": "group", "namespace": namespace, "hostname": hostname}
+	}
+
+	tests := []struct {
+		name       string
+		metricType string
+		value      any
+	}{
+		{"test_deleted_total", "counter", 1},
+		{"test_deleted_gauge", "gauge", 1.0},
+		{"test_deleted_distinct", "distinct", "value"},
+	}
+
+	for _, test := range tests {
+		deleted := &Metric{Name: test.name, Type: test.metricType}
+		kept := &Metric{Name: test.name, Type: test.metricType}
+		deleted.AddPromMetric(nil)
+		kept.AddPromMetric(nil)
+
+		deleted.Update(test.value, labels("deleted", "a"))
+		deleted.Update(test.value, labels("deleted", "b"))
+		kept.Update(test.value, labels("kept", "a"))
+
+		deleted.DeleteSeries("deleted")
+		count := 0
+		if distinct, ok := deleted.PromMetric.(*DistinctVec); ok {
+			// a distinct count is only collected once its window is over
+			count = len(distinct.series)
+		} else {
+			count = testutil.CollectAndCount(deleted.PromMetric)
+		}
+		if count != 1 {
+			t.Errorf("%s: expected the series of the other namespace, got %d series", test.metricType, count)
+		}
+	}
+}
+
+func TestStateSet(t *testing.T) {
+	labels := func(hostname string) prometheus.Labels {
+		return prometheus.Labels{"service": "service", "group": "group", "namespace": "namespace", "hostname": hostname}
+	}
+
+	tests := []struct {
+		name    string
+		states  []string
+		updates []string // of hostname a, b is up
+		series  int
+	}{
+		{"current state only", nil, []string{"up", "down", "down"}, 2},
+		{"known states", []string{"up", "down", "starting"}, []string{"up", "down"}, 6},
+	}
+
+	for _, test := range tests {
+		metric := &Metric{Name: "test_state_" + strings.ReplaceAll(test.name, " ", "_"), Type: "state_set", States: test.states}
+		metric.AddPromMetric(nil)
+		metric.Update("up", labels("b"))
+
+		for _, state := range test.updates {
+			metric.Update(state, labels("a"))
+		}
+
+		vec := metric.PromMetric.(*prometheus.GaugeVec)
+		if count := testutil.CollectAndCount(vec); count != test.series {
+			t.Errorf("%s: expected %d series, got %d", test.name, test.series, count)
+		}
+
+		last := test.updates[len(test.updates)-1]
+		if value := testutil.ToFloat64(vec.With(stateLabels(labels("a"), metric.Name, last))); value != 1 {
+			t.Errorf("%s: expected state %s of a set, got %v", test.name, last, value)
+		}
+		if value := testutil.ToFloat64(vec.With(stateLabels(labels("b"), metric.Name, "up"))); value != 1 {
+			t.Errorf("%s: expected state up of b kept, got %v", test.name, value)
+		}
+	}
+}
+
+func TestGaugeSet(t *testing.T) {
+	metric := &Metric{Name: "test_gauge_set", Type: "gauge"}
+	metric.AddPromMetric(nil)
+	labels := prometheus.Labels{"service": "service", "group": "group", "namespace": "namespace", "hostname": "a"}
+
+	for _, value := range []any{2.5, 3} {
+		if err := metric.Check(value); err != nil {
+			t.Errorf("Check(%v): %v", value, err)
+		}
+		metric.Update(value, labels)
+
+		expected, _ := toFloat64(value)
+		if got := testutil.ToFloat64(metric.PromMetric.(*prometheus.GaugeVec).With(labels)); got != expected {
+			t.Errorf("set %v: got %v", value, got)
+		}
+	}
+
+	if err := metric.Check("3"); err == nil {
+		t.Errorf("Check(\"3\"): expected an error")
+	}
+}
+
+func TestNativeHistogram(t *testing.T) {
+	labels := prometheus.Labels{"service": "service", "group": "group", "namespace": "namespace", "hostname": "a"}
+	native := &Metric{Name: "test_native_seconds", Type: "histogram", Buckets: []float64{1, 5}, NativeBucketFactor: 1.1}
+	classic := &Metric{Name: "test_classic_seconds", Type: "histogram", Buckets: []float64{1, 5}}
+	for _, metric := range []*Metric{native, classic} {
+		metric.AddPromMetric(nil)
+		// registered once for the repeated runs
+		metric.PromMetric.(*prometheus.HistogramVec).Reset()
+		for _, value := range []float64{0, 0.5, 2} {
+			metric.Update(value, labels)
+		}
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	histograms := make(map[string]*dto.Histogram)
+	for _, family := range families {
+		if family.GetType() == dto.MetricType_HISTOGRAM && len(family.GetMetric()) == 1 {
+			histograms[family.GetName()] = family.GetMetric()[0].GetHistogram()
+		}
+	}
+
+	// the largest schema whose bucket growth is within the factor: 2^(2^-3) ≈ 1.09
+	histogram := histograms[native.Name]
+	if histogram == nil || histogram.Schema == nil || histogram.GetSchema() != 3 {
+		t.Fatalf("expected the native histogram schema 3, got %v", histogram)
+	}
+	if histogram.GetZeroThreshold() != prometheus.DefNativeHistogramZeroThreshold || histogram.GetZeroCount() != 1 {
+		t.Errorf("expected the 0 in the zero bucket of the default threshold, got %v (%d)", histogram.GetZeroThreshold(), histogram.GetZeroCount())
+	}
+	if len(histogram.GetPositiveSpan()) == 0 || len(histogram.GetPositiveDelta()) != 2 {
+		t.Errorf("expected the positive values in native buckets, got %v %v", histogram.GetPositiveSpan(), histogram.GetPositiveDelta())
+	}
+	if len(histogram.GetBucket()) != 2 || histogram.GetSampleCount() != 3 {
+		t.Errorf("expected the classic buckets kept, got %v", histogram.GetBucket())
+	}
+
+	if histogram := histograms[classic.Name]; histogram == nil || histogram.Schema != nil || len(histogram.GetPositiveSpan()) != 0 {
+		t.Errorf("expected a classic histogram without schema, got %v", histogram)
+	}
+}
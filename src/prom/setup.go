@@ -12,18 +12,39 @@ type BasePromMetrics struct {
 	groupsGauge     prometheus.Gauge
 	namespacesGauge prometheus.Gauge
 	processedMsg    prometheus.Counter
+	readMsg         *prometheus.CounterVec
+	publishLag      *prometheus.GaugeVec
+	eventLag        *prometheus.GaugeVec
+	nackedMsg       prometheus.Counter
+	ackRetries      prometheus.Counter
+	ackFailures     prometheus.Counter
 	filteredMsg     *prometheus.CounterVec
 	filterTime      prometheus.Summary
 	pushTime        prometheus.Summary
 	processTime     prometheus.Summary
+	expiredSeries   *prometheus.CounterVec
+	overflowUpdates *prometheus.CounterVec
+	lastEventTime   *prometheus.GaugeVec
+	hostLastEvent   *prometheus.GaugeVec
+	errors          *prometheus.CounterVec
 
 	IncNumberGroups         func()
 	SetNumberNamespaces     func(n int)
-	IncProcessedMsg         func()
+	AddProcessedMsg         func(n int)
+	IncReadMsg              func(topic string)
+	SetPublishLag           func(topic string, lag time.Duration)
+	SetEventLag             func(topic string, lag time.Duration)
+	IncNackedMsg            func()
+	IncAckRetries           func()
+	IncAckFailures          func()
 	IncNamespaceFilteredMsg func(namespace string)
 	ObserveProcessingTime   func(t time.Duration)
 	ObserveFilterTime       func(t time.Duration)
 	ObservePushTime         func(t time.Duration)
+	AddExpiredSeries        func(metric string, n int)
+	IncOverflowUpdates      func(namespace string, metric string)
+	SetLastEventTime        func(namespace string, hostname string, t time.Time)
+	IncProcessingErrors     func(class string, namespace string)
 }
 
 func initBasePromMetricsHandlers(activateObserveProcessingTime bool) {
@@ -35,14 +56,64 @@ func initBasePromMetricsHandlers(activateObserveProcessingTime bool) {
 		MyBasePromMetrics.namespacesGauge.Set(float64(n))
 	}
 
-	MyBasePromMetrics.IncProcessedMsg = func() {
-		MyBasePromMetrics.processedMsg.Inc()
+	MyBasePromMetrics.AddProcessedMsg = func(n int) {
+		MyBasePromMetrics.processedMsg.Add(float64(n))
+	}
+
+	MyBasePromMetrics.IncReadMsg = func(topic string) {
+		MyBasePromMetrics.readMsg.WithLabelValues(topic).Inc()
+	}
+
+	MyBasePromMetrics.SetPublishLag = func(topic string, lag time.Duration) {
+		MyBasePromMetrics.publishLag.WithLabelValues(topic).Set(lag.Seconds())
+	}
+
+	MyBasePromMetrics.SetEventLag = func(topic string, lag time.Duration) {
+		MyBasePromMetrics.eventLag.WithLabelValues(topic).Set(lag.Seconds())
+	}
+
+	MyBasePromMetrics.IncNackedMsg = func() {
+		MyBasePromMetrics.nackedMsg.Inc()
+	}
+
+	MyBasePromMetrics.IncAckRetries = func() {
+		MyBasePromMetrics.ackRetries.Inc()
+	}
+
+	MyBasePromMetrics.IncAckFailures = func() {
+		MyBasePromMetrics.ackFailures.Inc()
 	}
 
 	MyBasePromMetrics.IncNamespaceFilteredMsg = func(namespace string) {
 		MyBasePromMetrics.filteredMsg.With(prometheus.Labels{"namespace": namespace}).Inc()
 	}
 
+	MyBasePromMetrics.AddExpiredSeries = func(metric string, n int) {
+		MyBasePromMetrics.expiredSeries.With(prometheus.Labels{"metric": metric}).Add(float64(n))
+	}
+
+	MyBasePromMetrics.IncOverflowUpdates = func(namespace string, metric string) {
+		MyBasePromMetrics.overflowUpdates.With(prometheus.Labels{"namespace": namespace, "metric": metric}).Inc()
+	}
+
+	// the hostname series expire and are limited like those of the namespace metrics
+	hostLastEventSeries := newHostnameSeries("hostname_last_event_time")
+	hostLastEventTracker := MyPromMetrics.trackerFor("hostname_last_event_time", MyBasePromMetrics.hostLastEvent)
+	MyBasePromMetrics.SetLastEventTime = func(namespace string, hostname string, t time.Time) {
+		seconds := float64(t.UnixNano()) / float64(time.Second)
+		MyBasePromMetrics.lastEventTime.With(prometheus.Labels{"namespace": namespace}).Set(seconds)
+
+		labels := hostLastEventSeries.limitLabels(prometheus.Labels{"namespace": namespace, "hostname": hostname})
+		MyBasePromMetrics.hostLastEvent.With(labels).Set(seconds)
+		if globalSeriesTTL > 0 {
+			hostLastEventTracker.touch(hostLastEventSeries, labels, globalSeriesTTL)
+		}
+	}
+
+	MyBasePromMetrics.IncProcessingErrors = func(class string, namespace string) {
+		MyBasePromMetrics.errors.With(prometheus.Labels{"class": class, "namespace": namespace}).Inc()
+	}
+
 	if activateObserveProcessingTime {
 		MyBasePromMetrics.ObserveProcessingTime = func(t time.Duration) {
 			go MyBasePromMetrics.processTime.Observe(float64(t / time.Microsecond))
@@ -64,7 +135,18 @@ func registerBasePromMetrics(activateObserveProcessingTime bool) {
 	reg.MustRegister(MyBasePromMetrics.groupsGauge)
 	reg.MustRegister(MyBasePromMetrics.namespacesGauge)
 	reg.MustRegister(MyBasePromMetrics.processedMsg)
+	reg.MustRegister(MyBasePromMetrics.readMsg)
+	reg.MustRegister(MyBasePromMetrics.publishLag)
+	reg.MustRegister(MyBasePromMetrics.eventLag)
+	reg.MustRegister(MyBasePromMetrics.nackedMsg)
+	reg.MustRegister(MyBasePromMetrics.ackRetries)
+	reg.MustRegister(MyBasePromMetrics.ackFailures)
 	reg.MustRegister(MyBasePromMetrics.filteredMsg)
+	reg.MustRegister(MyBasePromMetrics.expiredSeries)
+	reg.MustRegister(MyBasePromMetrics.overflowUpdates)
+	reg.MustRegister(MyBasePromMetrics.lastEventTime)
+	reg.MustRegister(MyBasePromMetrics.hostLastEvent)
+	reg.MustRegister(MyBasePromMetrics.errors)
 
 	if activateObserveProcessingTime {
 		reg.MustRegister(MyBasePromMetrics.filterTime)
@@ -92,12 +174,78 @@ var MyBasePromMetrics *BasePromMetrics = &BasePromMetrics{
 			Help: "The total number of processed messages from pulsar.",
 		},
 	),
+	readMsg: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "read_messages",
+			Help: "The number of messages read from a pulsar topic partition.",
+		}, []string{"topic"},
+	),
+	publishLag: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "publish_lag_seconds",
+			Help: "The time between the publication and the processing of the last message of a topic partition (s)",
+		}, []string{"topic"},
+	),
+	eventLag: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "event_lag_seconds",
+			Help: "The time between the event time and the processing of the last event of a topic partition, for RFC 3339 event times (s)",
+		}, []string{"topic"},
+	),
+	nackedMsg: prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nacked_messages",
+			Help: "The number of messages nacked because a sink failed to accept their events.",
+		},
+	),
+	ackRetries: prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ack_retries",
+			Help: "The number of retried acks after a failure.",
+		},
+	),
+	ackFailures: prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ack_failures",
+			Help: "The number of acks given up after all retries.",
+		},
+	),
 	filteredMsg: prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "filtered_messages",
 			Help: "The number of metrics generated per namespace",
 		}, []string{"namespace"},
 	),
+	expiredSeries: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "expired_series",
+			Help: "The number of series deleted for not being updated within their ttl",
+		}, []string{"metric"},
+	),
+	overflowUpdates: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "overflow_updates",
+			Help: "The number of updates folded into the overflow series for exceeding the series limits",
+		}, []string{"namespace", "metric"},
+	),
+	lastEventTime: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "namespace_last_event_time",
+			Help: "The unix time of the last event of a namespace (s)",
+		}, []string{"namespace"},
+	),
+	hostLastEvent: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hostname_last_event_time",
+			Help: "The unix time of the last event of a hostname in a namespace (s)",
+		}, []string{"namespace", "hostname"},
+	),
+	errors: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "processing_errors",
+			Help: "The number of errors while processing the messages, by class and namespace (empty when unknown).",
+		}, []string{"class", "namespace"},
+	),
 	filterTime: prometheus.NewSummary(
 		prometheus.SummaryOpts{
 			Name:       "filter_time",
@@ -123,6 +271,42 @@ var MyBasePromMetrics *BasePromMetrics = &BasePromMetrics{
 
 var reg *prometheus.Registry = prometheus.NewRegistry()
 
+// MustRegister registers collectors of other packages into the registry exposed on /metrics
+func MustRegister(collectors ...prometheus.Collector) {
+	reg.MustRegister(collectors...)
+}
+
+// Registerer lets the pulsar clients register their own metrics
+func Registerer() prometheus.Registerer {
+	return reg
+}
+
+// RegisterChannel exposes the occupancy of a channel
+func RegisterChannel(name string, length func() int, capacity int) {
+	labels := prometheus.Labels{"channel": name}
+	reg.MustRegister(
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name:        "channel_messages",
+				Help:        "The number of messages waiting in a channel",
+				ConstLabels: labels,
+			}, func() float64 { return float64(length()) },
+		),
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name:        "channel_capacity",
+				Help:        "The capacity of a channel",
+				ConstLabels: labels,
+			}, func() float64 { return float64(capacity) },
+		),
+	)
+}
+
+// Gatherer gives access to the registry for the outputs that push its content
+func Gatherer() prometheus.Gatherer {
+	return reg
+}
+
 func SetupPrometheus(activateObserveProcessingTime bool) {
 	initBasePromMetricsHandlers(activateObserveProcessingTime)
 	registerBasePromMetrics(activateObserveProcessingTime)
@@ -136,4 +320,6 @@ func SetupPrometheus(activateObserveProcessingTime bool) {
 			},
 		),
 	)
+	http.HandleFunc("/metrics/filter", filterHandler)
+	http.HandleFunc("/metrics/{label}/{value}", scopeHandler)
 }
@@ -0,0 +1,177 @@
+package report
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"example.com/streaming-metrics/src/prom"
+)
+
+// relative accuracy of the percentiles
+const sketchGamma = 1.02
+
+/*
+ * sketch estimates the percentiles of the values with logarithmic buckets,
+ * the estimate is within 1% of the value
+ */
+
+type sketch struct {
+	buckets  map[int]uint64
+	zeros    uint64
+	negative uint64
+	count    uint64
+}
+
+func newSketch() *sketch {
+	return &sketch{buckets: make(map[int]uint64)}
+}
+
+func (s *sketch) add(value float64) {
+	s.count++
+
+	switch {
+	case value < 0:
+		// latencies are positive, the negative values only count
+		s.negative++
+	case value == 0:
+		s.zeros++
+	default:
+		s.buckets[int(math.Ceil(math.Log(value)/math.Log(sketchGamma)))]++
+	}
+}
+
+func (s *sketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(s.count)))
+	seen := s.negative + s.zeros
+	if rank <= seen {
+		return 0
+	}
+
+	indexes := make([]int, 0, len(s.buckets))
+	for index := range s.buckets {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		seen += s.buckets[index]
+		if seen >= rank {
+			// middle of the bucket ]gamma^(i-1), gamma^i], its digits past the accuracy dropped
+			return roundSignificant(2*math.Pow(sketchGamma, float64(index))/(1+sketchGamma), 4)
+		}
+	}
+
+	return 0
+}
+
+func roundSignificant(value float64, digits int) float64 {
+	scale := math.Pow(10, float64(digits)-math.Ceil(math.Log10(value)))
+	return math.Round(value*scale) / scale
+}
+
+type metricAggregate struct {
+	kind  string
+	count uint64
+	sum   float64
+	min   float64
+	max   float64
+	dist  *sketch
+}
+
+func (aggregate *metricAggregate) add(value float64) {
+	if aggregate.count == 0 {
+		aggregate.min = value
+		aggregate.max = value
+	}
+
+	aggregate.count++
+	aggregate.sum += value
+	aggregate.min = math.Min(aggregate.min, value)
+	aggregate.max = math.Max(aggregate.max, value)
+
+	if aggregate.dist != nil {
+		aggregate.dist.add(value)
+	}
+}
+
+type namespaceAggregate struct {
+	service string
+	group   string
+	events  uint64
+	metrics map[string]*metricAggregate
+}
+
+/*
+ * aggregator accumulates the events of the current period per namespace
+ */
+
+type aggregator struct {
+	mu         sync.Mutex
+	namespaces map[string]*namespaceAggregate
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{namespaces: make(map[string]*namespaceAggregate)}
+}
+
+func (a *aggregator) add(namespace string, service string, group string, kinds map[string]*prom.Metric, metrics map[string]any) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	aggregate, exists := a.namespaces[namespace]
+	if !exists {
+		aggregate = &namespaceAggregate{
+			service: service,
+			group:   group,
+			metrics: make(map[string]*metricAggregate),
+		}
+		a.namespaces[namespace] = aggregate
+	}
+	aggregate.events++
+
+	for name, value := range metrics {
+		metricValue, ok := toFloat64(value)
+		if !ok {
+			continue
+		}
+
+		metric, exists := aggregate.metrics[name]
+		if !exists {
+			metric = &metricAggregate{}
+			if kind, exists := kinds[name]; exists {
+				metric.kind = kind.Type
+			}
+			if metric.kind == "histogram" || metric.kind == "summary" {
+				metric.dist = newSketch()
+			}
+			aggregate.metrics[name] = metric
+		}
+		metric.add(metricValue)
+	}
+}
+
+// reset starts a new period, returning the aggregates of the one that ended
+func (a *aggregator) reset() map[string]*namespaceAggregate {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	namespaces := a.namespaces
+	a.namespaces = make(map[string]*namespaceAggregate)
+	return namespaces
+}
+
+func toFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,68 @@
+package report
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSketchQuantiles(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name   string
+		values func(i int) float64
+	}{
+		{"uniform", func(i int) float64 { return 1 + random.Float64()*999 }},
+		{"exponential", func(i int) float64 { return random.ExpFloat64() * 50 }},
+		{"lognormal", func(i int) float64 { return math.Exp(random.NormFloat64() * 2) }},
+		{"constant", func(i int) float64 { return 42 }},
+		{"sequence", func(i int) float64 { return float64(i + 1) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newSketch()
+			values := make([]float64, 10000)
+			for i := range values {
+				values[i] = test.values(i)
+				s.add(values[i])
+			}
+			sort.Float64s(values)
+
+			for _, q := range []float64{0.5, 0.9, 0.95, 0.99, 1} {
+				exact := values[int(math.Ceil(q*float64(len(values))))-1]
+				estimate := s.quantile(q)
+				if math.Abs(estimate-exact)/exact > 0.011 {
+					t.Errorf("q%v: expected %v within 1%%, got %v", q, exact, estimate)
+				}
+			}
+		})
+	}
+}
+
+func TestSketchEdgeCases(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		q        float64
+		expected float64
+	}{
+		{"empty", nil, 0.5, 0},
+		{"zeros", []float64{0, 0, 0, 5}, 0.5, 0},
+		{"negative", []float64{-1, -2, 3}, 0.5, 0},
+		{"above zeros", []float64{0, 10, 10, 10}, 0.5, 10},
+	}
+
+	for _, test := range tests {
+		s := newSketch()
+		for _, value := range test.values {
+			s.add(value)
+		}
+
+		if estimate := s.quantile(test.q); math.Abs(estimate-test.expected) > test.expected*0.01 {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, estimate)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// extensions of the report files per format
+var formatExtensions = map[string]string{
+	"csv":      "csv",
+	"json":     "json",
+	"markdown": "md",
+}
+
+func encode(report *Report, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(report, "", "  ")
+	case "csv":
+		return encodeCSV(report)
+	case "markdown":
+		return encodeMarkdown(report), nil
+	default:
+		return nil, fmt.Errorf("unknown report format: %s", format)
+	}
+}
+
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func sortedNames[V any](values map[string]V) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// summaryRows flattens a summary as rows of: metric, stat, value
+func summaryRows(summary Summary) [][]string {
+	rows := [][]string{{"", "events", strconv.FormatUint(summary.Events, 10)}}
+	for _, name := range sortedNames(summary.Totals) {
+		rows = append(rows, []string{name, "total", formatNumber(summary.Totals[name])})
+	}
+	if summary.ErrorRatio != nil {
+		rows = append(rows, []string{"", "error_ratio", formatNumber(*summary.ErrorRatio)})
+	}
+	return rows
+}
+
+// encodeCSV writes one row per value: level, service, group, namespace, metric, stat, value
+func encodeCSV(report *Report) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	from, to := report.From.Format(time.RFC3339), report.To.Format(time.RFC3339)
+	write := func(level string, service string, group string, namespace string, rows [][]string) {
+		for _, row := range rows {
+			writer.Write(append([]string{from, to, level, service, group, namespace}, row...))
+		}
+	}
+
+	writer.Write([]string{"from", "to", "level", "service", "group", "namespace", "metric", "stat", "value"})
+	for _, service := range report.Services {
+		write("service", service.Service, "", "", summaryRows(service.Summary))
+
+		for _, group := range service.Groups {
+			write("group", service.Service, group.Group, "", summaryRows(group.Summary))
+
+			for _, namespace := range group.Namespaces {
+				rows := summaryRows(namespace.Summary)
+				for _, name := range sortedNames(namespace.Latencies) {
+					latency := namespace.Latencies[name]
+					rows = append(rows,
+						[]string{name, "count", strconv.FormatUint(latency.Count, 10)},
+						[]string{name, "p50", formatNumber(latency.P50)},
+						[]string{name, "p90", formatNumber(latency.P90)},
+						[]string{name, "p99", formatNumber(latency.P99)},
+						[]string{name, "max", formatNumber(latency.Max)},
+					)
+				}
+				write("namespace", service.Service, group.Group, namespace.Namespace, rows)
+			}
+		}
+	}
+
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func formatRatio(ratio *float64) string {
+	if ratio == nil {
+		return "-"
+	}
+	return strconv.FormatFloat(*ratio*100, 'f', 2, 64) + "%"
+}
+
+func formatTotals(totals map[string]float64) string {
+	parts := make([]string, 0, len(totals))
+	for _, name := range sortedNames(totals) {
+		parts = append(parts, name+"="+formatNumber(totals[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func markdownEscape(value string) string {
+	return strings.ReplaceAll(value, "|", `\|`)
+}
+
+func encodeMarkdown(report *Report) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# Report %s - %s\n", report.From.Format(time.RFC3339), report.To.Format(time.RFC3339))
+
+	for _, service := range report.Services {
+		fmt.Fprintf(&buf, "\n## %s\n\n", markdownEscape(service.Service))
+		fmt.Fprintf(&buf, "Events: %d, error ratio: %s\n\n", service.Events, formatRatio(service.ErrorRatio))
+		fmt.Fprintf(&buf, "Totals: %s\n", markdownEscape(formatTotals(service.Totals)))
+
+		for _, group := range service.Groups {
+			fmt.Fprintf(&buf, "\n### %s\n\n", markdownEscape(group.Group))
+			fmt.Fprintf(&buf, "Events: %d, error ratio: %s\n\n", group.Events, formatRatio(group.ErrorRatio))
+
+			buf.WriteString("| Namespace | Events | Error ratio | Totals |\n|---|---:|---:|---|\n")
+			for _, namespace := range group.Namespaces {
+				fmt.Fprintf(&buf, "| %s | %d | %s | %s |\n",
+					markdownEscape(namespace.Namespace), namespace.Events, formatRatio(namespace.ErrorRatio), markdownEscape(formatTotals(namespace.Totals)))
+			}
+
+			latencies := false
+			for _, namespace := range group.Namespaces {
+				for _, name := range sortedNames(namespace.Latencies) {
+					if !latencies {
+						buf.WriteString("\n| Namespace | Metric | Count | p50 | p90 | p99 | Max |\n|---|---|---:|---:|---:|---:|---:|\n")
+						latencies = true
+					}
+
+					latency := namespace.Latencies[name]
+					fmt.Fprintf(&buf, "| %s | %s | %d | %s | %s | %s | %s |\n",
+						markdownEscape(namespace.Namespace), markdownEscape(name), latency.Count,
+						formatNumber(latency.P50), formatNumber(latency.P90), formatNumber(latency.P99), formatNumber(latency.Max))
+				}
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
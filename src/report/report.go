@@ -0,0 +1,144 @@
+package report
+
+import (
+	"sort"
+	"time"
+)
+
+type Percentiles struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Summary is shared by every level of the report, the totals are the sums of the counters
+type Summary struct {
+	Events     uint64             `json:"events"`
+	Totals     map[string]float64 `json:"totals"`
+	ErrorRatio *float64           `json:"error_ratio,omitempty"`
+
+	errors float64
+	total  float64
+}
+
+type NamespaceReport struct {
+	Namespace string `json:"namespace"`
+	Summary
+	Latencies map[string]Percentiles `json:"latencies,omitempty"`
+}
+
+type GroupReport struct {
+	Group string `json:"group"`
+	Summary
+	Namespaces []*NamespaceReport `json:"namespaces"`
+}
+
+type ServiceReport struct {
+	Service string `json:"service"`
+	Summary
+	Groups []*GroupReport `json:"groups"`
+}
+
+type Report struct {
+	From     time.Time        `json:"from"`
+	To       time.Time        `json:"to"`
+	Services []*ServiceReport `json:"services"`
+}
+
+func newSummary() Summary {
+	return Summary{Totals: make(map[string]float64)}
+}
+
+// merge adds the totals and errors of a lower level
+func (summary *Summary) merge(other Summary) {
+	summary.Events += other.Events
+	for name, total := range other.Totals {
+		summary.Totals[name] += total
+	}
+	summary.errors += other.errors
+	summary.total += other.total
+}
+
+func (summary *Summary) ratio() {
+	if summary.total > 0 {
+		ratio := summary.errors / summary.total
+		summary.ErrorRatio = &ratio
+	}
+}
+
+// buildReport groups the aggregates of the period by service and group, sorted by name
+func buildReport(namespaces map[string]*namespaceAggregate, from time.Time, to time.Time, errorMetrics []string, totalMetric string) *Report {
+	services := make(map[string]*ServiceReport)
+	groups := make(map[string]*GroupReport)
+
+	for name, aggregate := range namespaces {
+		namespace := &NamespaceReport{
+			Namespace: name,
+			Summary:   newSummary(),
+			Latencies: make(map[string]Percentiles),
+		}
+		namespace.Events = aggregate.events
+
+		for metricName, metric := range aggregate.metrics {
+			if metric.kind == "counter" {
+				namespace.Totals[metricName] = metric.sum
+			}
+
+			if metric.dist != nil {
+				namespace.Latencies[metricName] = Percentiles{
+					Count: metric.count,
+					P50:   metric.dist.quantile(0.5),
+					P90:   metric.dist.quantile(0.9),
+					P99:   metric.dist.quantile(0.99),
+					Max:   metric.max,
+				}
+			}
+		}
+
+		for _, errorMetric := range errorMetrics {
+			if metric, exists := aggregate.metrics[errorMetric]; exists {
+				namespace.errors += metric.sum
+			}
+		}
+		if metric, exists := aggregate.metrics[totalMetric]; exists {
+			namespace.total = metric.sum
+		}
+		namespace.ratio()
+
+		service, exists := services[aggregate.service]
+		if !exists {
+			service = &ServiceReport{Service: aggregate.service, Summary: newSummary()}
+			services[aggregate.service] = service
+		}
+
+		groupKey := aggregate.service + "\xff" + aggregate.group
+		group, exists := groups[groupKey]
+		if !exists {
+			group = &GroupReport{Group: aggregate.group, Summary: newSummary()}
+			groups[groupKey] = group
+			service.Groups = append(service.Groups, group)
+		}
+
+		group.Namespaces = append(group.Namespaces, namespace)
+		group.merge(namespace.Summary)
+		service.merge(namespace.Summary)
+	}
+
+	report := &Report{From: from, To: to, Services: make([]*ServiceReport, 0, len(services))}
+	for _, service := range services {
+		service.ratio()
+		sort.Slice(service.Groups, func(i, j int) bool { return service.Groups[i].Group < service.Groups[j].Group })
+
+		for _, group := range service.Groups {
+			group.ratio()
+			sort.Slice(group.Namespaces, func(i, j int) bool { return group.Namespaces[i].Namespace < group.Namespaces[j].Namespace })
+		}
+
+		report.Services = append(report.Services, service)
+	}
+	sort.Slice(report.Services, func(i, j int) bool { return report.Services[i].Service < report.Services[j].Service })
+
+	return report
+}
@@ -0,0 +1,161 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/sirupsen/logrus"
+
+	"example.com/streaming-metrics/src/flow"
+)
+
+const publishTimeout = 30 * time.Second
+
+type Options struct {
+	Schedule     string
+	Format       string // csv, json or markdown
+	Dir          string
+	Topic        string
+	ErrorMetrics []string // summed as the errors of the error ratio
+	TotalMetric  string
+}
+
+/*
+ * Reporter aggregates the events between two times of its schedule, then writes
+ * the report of the period to a directory and/or publishes it to a topic
+ */
+
+type Reporter struct {
+	schedule     *Schedule
+	format       string
+	dir          string
+	producer     pulsar.Producer
+	errorMetrics []string
+	totalMetric  string
+
+	aggregator *aggregator
+	from       time.Time
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func NewReporter(options Options, client pulsar.Client) (*Reporter, error) {
+	schedule, err := ParseSchedule(options.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := formatExtensions[options.Format]; !ok {
+		return nil, fmt.Errorf("unknown report format: %s", options.Format)
+	}
+
+	reporter := &Reporter{
+		schedule:     schedule,
+		format:       options.Format,
+		dir:          options.Dir,
+		errorMetrics: options.ErrorMetrics,
+		totalMetric:  options.TotalMetric,
+		aggregator:   newAggregator(),
+		from:         time.Now(),
+		stop:         make(chan struct{}),
+	}
+
+	if len(options.Dir) > 0 {
+		if err := os.MkdirAll(options.Dir, 0755); err != nil {
+			return nil, fmt.Errorf("report dir: %w", err)
+		}
+	}
+
+	if len(options.Topic) > 0 {
+		reporter.producer, err = client.CreateProducer(pulsar.ProducerOptions{
+			Topic: options.Topic,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("report topic: %w", err)
+		}
+	}
+
+	reporter.done.Add(1)
+	go reporter.scheduleLoop()
+
+	return reporter, nil
+}
+
+func (reporter *Reporter) Push(namespace *flow.Namespace, hostname string, event flow.Event, msg pulsar.ConsumerMessage) {
+	reporter.aggregator.add(namespace.Name, namespace.Service, namespace.Group, namespace.Metrics, event.Metrics())
+}
+
+func (reporter *Reporter) scheduleLoop() {
+	defer reporter.done.Done()
+
+	for {
+		next, ok := reporter.schedule.Next(time.Now())
+		if !ok {
+			logrus.Errorf("report schedule: no run within %d years, stopping the reports", scheduleCycleYears)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-timer.C:
+			reporter.report(next)
+		case <-reporter.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// report ends the period at to
+func (reporter *Reporter) report(to time.Time) {
+	report := buildReport(reporter.aggregator.reset(), reporter.from, to, reporter.errorMetrics, reporter.totalMetric)
+	reporter.from = to
+
+	payload, err := encode(report, reporter.format)
+	if err != nil {
+		logrus.Errorf("report encode: %+v", err)
+		return
+	}
+
+	if len(reporter.dir) > 0 {
+		name := fmt.Sprintf("report-%s.%s", to.Format("20060102T1504"), formatExtensions[reporter.format])
+		if err := os.WriteFile(filepath.Join(reporter.dir, name), payload, 0644); err != nil {
+			logrus.Errorf("report write %s: %+v", name, err)
+		} else {
+			logrus.Infof("wrote report %s", name)
+		}
+	}
+
+	if reporter.producer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		defer cancel()
+
+		_, err := reporter.producer.Send(ctx, &pulsar.ProducerMessage{
+			Payload: payload,
+			Properties: map[string]string{
+				"format": reporter.format,
+				"from":   report.From.Format(time.RFC3339),
+				"to":     report.To.Format(time.RFC3339),
+			},
+		})
+		if err != nil {
+			logrus.Errorf("report publish: %+v", err)
+		}
+	}
+}
+
+// Close drops the current period, its report would be partial
+func (reporter *Reporter) Close() {
+	close(reporter.stop)
+	reporter.done.Wait()
+
+	if reporter.producer != nil {
+		reporter.producer.Close()
+	}
+}
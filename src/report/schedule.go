@@ -0,0 +1,154 @@
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the days of week and the leap years repeat every 28 years, until 2100
+const scheduleCycleYears = 28
+
+var scheduleCycleStart = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var scheduleAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+/*
+ * Schedule is a cron expression: minute hour day-of-month month day-of-week,
+ * each field a *, a value or a range a-b, optionally with a step /n, or a list of those.
+ * As in cron, when both the day of month and the day of week are restricted (not
+ * starting with *), a day matches either of them: "0 0 1 * 1" runs on the 1st and on Mondays
+ */
+
+type Schedule struct {
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+
+	// the day fields starting with *, a restricted field alone decides the day
+	anyDay     bool
+	anyWeekday bool
+}
+
+func ParseSchedule(expression string) (*Schedule, error) {
+	if alias, ok := scheduleAliases[expression]; ok {
+		expression = alias
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: expected 5 fields", expression)
+	}
+
+	var schedule Schedule
+	ranges := []struct {
+		set []bool
+		min int
+		max int
+	}{
+		{schedule.minutes[:], 0, 59},
+		{schedule.hours[:], 0, 23},
+		{schedule.days[:], 1, 31},
+		{schedule.months[:], 1, 12},
+		{schedule.weekdays[:], 0, 6},
+	}
+
+	for i, field := range fields {
+		if err := parseField(field, ranges[i].set, ranges[i].min, ranges[i].max); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", expression, err)
+		}
+	}
+
+	schedule.anyDay = strings.HasPrefix(fields[2], "*")
+	schedule.anyWeekday = strings.HasPrefix(fields[4], "*")
+
+	// the calendar repeats within a cycle, a schedule matching no day of it never runs
+	if _, ok := schedule.Next(scheduleCycleStart); !ok {
+		return nil, fmt.Errorf("schedule %q: never matches", expression)
+	}
+
+	return &schedule, nil
+}
+
+func parseField(field string, set []bool, min int, max int) error {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if value, stepPart, found := strings.Cut(part, "/"); found {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("bad step %q", part)
+			}
+			step = n
+			part = value
+		}
+
+		from, to := min, max
+		if part != "*" {
+			value, end, isRange := strings.Cut(part, "-")
+
+			var err error
+			if from, err = strconv.Atoi(value); err != nil {
+				return fmt.Errorf("bad value %q", part)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(end); err != nil {
+					return fmt.Errorf("bad range %q", part)
+				}
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return fmt.Errorf("%q out of range [%d, %d]", part, min, max)
+		}
+
+		for i := from; i <= to; i += step {
+			set[i] = true
+		}
+	}
+
+	return nil
+}
+
+func (schedule *Schedule) matches(t time.Time) bool {
+	return schedule.minutes[t.Minute()] &&
+		schedule.hours[t.Hour()] &&
+		schedule.months[t.Month()] &&
+		schedule.matchesDay(t)
+}
+
+func (schedule *Schedule) matchesDay(t time.Time) bool {
+	day, weekday := schedule.days[t.Day()], schedule.weekdays[t.Weekday()]
+	if schedule.anyDay || schedule.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+// Next is the first matching minute after t, false when none is within a calendar cycle
+func (schedule *Schedule) Next(t time.Time) (time.Time, bool) {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(scheduleCycleYears, 0, 0)
+
+	for next.Before(limit) {
+		if !schedule.months[next.Month()] || !schedule.matchesDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if schedule.matches(next) {
+			return next, true
+		}
+		next = next.Add(time.Minute)
+	}
+
+	return time.Time{}, false
+}
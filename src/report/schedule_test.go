@@ -0,0 +1,82 @@
+package report
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expression string
+		valid      bool
+	}{
+		{"@daily", true},
+		{"@hourly", true},
+		{"*/15 * * * *", true},
+		{"0 8-18/2 * * 1-5", true},
+		{"0,30 0 1,15 * *", true},
+		{"0 0 * * 0", true},
+		{"", false},
+		{"0 0 * *", false},
+		{"0 0 * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 7", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+		{"@yearly", false},
+		{"0 0 29 2 *", true},
+		{"0 0 31 2 1", true},
+		{"0 0 29 2 */7", true},
+		{"0 0 30 2 *", false},
+		{"0 0 31 2 *", false},
+		{"0 0 31 4,6,9,11 *", false},
+	}
+
+	for _, test := range tests {
+		_, err := ParseSchedule(test.expression)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseSchedule(%q): expected valid %v, got %v", test.expression, test.valid, err)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, time.May, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expression string
+		next       time.Time
+	}{
+		{"@hourly", time.Date(2024, time.May, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, time.May, 19, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.May, 15, 10, 15, 0, 0, time.UTC)},
+		{"* * * * *", time.Date(2024, time.May, 15, 10, 8, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2024, time.May, 16, 9, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// day of month or weekday when both are restricted: the 1st or a Monday
+		{"0 0 1 * 1", time.Date(2024, time.May, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 16 * 1", time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		// a day field starting with * makes both apply: a Monday that is the 1st, 11th, 21st or 31st
+		{"0 0 */10 * 1", time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC)},
+		// a leap day on a Sunday, 8 years away
+		{"0 0 29 2 */7", time.Date(2032, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.expression)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %v", test.expression, err)
+		}
+
+		if next, ok := schedule.Next(from); !ok || !next.Equal(test.next) {
+			t.Errorf("%q: expected next %v, got %v", test.expression, test.next, next)
+		}
+	}
+}